@@ -54,12 +54,17 @@ func init() {
 	gob.Register(addBurstEvent{})
 }
 
+func (e addBurstEvent) Player() game.Gid {
+	return e.PlayerGid
+}
+
 func (e addBurstEvent) Apply(_g interface{}) {
 	g := _g.(*game.Game)
 	player, ok := g.Ents[e.PlayerGid].(*game.PlayerEnt)
 	if !ok {
 		return
 	}
+	player.Reveal(game.RevealOnCastFrames)
 	initial := game.Mana{math.Pow(float64(e.Force)*float64(e.Frames), 2) / 1.0e7, 0, 0}
 	player.Processes[100+e.Id] = &burstProcess{
 		Frames:            int32(e.Frames),
@@ -152,7 +157,7 @@ func (p *burstProcess) Think(g *game.Game) {
 				dist = 1
 			}
 			force := p.Force / dist
-			other.ApplyForce(other.Pos().Sub(player.Pos()).Norm().Scale(force))
+			g.Knockback(other, other.Pos().Sub(player.Pos()).Norm().Scale(force))
 		})
 	}
 }
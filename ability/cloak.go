@@ -57,6 +57,10 @@ func init() {
 	gob.Register(addCloakEvent{})
 }
 
+func (e addCloakEvent) Player() game.Gid {
+	return e.PlayerGid
+}
+
 func (e addCloakEvent) Apply(_g interface{}) {
 	g := _g.(*game.Game)
 	player, ok := g.Ents[e.PlayerGid].(*game.PlayerEnt)
@@ -86,6 +90,10 @@ func init() {
 	gob.Register(removeCloakEvent{})
 }
 
+func (e removeCloakEvent) Player() game.Gid {
+	return e.PlayerGid
+}
+
 func (e removeCloakEvent) Apply(_g interface{}) {
 	g := _g.(*game.Game)
 	player, ok := g.Ents[e.PlayerGid].(*game.PlayerEnt)
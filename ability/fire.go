@@ -62,12 +62,17 @@ func init() {
 	gob.Register(addFireEvent{})
 }
 
+func (e addFireEvent) Player() game.Gid {
+	return e.PlayerGid
+}
+
 func (e addFireEvent) Apply(_g interface{}) {
 	g := _g.(*game.Game)
 	player, ok := g.Ents[e.PlayerGid].(*game.PlayerEnt)
 	if !ok {
 		return
 	}
+	player.Reveal(game.RevealOnCastFrames)
 	pos := player.Position.Add((linear.Vec2{40, 0}).Rotate(player.Angle))
 	player.Processes[100+e.Id] = &fireProcess{
 		BasicPhases: BasicPhases{game.PhaseRunning},
@@ -153,6 +158,10 @@ type fireProcess struct {
 	rng        *cmwc.Cmwc
 }
 
+func init() {
+	gob.Register(&fireProcess{})
+}
+
 func (f *fireProcess) Supply(supply game.Mana) game.Mana {
 	f.Stored += supply[game.ColorRed]
 	supply[game.ColorRed] = 0
@@ -168,7 +177,7 @@ func (f *fireProcess) Think(g *game.Game) {
 	f.Stored *= 0.97
 	if f.rng == nil {
 		f.rng = cmwc.MakeGoodCmwc()
-		f.rng.SeedWithDevRand()
+		f.rng.Seed(g.Rng.Int63())
 	}
 	max := int(f.Stored / 15)
 	algorithm.Choose(&f.explosions, func(e fireExplosion) bool { return !e.Done() })
@@ -231,15 +240,26 @@ func init() {
 	gob.Register(addFireExplodeEvent{})
 }
 
+func (e addFireExplodeEvent) Player() game.Gid {
+	return e.PlayerGid
+}
+
 func (e addFireExplodeEvent) Apply(_g interface{}) {
 	g := _g.(*game.Game)
 	player, ok := g.Ents[e.PlayerGid].(*game.PlayerEnt)
 	if !ok {
 		return
 	}
-	prevProc := player.Processes[100+e.Id].(*fireProcess)
+	player.Reveal(game.RevealOnCastFrames)
+	// The player may have died and respawned since the fire was started, in
+	// which case there is nothing left to explode.
+	prevProc, ok := player.Processes[100+e.Id].(*fireProcess)
+	if !ok {
+		return
+	}
 	var fpe fireProcessExplosion
 	fpe.The_phase = game.PhaseRunning
+	fpe.Side = player.Side()
 	delete(player.Processes, 100+e.Id)
 	if int(prevProc.Stored/10) == 0 {
 		return
@@ -255,9 +275,14 @@ func (e addFireExplodeEvent) Apply(_g interface{}) {
 type fireProcessExplosion struct {
 	BasicPhases
 	NullCondition
+	Side       int
 	Explosions []fireExplosion
 }
 
+func init() {
+	gob.Register(&fireProcessExplosion{})
+}
+
 func (f *fireProcessExplosion) Supply(supply game.Mana) game.Mana {
 	return supply
 }
@@ -269,7 +294,7 @@ func (f *fireProcessExplosion) Think(g *game.Game) {
 				continue
 			}
 			if expl.Pos.Sub(ent.Pos()).Mag() <= expl.Size() {
-				ent.Stats().ApplyDamage(stats.Damage{stats.DamageFire, 1})
+				g.DealDamage(f.Side, ent, game.DamageSourceAbility, stats.Damage{stats.DamageFire, 1})
 			}
 		}
 	})
@@ -285,6 +310,14 @@ func (f *fireProcessExplosion) Think(g *game.Game) {
 	}
 }
 
+func (f *fireProcessExplosion) Location() (int, []linear.Vec2) {
+	var where []linear.Vec2
+	for _, expl := range f.Explosions {
+		where = append(where, expl.Pos)
+	}
+	return f.Side, where
+}
+
 func (f *fireProcessExplosion) Draw(gid game.Gid, g *game.Game, side int) {
 	base.EnableShader("circle")
 	base.SetUniformF("circle", "edge", 0.7)
@@ -82,6 +82,10 @@ type nullSphereCastProcess struct {
 	targetGid game.Gid
 }
 
+func init() {
+	gob.Register(&nullSphereCastProcess{})
+}
+
 func (p *nullSphereCastProcess) Supply(supply game.Mana) game.Mana {
 	p.Stored[game.ColorBlue] += supply[game.ColorBlue]
 	supply[game.ColorBlue] = 0
@@ -163,12 +167,17 @@ func init() {
 	gob.Register(addNullSphereCastProcessEvent{})
 }
 
+func (e addNullSphereCastProcessEvent) Player() game.Gid {
+	return e.PlayerGid
+}
+
 func (e addNullSphereCastProcessEvent) Apply(_g interface{}) {
 	g := _g.(*game.Game)
 	player, ok := g.Ents[e.PlayerGid].(*game.PlayerEnt)
 	if !ok {
 		return
 	}
+	player.Reveal(game.RevealOnCastFrames)
 	player.Processes[100+e.ProcessId] = &nullSphereCastProcess{
 		PlayerGid: e.PlayerGid,
 		Cost:      e.Cost,
@@ -185,6 +194,10 @@ func init() {
 	gob.Register(removeNullSphereCastProcessEvent{})
 }
 
+func (e removeNullSphereCastProcessEvent) Player() game.Gid {
+	return e.PlayerGid
+}
+
 func (e removeNullSphereCastProcessEvent) Apply(_g interface{}) {
 	g := _g.(*game.Game)
 	player, ok := g.Ents[e.PlayerGid].(*game.PlayerEnt)
@@ -222,6 +235,10 @@ func nullSphereTarget(g *game.Game, player *game.PlayerEnt) game.Ent {
 			// Only target players that we have los to
 			return
 		}
+		if !g.IsVisibleTo(ent, player.Side()) {
+			// Or that are cloaked
+			return
+		}
 		distSq := player.Pos().Sub(ent.Pos()).Mag2()
 		if distSq <= bestDistSq {
 			bestDistSq = distSq
@@ -231,6 +248,10 @@ func nullSphereTarget(g *game.Game, player *game.PlayerEnt) game.Ent {
 	return bestEnt
 }
 
+func (e addNullSphereFireEvent) Player() game.Gid {
+	return e.PlayerGid
+}
+
 func (e addNullSphereFireEvent) Apply(_g interface{}) {
 	g := _g.(*game.Game)
 	player, ok := g.Ents[e.PlayerGid].(*game.PlayerEnt)
@@ -256,6 +277,8 @@ func (e addNullSphereFireEvent) Apply(_g interface{}) {
 	nsProc.Stored[game.ColorBlue] -= nsProc.Cost
 	size := player.Stats().Size()
 	g.MakeHeatSeeker(
+		player.Id(),
+		player.Side(),
 		player.Pos().Add(target.Pos().Sub(player.Pos()).Norm().Scale(player.Stats().Size()+size)),
 		game.BaseEntParams{
 			Health: 100,
@@ -89,12 +89,17 @@ func init() {
 	gob.Register(addRiftWalkEvent{})
 }
 
+func (e addRiftWalkEvent) Player() game.Gid {
+	return e.PlayerGid
+}
+
 func (e addRiftWalkEvent) Apply(_g interface{}) {
 	g := _g.(*game.Game)
 	player, ok := g.Ents[e.PlayerGid].(*game.PlayerEnt)
 	if !ok {
 		return
 	}
+	player.Reveal(game.RevealOnCastFrames)
 	player.Processes[100+e.ProcessId] = &riftWalkProcess{
 		BasicPhases: ability.BasicPhases{game.PhaseRunning},
 		PlayerGid:   e.PlayerGid,
@@ -113,6 +118,10 @@ func init() {
 	gob.Register(removeRiftWalkEvent{})
 }
 
+func (e removeRiftWalkEvent) Player() game.Gid {
+	return e.PlayerGid
+}
+
 func (e removeRiftWalkEvent) Apply(_g interface{}) {
 	g := _g.(*game.Game)
 	player, ok := g.Ents[e.PlayerGid].(*game.PlayerEnt)
@@ -224,6 +233,10 @@ type addRiftWalkFireEvent struct {
 func init() {
 	gob.Register(addRiftWalkFireEvent{})
 }
+func (e addRiftWalkFireEvent) Player() game.Gid {
+	return e.PlayerGid
+}
+
 func (e addRiftWalkFireEvent) Apply(_g interface{}) {
 	g := _g.(*game.Game)
 	player, ok := g.Ents[e.PlayerGid].(*game.PlayerEnt)
@@ -289,7 +302,7 @@ func (e addRiftWalkFireEvent) Apply(_g interface{}) {
 		doDamage := false
 		if ent.Pos().Sub(dest).Mag() <= radius+ent.Stats().Size() {
 			angle := dest.Sub(ent.Pos()).Angle()
-			ent.ApplyForce((linear.Vec2{-rwProc.Force, 0}).Rotate(angle))
+			g.Knockback(ent, (linear.Vec2{-rwProc.Force, 0}).Rotate(angle))
 			doDamage = true
 		} else {
 			ray := dest.Sub(player.Pos())
@@ -304,16 +317,16 @@ func (e addRiftWalkFireEvent) Apply(_g interface{}) {
 				backward := ent.Pos().Sub(player.Pos())
 				if (forward.Dot(ray) < 0) != (backward.Dot(ray) < 0) {
 					if (linear.Seg2{player.Pos(), dest}).Left(ent.Pos()) {
-						ent.ApplyForce(perp.Scale(rwProc.Force))
+						g.Knockback(ent, perp.Scale(rwProc.Force))
 					} else {
-						ent.ApplyForce(perp.Scale(-rwProc.Force))
+						g.Knockback(ent, perp.Scale(-rwProc.Force))
 					}
 					doDamage = true
 				}
 			}
 		}
 		if doDamage {
-			ent.Stats().ApplyDamage(stats.Damage{stats.DamageFire, 50})
+			g.DealDamage(player.Side(), ent, game.DamageSourceAbility, stats.Damage{stats.DamageFire, 50})
 		}
 	}
 	player.SetPos(dest)
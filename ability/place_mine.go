@@ -93,6 +93,10 @@ type placeMineCastProcess struct {
 	Cost      float64
 }
 
+func init() {
+	gob.Register(&placeMineCastProcess{})
+}
+
 func (p *placeMineCastProcess) Supply(supply game.Mana) game.Mana {
 	p.Stored[game.ColorBlue] += supply[game.ColorBlue]
 	supply[game.ColorBlue] = 0
@@ -147,12 +151,17 @@ func init() {
 	gob.Register(addPlaceMineCastProcessEvent{})
 }
 
+func (e addPlaceMineCastProcessEvent) Player() game.Gid {
+	return e.PlayerGid
+}
+
 func (e addPlaceMineCastProcessEvent) Apply(_g interface{}) {
 	g := _g.(*game.Game)
 	player, ok := g.Ents[e.PlayerGid].(*game.PlayerEnt)
 	if !ok {
 		return
 	}
+	player.Reveal(game.RevealOnCastFrames)
 	player.Processes[100+e.ProcessId] = &placeMineCastProcess{
 		PlayerGid: e.PlayerGid,
 		Cost:      e.Cost,
@@ -169,6 +178,10 @@ func init() {
 	gob.Register(removePlaceMineCastProcessEvent{})
 }
 
+func (e removePlaceMineCastProcessEvent) Player() game.Gid {
+	return e.PlayerGid
+}
+
 func (e removePlaceMineCastProcessEvent) Apply(_g interface{}) {
 	g := _g.(*game.Game)
 	player, ok := g.Ents[e.PlayerGid].(*game.PlayerEnt)
@@ -194,6 +207,10 @@ func init() {
 	gob.Register(addPlaceMineFireEvent{})
 }
 
+func (e addPlaceMineFireEvent) Player() game.Gid {
+	return e.PlayerGid
+}
+
 func (e addPlaceMineFireEvent) Apply(_g interface{}) {
 	g := _g.(*game.Game)
 	player, ok := g.Ents[e.PlayerGid].(*game.PlayerEnt)
@@ -223,5 +240,5 @@ func (e addPlaceMineFireEvent) Apply(_g interface{}) {
 	pos := player.Position.Add((linear.Vec2{50, 0}).Rotate(angle + math.Pi))
 	rng := rand.New(g.Rng)
 	pos = pos.Add((linear.Vec2{rng.NormFloat64() * 15, 0}).Rotate(rng.Float64() * math.Pi * 2))
-	g.MakeMine(pos, player.Velocity.Scale(0.5), e.Health, e.Mass, e.Damage, e.Trigger)
+	g.MakeMine(player.Id(), player.Side(), pos, player.Velocity.Scale(0.5), e.Health, e.Mass, e.Damage, e.Trigger)
 }
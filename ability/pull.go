@@ -70,6 +70,10 @@ func init() {
 	gob.Register(addPullEvent{})
 }
 
+func (e addPullEvent) Player() game.Gid {
+	return e.PlayerGid
+}
+
 func (e addPullEvent) Apply(_g interface{}) {
 	g := _g.(*game.Game)
 	player, ok := g.Ents[e.PlayerGid].(*game.PlayerEnt)
@@ -83,6 +87,7 @@ func (e addPullEvent) Apply(_g interface{}) {
 		}
 		return
 	}
+	player.Reveal(game.RevealOnCastFrames)
 	player.Processes[100+e.Id] = &pullProcess{
 		BasicPhases: BasicPhases{game.PhaseRunning},
 		PlayerGid:   e.PlayerGid,
@@ -102,6 +107,10 @@ func init() {
 	gob.Register(removePullEvent{})
 }
 
+func (e removePullEvent) Player() game.Gid {
+	return e.PlayerGid
+}
+
 func (e removePullEvent) Apply(_g interface{}) {
 	g := _g.(*game.Game)
 	player, ok := g.Ents[e.PlayerGid].(*game.PlayerEnt)
@@ -178,8 +187,8 @@ func (p *pullProcess) Think(g *game.Game) {
 		// dist := ray.Mag()
 		ray = ray.Norm()
 		force := base_force // / math.Pow(dist, p.Angle/(2*math.Pi))
-		ent.ApplyForce(ray.Scale(-force))
-		player.ApplyForce(ray.Scale(force).Scale(0.01))
+		g.Knockback(ent, ray.Scale(-force))
+		g.Knockback(player, ray.Scale(force).Scale(0.01))
 	})
 }
 
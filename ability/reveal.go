@@ -0,0 +1,67 @@
+package ability
+
+import (
+	"encoding/gob"
+	"github.com/runningwild/cgf"
+	"github.com/runningwild/magnus/game"
+)
+
+// Reveal uncovers every enemy within radius of the player, cloaked or not,
+// for a number of frames.  It also uncovers any hidden hazards in that area
+// that the player's side has line of sight to.
+func makeReveal(params map[string]int) game.Ability {
+	var r reveal
+	r.radius = float64(params["radius"])
+	r.frames = params["frames"]
+	return &r
+}
+
+func init() {
+	game.RegisterAbility("reveal", makeReveal)
+}
+
+type reveal struct {
+	NeverActive
+	NonThinker
+	NonRendering
+
+	radius float64
+	frames int
+}
+
+func (r *reveal) Activate(gid game.Gid, keyPress bool) ([]cgf.Event, bool) {
+	if !keyPress {
+		return nil, false
+	}
+	ret := []cgf.Event{
+		addRevealEvent{
+			PlayerGid: gid,
+			Radius:    r.radius,
+			Frames:    r.frames,
+		},
+	}
+	return ret, false
+}
+
+type addRevealEvent struct {
+	PlayerGid game.Gid
+	Radius    float64
+	Frames    int
+}
+
+func init() {
+	gob.Register(addRevealEvent{})
+}
+
+func (e addRevealEvent) Player() game.Gid {
+	return e.PlayerGid
+}
+
+func (e addRevealEvent) Apply(_g interface{}) {
+	g := _g.(*game.Game)
+	player, ok := g.Ents[e.PlayerGid].(*game.PlayerEnt)
+	if !ok {
+		return
+	}
+	g.RevealNear(player.Side(), player.CurrentLevel, player.Position, e.Radius, e.Frames)
+}
@@ -0,0 +1,66 @@
+package ability
+
+import (
+	"encoding/gob"
+	"github.com/runningwild/cgf"
+	"github.com/runningwild/magnus/game"
+)
+
+// Smoke drops a cloud of smoke on the player that blocks line of sight and
+// hides everything inside of it for a number of frames.
+func makeSmoke(params map[string]int) game.Ability {
+	var s smoke
+	s.radius = float64(params["radius"])
+	s.frames = params["frames"]
+	return &s
+}
+
+func init() {
+	game.RegisterAbility("smoke", makeSmoke)
+}
+
+type smoke struct {
+	NeverActive
+	NonThinker
+	NonRendering
+
+	radius float64
+	frames int
+}
+
+func (s *smoke) Activate(gid game.Gid, keyPress bool) ([]cgf.Event, bool) {
+	if !keyPress {
+		return nil, false
+	}
+	ret := []cgf.Event{
+		addSmokeEvent{
+			PlayerGid: gid,
+			Radius:    s.radius,
+			Frames:    s.frames,
+		},
+	}
+	return ret, false
+}
+
+type addSmokeEvent struct {
+	PlayerGid game.Gid
+	Radius    float64
+	Frames    int
+}
+
+func init() {
+	gob.Register(addSmokeEvent{})
+}
+
+func (e addSmokeEvent) Player() game.Gid {
+	return e.PlayerGid
+}
+
+func (e addSmokeEvent) Apply(_g interface{}) {
+	g := _g.(*game.Game)
+	player, ok := g.Ents[e.PlayerGid].(*game.PlayerEnt)
+	if !ok {
+		return
+	}
+	g.MakeSmoke(player.Id(), player.Side(), player.Position, e.Radius, e.Frames)
+}
@@ -0,0 +1,157 @@
+package ability
+
+import (
+	"encoding/gob"
+	"github.com/runningwild/cgf"
+	"github.com/runningwild/linear"
+	"github.com/runningwild/magnus/game"
+	"github.com/runningwild/magnus/stats"
+)
+
+// A trap is a hidden hazard placed at the player's feet.  It stays invisible
+// to the other sides until one of their ents walks into it.
+// Cost: [cost] green mana, which has to be paid before the trap is placed.
+// Pressing the key again while paying for a trap does nothing.  Each player
+// can have at most [max] traps out, placing another removes the oldest.
+func makeTrap(params map[string]int) game.Ability {
+	var t trap
+	t.id = NextAbilityId()
+	t.size = float64(params["size"])
+	t.damage = float64(params["damage"])
+	t.slow = float64(params["slow"]) / 100
+	t.cost = float64(params["cost"])
+	t.max = params["max"]
+	return &t
+}
+
+func init() {
+	game.RegisterAbility("trap", makeTrap)
+}
+
+type trap struct {
+	NeverActive
+	NonThinker
+	NonRendering
+
+	id     int
+	size   float64
+	damage float64
+	slow   float64
+	cost   float64
+	max    int
+}
+
+func (t *trap) Activate(gid game.Gid, keyPress bool) ([]cgf.Event, bool) {
+	if !keyPress {
+		return nil, false
+	}
+	ret := []cgf.Event{
+		addTrapEvent{
+			PlayerGid: gid,
+			Id:        t.id,
+			Size:      t.size,
+			Damage:    t.damage,
+			Slow:      t.slow,
+			Cost:      t.cost,
+			Max:       t.max,
+		},
+	}
+	return ret, false
+}
+
+type addTrapEvent struct {
+	PlayerGid game.Gid
+	Id        int
+	Size      float64
+	Damage    float64
+	Slow      float64
+	Cost      float64
+	Max       int
+}
+
+func init() {
+	gob.Register(addTrapEvent{})
+}
+
+func (e addTrapEvent) Player() game.Gid {
+	return e.PlayerGid
+}
+
+func (e addTrapEvent) Apply(_g interface{}) {
+	g := _g.(*game.Game)
+	player, ok := g.Ents[e.PlayerGid].(*game.PlayerEnt)
+	if !ok {
+		return
+	}
+	if proc, ok := player.Processes[100+e.Id]; ok && proc.Phase() != game.PhaseComplete {
+		return
+	}
+	player.Processes[100+e.Id] = &trapProcess{
+		PlayerGid: e.PlayerGid,
+		Size:      e.Size,
+		Damage:    e.Damage,
+		Slow:      e.Slow,
+		Max:       e.Max,
+		Remaining: game.Mana{0, e.Cost, 0},
+	}
+}
+
+func init() {
+	gob.Register(&trapProcess{})
+}
+
+// trapProcess places a trap once its cost has been paid.
+type trapProcess struct {
+	BasicPhases
+	NullCondition
+	NonRendering
+	PlayerGid game.Gid
+	Size      float64
+	Damage    float64
+	Slow      float64
+	Max       int
+	Remaining game.Mana
+}
+
+// Supplies mana to the process.  Any mana that is unused is returned.
+func (p *trapProcess) Supply(supply game.Mana) game.Mana {
+	for color := range supply {
+		if supply[color] > p.Remaining[color] {
+			supply[color] -= p.Remaining[color]
+			p.Remaining[color] = 0
+		} else {
+			p.Remaining[color] -= supply[color]
+			supply[color] = 0
+		}
+	}
+	return supply
+}
+
+func (p *trapProcess) Think(g *game.Game) {
+	player, ok := g.Ents[p.PlayerGid].(*game.PlayerEnt)
+	if !ok {
+		p.The_phase = game.PhaseComplete
+		return
+	}
+	if p.Remaining.Magnitude() > 0 {
+		return
+	}
+	p.The_phase = game.PhaseComplete
+	player.Reveal(game.RevealOnCastFrames)
+	var region linear.Poly
+	for _, v := range []linear.Vec2{{-1, -1}, {-1, 1}, {1, 1}, {1, -1}} {
+		region = append(region, player.Position.Add(v.Scale(p.Size/2)))
+	}
+	hazard := game.Hazard{
+		Region: region,
+		Damage: stats.Damage{stats.DamageCrushing, p.Damage},
+		Placed: true,
+		Side:   player.Side(),
+		Owner:  player.Id(),
+		Hidden: true,
+	}
+	if p.Slow > 0 {
+		hazard.AccMultiplier = 1 - p.Slow
+	}
+	g.PlaceOwnedHazard(player.CurrentLevel, hazard, p.Max)
+}
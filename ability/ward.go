@@ -0,0 +1,79 @@
+package ability
+
+import (
+	"encoding/gob"
+	"github.com/runningwild/cgf"
+	"github.com/runningwild/linear"
+	"github.com/runningwild/magnus/game"
+)
+
+// A ward is placed a short distance in front of the player and gives their
+// side vision in a radius around it.  Each player can only have a few wards
+// out at a time.  Nothing happens if that spot is inside of a wall.
+func makeWard(params map[string]int) game.Ability {
+	var w ward
+	w.radius = float64(params["radius"])
+	w.health = float64(params["health"])
+	w.frames = params["frames"]
+	w.max = params["max"]
+	return &w
+}
+
+func init() {
+	game.RegisterAbility("ward", makeWard)
+}
+
+type ward struct {
+	NeverActive
+	NonThinker
+	NonRendering
+
+	radius float64
+	health float64
+	frames int
+	max    int
+}
+
+func (w *ward) Activate(gid game.Gid, keyPress bool) ([]cgf.Event, bool) {
+	if !keyPress {
+		return nil, false
+	}
+	ret := []cgf.Event{
+		addWardEvent{
+			PlayerGid: gid,
+			Radius:    w.radius,
+			Health:    w.health,
+			Frames:    w.frames,
+			Max:       w.max,
+		},
+	}
+	return ret, false
+}
+
+type addWardEvent struct {
+	PlayerGid game.Gid
+	Radius    float64
+	Health    float64
+	Frames    int
+	Max       int
+}
+
+func init() {
+	gob.Register(addWardEvent{})
+}
+
+func (e addWardEvent) Player() game.Gid {
+	return e.PlayerGid
+}
+
+func (e addWardEvent) Apply(_g interface{}) {
+	g := _g.(*game.Game)
+	player, ok := g.Ents[e.PlayerGid].(*game.PlayerEnt)
+	if !ok {
+		return
+	}
+	pos := player.Position.Add((linear.Vec2{40, 0}).Rotate(player.Angle))
+	if g.MakeWard(player.Id(), player.Side(), pos, e.Radius, e.Health, e.Frames, e.Max) {
+		player.Reveal(game.RevealOnCastFrames)
+	}
+}
@@ -24,6 +24,7 @@ var datadir string
 var logger *log.Logger
 var log_reader io.Reader
 var log_out *os.File
+var log_writer io.Writer
 
 var log_console *bytes.Buffer
 var logTailer Tailer
@@ -56,9 +57,19 @@ func setupLogger() {
 		log_out = os.Stdout
 	}
 	tee := bytes.NewBuffer(nil)
-	logWriter := io.MultiWriter(tee, log_out)
+	log_writer = io.MultiWriter(tee, log_out)
 	logTailer = newTail(tee, 100)
-	logger = log.New(logWriter, "> ", log.Ltime|log.Lshortfile)
+	logger = log.New(log_writer, "> ", log.Ltime|log.Lshortfile)
+}
+
+// LogToStdout copies everything logged from now on to stdout, for programs
+// that don't have a console to show it in.  The log file is still written.
+func LogToStdout() {
+	if log_out == os.Stdout {
+		return
+	}
+	log_writer = io.MultiWriter(log_writer, os.Stdout)
+	logger.SetOutput(log_writer)
 }
 
 // TODO: This probably isn't the best way to do things - different go-routines
@@ -333,8 +344,21 @@ func EmailCrashReport(panicData interface{}) {
 	}
 }
 
+var panicHandler func(panicData interface{}, stack []byte)
+
+// SetPanicHandler makes StackCatcher call handler instead of reporting the
+// crash and exiting.  This is for tools, like the soak tester, that want to
+// record a panic and keep going.  Passing nil restores the default behavior.
+func SetPanicHandler(handler func(panicData interface{}, stack []byte)) {
+	panicHandler = handler
+}
+
 func StackCatcher() {
 	if r := recover(); r != nil {
+		if panicHandler != nil {
+			panicHandler(r, debug.Stack())
+			return
+		}
 		EmailCrashReport(r)
 		Error().Printf("Panic: %v", r)
 		Error().Fatalf("Stack:\n%s", string(debug.Stack()))
@@ -0,0 +1,81 @@
+// Command import makes room files from SVG drawings and Tiled maps.  It
+// doesn't need a display.
+//
+//	import -o data/rooms/arena.json arena.svg
+//	import -scale 2 -author someone cave.tmx
+//
+// Shapes are made into walls, starts, portals and so on depending on their
+// names and the names of their layers, see the importer package for how.
+// Anything that can't be imported is printed, along with any problems that
+// the room has, and it exits with a non-zero status if there were any.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/runningwild/magnus/generator"
+	"github.com/runningwild/magnus/importer"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	out    = flag.String("o", "", "Where to write the room, the input with a .json extension is used if this is empty.")
+	name   = flag.String("name", "", "Name of the room, the input's name is used if this is empty.")
+	author = flag.String("author", os.Getenv("USER"), "Author of the room.")
+	scale  = flag.Float64("scale", 1, "Coordinates in the input are multiplied by this.")
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Printf("Usage: import [flags] room.svg|room.tmx\n")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	path := flag.Arg(0)
+	ext := strings.ToLower(filepath.Ext(path))
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Printf("Unable to open %s: %v\n", path, err)
+		os.Exit(2)
+	}
+	options := importer.Options{Scale: *scale}
+	var room generator.Room
+	var problems []importer.Problem
+	switch ext {
+	case ".svg":
+		room, problems = importer.ImportSVG(f, options)
+	case ".tmx":
+		room, problems = importer.ImportTMX(f, options)
+	default:
+		fmt.Printf("Unknown file type %q, expected .svg or .tmx\n", ext)
+		os.Exit(2)
+	}
+	f.Close()
+
+	if *out == "" {
+		*out = strings.TrimSuffix(path, filepath.Ext(path)) + ".json"
+	}
+	info := generator.RoomInfo{Name: *name, Author: *author}
+	if info.Name == "" {
+		info.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if err := generator.WriteRoomFile(*out, info, room); err != nil {
+		fmt.Printf("Unable to write %s: %v\n", *out, err)
+		os.Exit(2)
+	}
+	fmt.Printf("Wrote %s: %d walls, %d starts\n", *out, len(room.Walls), len(room.Starts))
+
+	for _, problem := range problems {
+		fmt.Printf("UNSUPPORTED %v\n", problem)
+	}
+	invalid := generator.Validate(room, generator.ValidateOptions{})
+	for _, problem := range invalid {
+		fmt.Printf("INVALID %v\n", problem)
+	}
+	if len(problems)+len(invalid) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,68 @@
+// Command preview saves pictures of rooms without needing a display.
+//
+//	preview data/rooms
+//	preview -width 128 -o thumbnails some/room.json
+//
+// Each room is saved as a png with the same name, in the -o directory if it is
+// set and next to the room otherwise.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/runningwild/magnus/generator"
+	"github.com/runningwild/magnus/preview"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	outDir = flag.String("o", "", "Directory to save pictures to, they're saved next to the rooms if this is empty.")
+	width  = flag.Int("width", 512, "Width of the pictures, 0 to use -height or the size of the room.")
+	height = flag.Int("height", 0, "Height of the pictures, 0 to use -width or the size of the room.")
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Printf("Usage: preview [flags] room.json|dir...\n")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	if *outDir != "" {
+		if err := os.MkdirAll(*outDir, 0755); err != nil {
+			fmt.Printf("Unable to make %s: %v\n", *outDir, err)
+			os.Exit(2)
+		}
+	}
+	failed := false
+	for _, path := range flag.Args() {
+		files := []string{path}
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			files, _ = filepath.Glob(filepath.Join(path, "*.json"))
+		}
+		for _, file := range files {
+			room, err := generator.LoadRoom(file)
+			if err != nil {
+				fmt.Printf("Unable to load %s: %v\n", file, err)
+				failed = true
+				continue
+			}
+			out := strings.TrimSuffix(file, filepath.Ext(file)) + ".png"
+			if *outDir != "" {
+				out = filepath.Join(*outDir, filepath.Base(out))
+			}
+			err = preview.SavePNG(out, room, preview.Options{Width: *width, Height: *height})
+			if err != nil {
+				fmt.Printf("Unable to save %s: %v\n", out, err)
+				failed = true
+				continue
+			}
+			fmt.Printf("Saved %s\n", out)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
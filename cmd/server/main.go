@@ -0,0 +1,211 @@
+// Command server hosts a match without a window, so that playtest servers can
+// run on machines without a display.
+//
+//	server -data data -port 20007 -map "Mirrored moba" -ai 1:hard -ai 1:normal
+//
+// Options can also come from a json config file, anything given on the
+// command line overrides it:
+//
+//	server -config playtest.json -minutes 10
+//
+// where playtest.json looks like
+//
+//	{"Map": "Moba", "Size": 1536, "Ais": ["1:hard"], "MaxPlayers": 4}
+//
+// With -sync visible the server runs the simulation by itself and only sends
+// each client what its side can see, so cloaked enemies and hidden hazards
+// can't be pulled out of a modified client, and clients can only send events
+// for their own player.  Clients connect to it by setting MAGNUS_SERVER to
+// host:port instead of searching the LAN.
+//
+// The server waits in the lobby until at least -min-players players have
+// joined and all of them are ready, plays until the time runs out or every
+// player leaves, then prints the results and exits.  Everything is logged to
+// stdout as well as the usual log file.
+package main
+
+import (
+	"flag"
+	"fmt"
+	_ "github.com/runningwild/magnus/ability"
+	_ "github.com/runningwild/magnus/ability/kassadin"
+	"github.com/runningwild/magnus/base"
+	_ "github.com/runningwild/magnus/effects"
+	"github.com/runningwild/magnus/game"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Config is everything that can be set in a config file.
+type Config struct {
+	// Port to host on, and the name that the server is advertised with on the
+	// LAN.
+	Port int
+	Name string
+
+	Mode string
+
+	// Map is the name of a map preset, the name of a room in the rooms
+	// directory, or the path to a room file.  Size is only used for presets.
+	Map  string
+	Size int
+
+	// Seed for generating the map and for the match, the time is used if this
+	// is 0.
+	Seed int64
+
+	// Ai players in the form side[:difficulty[:champ]], like 1:hard:Kassadin.
+	Ais []string
+
+	// Damage sources that hurt their own side, like "mine,environment", or
+	// "all" or "off".
+	FriendlyFire string
+
+	// Players beyond MaxPlayers can connect but are left out of the match.
+	MaxPlayers int
+	MinPlayers int
+
+	// The match ends after this long, or only once every player leaves if this
+	// is 0.
+	Minutes float64
+
+	// Either full, where every client simulates the whole game, or visible,
+	// where clients are only sent what they can see.
+	Sync string
+
+	// Precompute and save the los cache for each map, and load it in later
+	// matches.  Once a map has been played everyone starts its matches with
+	// the server's cache, rather than an empty one.
+	LosPersist bool
+}
+
+var defaultConfig = Config{
+	Port:         20007,
+	Name:         "thunderball",
+	Mode:         "moba",
+	Map:          "Moba",
+	Size:         1024,
+	MaxPlayers:   8,
+	MinPlayers:   1,
+	Minutes:      15,
+	Sync:         "full",
+	LosPersist:   true,
+	FriendlyFire: "off",
+}
+
+var (
+	dataDir      = flag.String("data", "data", "Path to the data directory.")
+	configPath   = flag.String("config", "", "Json file to read options from, flags override it.")
+	port         = flag.Int("port", defaultConfig.Port, "Port to host on.")
+	name         = flag.String("name", defaultConfig.Name, "Name to advertise on the LAN.")
+	mode         = flag.String("mode", defaultConfig.Mode, "Game mode, only moba can be hosted right now.")
+	mapName      = flag.String("map", defaultConfig.Map, "Map preset, room name or path to a room file.")
+	size         = flag.Int("size", defaultConfig.Size, "Width and height of generated maps.")
+	seed         = flag.Int64("seed", 0, "Seed for the map and the match, the time is used if this is 0.")
+	maxPlayers   = flag.Int("max-players", defaultConfig.MaxPlayers, "Most players that can be in the match.")
+	minPlayers   = flag.Int("min-players", defaultConfig.MinPlayers, "Fewest players that have to join before the match can start.")
+	minutes      = flag.Float64("minutes", defaultConfig.Minutes, "Length of the match in minutes, 0 plays until everyone leaves.")
+	syncMode     = flag.String("sync", defaultConfig.Sync, "Either full or visible, visible only sends clients what their side can see.")
+	losPersist   = flag.Bool("los-persist", defaultConfig.LosPersist, "Save the los cache for each map in the data directory, and send it to everyone in later matches.")
+	friendlyFire = flag.String("friendly-fire", defaultConfig.FriendlyFire, "Damage sources that hurt their own side, a list like mine,environment, or all or off.")
+	ais          aiFlag
+)
+
+// aiFlag collects every -ai flag.
+type aiFlag []string
+
+func (a *aiFlag) String() string {
+	return strings.Join(*a, ",")
+}
+func (a *aiFlag) Set(value string) error {
+	*a = append(*a, value)
+	return nil
+}
+
+func init() {
+	flag.Var(&ais, "ai", "Add an ai player as side[:difficulty[:champ]], can be given more than once.")
+}
+
+func loadConfig() (Config, error) {
+	config := defaultConfig
+	if *configPath != "" {
+		if err := base.LoadJson(*configPath, &config); err != nil {
+			return config, err
+		}
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			config.Port = *port
+		case "name":
+			config.Name = *name
+		case "mode":
+			config.Mode = *mode
+		case "map":
+			config.Map = *mapName
+		case "size":
+			config.Size = *size
+		case "seed":
+			config.Seed = *seed
+		case "max-players":
+			config.MaxPlayers = *maxPlayers
+		case "min-players":
+			config.MinPlayers = *minPlayers
+		case "minutes":
+			config.Minutes = *minutes
+		case "sync":
+			config.Sync = *syncMode
+		case "los-persist":
+			config.LosPersist = *losPersist
+		case "friendly-fire":
+			config.FriendlyFire = *friendlyFire
+		case "ai":
+			config.Ais = ais
+		}
+	})
+	if config.Seed == 0 {
+		config.Seed = time.Now().UnixNano()
+	}
+	return config, nil
+}
+
+func main() {
+	flag.Parse()
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Unable to load %s: %v\n", *configPath, err)
+		os.Exit(2)
+	}
+	base.SetDatadir(*dataDir)
+	base.LogToStdout()
+	game.LosCacheSettings.Persist = config.LosPersist
+
+	g := game.MakeGame()
+	s, err := makeServer(config, g)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(2)
+	}
+	if err := s.start(g); err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	ticker := time.Tick(time.Millisecond * frameMs)
+	for s.done == "" {
+		select {
+		case <-ticker:
+			s.think()
+		case sig := <-interrupt:
+			s.done = fmt.Sprintf("stopped by %v", sig)
+		}
+	}
+	fmt.Printf("%s\n", s.summary())
+	s.stop()
+	base.CloseLog()
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"fmt"
+	"github.com/runningwild/cgf"
+	"github.com/runningwild/magnus/base"
+	"github.com/runningwild/magnus/game"
+	"github.com/runningwild/magnus/statesync"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Same frame length as the client uses.
+const frameMs = 17
+
+type server struct {
+	config Config
+	engine *cgf.Engine
+
+	// Only used with visible sync, where clients connect here rather than to
+	// the engine.
+	sync *statesync.Server
+
+	// Sent as soon as the lobby is up.
+	setupMap     game.SetupMap
+	ais          []game.SetupAddAi
+	friendlyFire game.FriendlyFirePolicy
+	sentSetup    bool
+
+	// Set once SetupComplete has been sent, so that it's only sent once.
+	starting bool
+
+	// Set once the saved los cache, if there is one, has been sent.
+	sentLosCache bool
+
+	// Frame that the match started on and the number of frames it lasts, or 0
+	// if it doesn't have a time limit.
+	startFrame  int
+	matchFrames int
+
+	// Why the server is stopping, empty until then.
+	done string
+
+	results game.Results
+	frames  int
+}
+
+// makeServer checks config against the maps and champs that g knows about.
+func makeServer(config Config, g *game.Game) (*server, error) {
+	s := &server{config: config}
+	if config.Mode != "moba" {
+		return nil, fmt.Errorf("Unable to host %q games, only moba can be hosted right now", config.Mode)
+	}
+	switch config.Sync {
+	case "full":
+	case "visible":
+		g.Sync = game.SyncVisible
+	default:
+		return nil, fmt.Errorf("Sync must be full or visible, not %q", config.Sync)
+	}
+	if config.MaxPlayers < 0 || config.MinPlayers > config.MaxPlayers {
+		return nil, fmt.Errorf("Min players (%d) must be at most max players (%d)", config.MinPlayers, config.MaxPlayers)
+	}
+	if config.Minutes > 0 {
+		s.matchFrames = int(config.Minutes * 60 * 1000 / frameMs)
+	}
+
+	var err error
+	s.setupMap, err = findMap(config.Mode, config.Map, config.Size)
+	if err != nil {
+		return nil, err
+	}
+	s.friendlyFire, err = game.ParseFriendlyFire(config.FriendlyFire)
+	if err != nil {
+		return nil, fmt.Errorf("Friendly fire: %v", err)
+	}
+	for _, spec := range config.Ais {
+		ai, err := parseAi(spec, g)
+		if err != nil {
+			return nil, err
+		}
+		s.ais = append(s.ais, ai)
+	}
+	return s, nil
+}
+
+// findMap returns the map named name, which can also be the path to a room
+// file.
+func findMap(mode, name string, size int) (game.SetupMap, error) {
+	if strings.HasSuffix(name, ".json") {
+		file, err := game.LoadRoomFile(name)
+		if err != nil {
+			return game.SetupMap{}, fmt.Errorf("Unable to load %s: %v", name, err)
+		}
+		if !file.Supports(mode, 0) {
+			return game.SetupMap{}, fmt.Errorf("%s can't be played in %s", name, mode)
+		}
+		return game.SetupMap{Name: file.Info.Name, Room: &file.Room}, nil
+	}
+	var names []string
+	for _, m := range game.MapChoices(mode, 0) {
+		if !strings.EqualFold(m.Name, name) {
+			names = append(names, m.Name)
+			continue
+		}
+		if m.Preset != "" {
+			if size <= 0 {
+				return m, fmt.Errorf("Map size must be positive, not %d", size)
+			}
+			m.Dx, m.Dy = size, size
+		}
+		return m, nil
+	}
+	return game.SetupMap{}, fmt.Errorf("No map named %q, choose from %s", name, strings.Join(names, ", "))
+}
+
+// parseAi parses side[:difficulty[:champ]].
+func parseAi(spec string, g *game.Game) (game.SetupAddAi, error) {
+	ai := game.SetupAddAi{Difficulty: game.AiNormal}
+	parts := strings.Split(spec, ":")
+	if len(parts) > 3 {
+		return ai, fmt.Errorf("Ai %q should look like side[:difficulty[:champ]]", spec)
+	}
+	side, err := strconv.Atoi(parts[0])
+	if err != nil || side < 0 {
+		return ai, fmt.Errorf("Ai %q has an invalid side", spec)
+	}
+	ai.Side = side
+	if len(parts) > 1 {
+		ai.Difficulty, err = game.ParseAiDifficulty(parts[1])
+		if err != nil {
+			return ai, fmt.Errorf("Ai %q: %v", spec, err)
+		}
+	}
+	if len(parts) > 2 {
+		ai.Champ = -1
+		for i := range g.Champs {
+			if strings.EqualFold(g.Champs[i].Name, parts[2]) {
+				ai.Champ = i
+			}
+		}
+		if ai.Champ == -1 {
+			return ai, fmt.Errorf("Ai %q: no champ named %q", spec, parts[2])
+		}
+	}
+	return ai, nil
+}
+
+// start starts hosting g.  With full sync clients connect to a cgf host
+// engine that is advertised on the LAN, with visible sync the engine is local
+// and clients connect to a statesync server on the same port.
+func (s *server) start(g *game.Game) error {
+	var err error
+	if g.Sync == game.SyncVisible {
+		s.engine, err = cgf.NewLocalEngine(g, frameMs, base.EmailCrashReport, base.Log())
+		if err != nil {
+			return err
+		}
+		s.sync, err = statesync.Listen(s.config.Port)
+		if err != nil {
+			return fmt.Errorf("Unable to host on port %d: %v", s.config.Port, err)
+		}
+		base.Log().Printf("Server: sending each side only what it can see on port %d with seed %d", s.config.Port, s.config.Seed)
+		return nil
+	}
+	s.engine, err = cgf.NewHostEngine(g, frameMs, "", s.config.Port, base.EmailCrashReport, base.Log())
+	if err != nil {
+		return fmt.Errorf("Unable to host on port %d: %v", s.config.Port, err)
+	}
+	if err := cgf.Host(s.config.Port, s.config.Name); err != nil {
+		return fmt.Errorf("Unable to advertise on the LAN: %v", err)
+	}
+	base.Log().Printf("Server: hosting %q on port %d with seed %d", s.config.Name, s.config.Port, s.config.Seed)
+	return nil
+}
+
+func (s *server) stop() {
+	if s.sync != nil {
+		s.sync.Close()
+	}
+	s.engine.Kill()
+}
+
+// connected returns the engine ids of every connected client.
+func (s *server) connected() []int64 {
+	if s.sync != nil {
+		return s.sync.Ids()
+	}
+	var ids []int64
+	for _, id := range s.engine.Ids() {
+		if id != s.engine.Id() {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(int64Slice(ids))
+	return ids
+}
+
+// players returns the engines that are in the match, which is every connected
+// client up to MaxPlayers.  Engines that joined first get in.
+func (s *server) players() []int64 {
+	ids := s.connected()
+	if len(ids) > s.config.MaxPlayers {
+		ids = ids[:s.config.MaxPlayers]
+	}
+	return ids
+}
+
+func (s *server) think() {
+	s.engine.Pause()
+	defer s.engine.Unpause()
+	g := s.engine.GetState().(*game.Game)
+	if s.sync != nil {
+		for _, event := range s.sync.Events() {
+			if allowedFrom(event.Id, event.Event) {
+				s.engine.ApplyEvent(event.Event)
+			} else {
+				base.Warn().Printf("Server: ignoring %T from engine %d", event.Event, event.Id)
+			}
+		}
+	}
+	if g.Setup != nil {
+		s.setup(g)
+	} else {
+		s.play(g)
+	}
+	if s.sync != nil {
+		s.sync.Send(g)
+	}
+}
+
+// allowedFrom returns true only for events that a client may send, and only
+// if they change the client's own player.  Anything else, like events that
+// only the server should send or that place hazards, is dropped.  This is only
+// checked with visible sync, with full sync every client can see and change
+// everything.
+func allowedFrom(id int64, event cgf.Event) bool {
+	gid := game.Gid(fmt.Sprintf("Engine:%d", id))
+	switch e := event.(type) {
+	case game.SetupChangeSides:
+		return e.EngineId == id
+	case game.SetupChampSelect:
+		return e.EngineId == id
+	case game.SetupSetName:
+		return e.EngineId == id
+	case game.SetupSetReady:
+		return e.EngineId == id
+	case game.Turn:
+		return e.Gid == gid
+	case game.Accelerate:
+		return e.Gid == gid
+	case game.AbilityEvent:
+		return e.Player() == gid
+	}
+	return false
+}
+
+func (s *server) setup(g *game.Game) {
+	if !s.sentSetup {
+		s.engine.ApplyEvent(game.SetupSelectMap{s.setupMap})
+		s.engine.ApplyEvent(game.SetupFriendlyFire{s.friendlyFire})
+		for _, ai := range s.ais {
+			s.engine.ApplyEvent(ai)
+		}
+		s.sentSetup = true
+		base.Log().Printf("Server: waiting for players on %s", s.setupMap.Name)
+		return
+	}
+	if s.starting {
+		return
+	}
+
+	ids := s.players()
+	if !sameIds(ids, g.Setup.EngineIds) {
+		base.Log().Printf("Server: players are now %v", ids)
+		if n := len(s.connected()) - len(ids); n > 0 {
+			base.Warn().Printf("Server: %d engines are left out, the most players is %d", n, s.config.MaxPlayers)
+		}
+		s.engine.ApplyEvent(game.SetupSetEngineIds{ids, s.engine.Id()})
+		return
+	}
+	if len(ids) < s.config.MinPlayers || len(g.Setup.Members()) == 0 {
+		return
+	}
+	if len(g.Setup.Waiting(s.engine.Id())) > 0 {
+		return
+	}
+	for _, id := range g.Setup.Members() {
+		base.Log().Printf("Server: side %d: %s", g.Setup.Sides[id].Side, g.Setup.PlayerName(id))
+	}
+	base.Log().Printf("Server: everyone is ready, starting")
+	s.engine.ApplyEvent(game.SetupComplete{s.config.Seed})
+	s.starting = true
+}
+
+func (s *server) play(g *game.Game) {
+	if s.startFrame == 0 {
+		s.startFrame = g.GameThinks
+		base.Log().Printf("Server: match started")
+	}
+	for _, event := range g.AiEvents() {
+		s.engine.ApplyEvent(event)
+	}
+	if !s.sentLosCache && g.GameThinks > s.startFrame {
+		s.sendLosCache(g)
+	}
+	s.frames = g.GameThinks - s.startFrame
+	s.results = g.Results()
+	if s.matchFrames > 0 && s.frames >= s.matchFrames {
+		s.done = "time is up"
+		return
+	}
+
+	// Nobody is left to play against the ais, ai only matches just run until
+	// the time is up.
+	connected := make(map[int64]bool)
+	for _, id := range s.connected() {
+		connected[id] = true
+	}
+	players, left := 0, 0
+	for id, data := range g.Engines {
+		if data.Ai == nil {
+			players++
+			if !connected[id] {
+				left++
+			}
+		}
+	}
+	if players > 0 && left == players {
+		s.done = "every player left"
+	}
+}
+
+// sendLosCache sends everyone the saved los cache for the map, so that they
+// don't all start with an empty one.
+func (s *server) sendLosCache(g *game.Game) {
+	s.sentLosCache = true
+	event, ok := g.LosCacheEvent()
+	if !ok {
+		base.Log().Printf("Server: no saved los cache for this map yet")
+		return
+	}
+	base.Log().Printf("Server: sending the saved los cache, %d KB", len(event.Data)>>10)
+	if s.sync != nil {
+		s.sync.SendLosCache(event.Data)
+	}
+	s.engine.ApplyEvent(event)
+}
+
+// summary describes how the match went.
+func (s *server) summary() string {
+	if !s.starting {
+		return fmt.Sprintf("Server %s before the match started", s.done)
+	}
+	length := time.Duration(s.frames) * frameMs * time.Millisecond
+	lines := []string{
+		fmt.Sprintf("Match over, %s", s.done),
+		fmt.Sprintf("Map %s, seed %d, played %v", s.setupMap.Name, s.config.Seed, length),
+		s.results.String(),
+	}
+	return strings.Join(lines, "\n")
+}
+
+func sameIds(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type int64Slice []int64
+
+func (s int64Slice) Len() int           { return len(s) }
+func (s int64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s int64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,87 @@
+// Command soak runs many headless matches with bots that mash random inputs,
+// looking for simulation bugs.  It doesn't need a display.
+//
+//	soak -data data -matches 100 -frames 3600 -out soak-failures
+//
+// Any failure is written to the -out directory as seed-N.gob and seed-N.txt.
+// The .gob file can be passed back in with -replay to reproduce it exactly.
+package main
+
+import (
+	"flag"
+	"fmt"
+	_ "github.com/runningwild/magnus/ability"
+	_ "github.com/runningwild/magnus/ability/kassadin"
+	"github.com/runningwild/magnus/base"
+	_ "github.com/runningwild/magnus/effects"
+	"github.com/runningwild/magnus/game"
+	"github.com/runningwild/magnus/soak"
+	"os"
+	"time"
+)
+
+var (
+	dataDir        = flag.String("data", "data", "Path to the data directory.")
+	seed           = flag.Int64("seed", 0, "Seed for the first match, the time is used if this is 0.")
+	matches        = flag.Int("matches", 10, "Number of matches to play.")
+	frames         = flag.Int("frames", 60*60, "Number of frames to play in each match.")
+	sides          = flag.Int("sides", 2, "Number of sides.")
+	playersPerSide = flag.Int("players", 2, "Number of bots on each side.")
+	checkReplay    = flag.Bool("check-replay", true, "Replay every match and check that it is deterministic.")
+	outDir         = flag.String("out", "soak-failures", "Directory to write failed matches to.")
+	replay         = flag.String("replay", "", "Replay a previously written .gob event log instead of playing new matches.")
+	losCacheMb     = flag.Int("los-cache-mb", game.LosCacheSettings.MaxBytes>>20, "Memory limit for the los cache, in megabytes.")
+	losPrecompute  = flag.Bool("los-precompute", false, "Fill the los cache in the background whenever the walls change.")
+	losPersist     = flag.Bool("los-persist", false, "Save the los cache for each room in the data directory and load it in later matches.")
+	friendlyFire   = flag.String("friendly-fire", "off", "Damage sources that hurt their own side, a list like mine,environment, or all or off.")
+)
+
+func main() {
+	flag.Parse()
+	base.SetDatadir(*dataDir)
+	game.LosCacheSettings.MaxBytes = *losCacheMb << 20
+	game.LosCacheSettings.Precompute = *losPrecompute
+	game.LosCacheSettings.Persist = *losPersist
+
+	if *replay != "" {
+		log, err := soak.LoadEventLog(*replay)
+		if err != nil {
+			fmt.Printf("Unable to load %s: %v\n", *replay, err)
+			os.Exit(2)
+		}
+		if failure := soak.Replay(log); failure != nil {
+			fmt.Printf("FAIL %v\n%s", failure, failure.Stack)
+			os.Exit(1)
+		}
+		fmt.Printf("PASS seed %d, %d frames\n", log.Seed, len(log.Frames))
+		return
+	}
+
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	policy, err := game.ParseFriendlyFire(*friendlyFire)
+	if err != nil {
+		fmt.Printf("Friendly fire: %v\n", err)
+		os.Exit(2)
+	}
+	config := soak.Config{
+		Seed:           *seed,
+		Matches:        *matches,
+		Frames:         *frames,
+		Sides:          *sides,
+		PlayersPerSide: *playersPerSide,
+		CheckReplay:    *checkReplay,
+		OutDir:         *outDir,
+		FriendlyFire:   policy,
+	}
+	fmt.Printf("Playing %d matches of %d frames starting with seed %d\n", config.Matches, config.Frames, config.Seed)
+	failures := soak.Run(config)
+	for _, failure := range failures {
+		fmt.Printf("FAIL %v\n", failure)
+	}
+	fmt.Printf("%d of %d matches failed\n", len(failures), config.Matches)
+	if len(failures) > 0 {
+		os.Exit(1)
+	}
+}
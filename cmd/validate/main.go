@@ -0,0 +1,167 @@
+// Command validate checks that rooms are playable.  It doesn't need a display.
+//
+//	validate -data data
+//	validate -size 20 some/room.json other/rooms
+//
+// With no arguments it checks every room in the rooms directory in the data
+// directory, otherwise it checks the named rooms and every room in the named
+// directories.  It can also check rooms straight from the generators:
+//
+//	validate -generate moba -seeds 100
+//
+// Every problem is printed, and it exits with a non-zero status if there were
+// any.  With -png a picture of each room that has problems is saved with the
+// problems marked on it:
+//
+//	validate -generate dungeon -seeds 100 -png failures
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/runningwild/magnus/base"
+	"github.com/runningwild/magnus/generator"
+	"github.com/runningwild/magnus/preview"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+var (
+	dataDir  = flag.String("data", "data", "Path to the data directory.")
+	entSize  = flag.Float64("size", 0, "Radius of the largest ent, a player's size is used if this is 0.")
+	width    = flag.Float64("width", 0, "Narrowest that a corridor can be, four times -size is used if this is 0.")
+	grid     = flag.Float64("grid", 0, "Size of the grid that reachability is checked on.")
+	generate = flag.String("generate", "", "Check rooms from a generator instead of from files, one of room, moba or dungeon.")
+	seed     = flag.Int64("seed", 1, "First seed to use with -generate.")
+	seeds    = flag.Int("seeds", 10, "Number of seeds to use with -generate.")
+	dx       = flag.Float64("dx", 1024, "Width of rooms from -generate.")
+	dy       = flag.Float64("dy", 1024, "Height of rooms from -generate.")
+	sides    = flag.Int("sides", 2, "Number of sides for -generate moba.")
+	levels   = flag.Int("levels", 1, "Number of levels for -generate dungeon.")
+	pngDir   = flag.String("png", "", "Directory to save a picture of every room with problems to.")
+	pngSize  = flag.Int("png-size", 512, "Width of the pictures saved with -png.")
+)
+
+type namedRoom struct {
+	name string
+	room generator.Room
+}
+
+func loadRooms(paths []string) []namedRoom {
+	var rooms []namedRoom
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Printf("Unable to read %s: %v\n", path, err)
+			os.Exit(2)
+		}
+		files := []string{path}
+		if info.IsDir() {
+			files, err = filepath.Glob(filepath.Join(path, "*.json"))
+			if err != nil {
+				fmt.Printf("Unable to list %s: %v\n", path, err)
+				os.Exit(2)
+			}
+		}
+		for _, file := range files {
+			var room generator.Room
+			if err := base.LoadJson(file, &room); err != nil {
+				fmt.Printf("Unable to load %s: %v\n", file, err)
+				os.Exit(2)
+			}
+			rooms = append(rooms, namedRoom{file, room})
+		}
+	}
+	return rooms
+}
+
+func generateRooms() []namedRoom {
+	var rooms []namedRoom
+	for s := *seed; s < *seed+int64(*seeds); s++ {
+		name := fmt.Sprintf("%s seed %d", *generate, s)
+		switch *generate {
+		case "room":
+			rooms = append(rooms, namedRoom{name, generator.GenerateRoom(*dx, *dy, 100, 64, s)})
+		case "moba":
+			room := generator.GenerateMoba(generator.MobaOptions{Dx: *dx, Dy: *dy, Sides: *sides}, s)
+			rooms = append(rooms, namedRoom{name, room})
+		case "dungeon":
+			levels := generator.GenerateDungeon(generator.DungeonOptions{Dx: *dx, Dy: *dy, Levels: *levels}, s)
+			for i, room := range levels {
+				rooms = append(rooms, namedRoom{fmt.Sprintf("%s level %d", name, i), room})
+			}
+		default:
+			fmt.Printf("Unknown generator %q, expected room, moba or dungeon\n", *generate)
+			os.Exit(2)
+		}
+	}
+	return rooms
+}
+
+// savePreview saves a picture of room with its problems marked in the -png
+// directory.
+func savePreview(room namedRoom, problems []generator.Problem) {
+	if err := os.MkdirAll(*pngDir, 0755); err != nil {
+		fmt.Printf("Unable to make %s: %v\n", *pngDir, err)
+		os.Exit(2)
+	}
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, strings.TrimSuffix(room.name, filepath.Ext(room.name)))
+	options := preview.Options{Width: *pngSize}
+	for _, problem := range problems {
+		options.Marks = append(options.Marks, problem.Pos)
+	}
+	path := filepath.Join(*pngDir, name+".png")
+	if err := preview.SavePNG(path, room.room, options); err != nil {
+		fmt.Printf("Unable to save %s: %v\n", path, err)
+		os.Exit(2)
+	}
+}
+
+func main() {
+	flag.Parse()
+	base.SetDatadir(*dataDir)
+	options := generator.ValidateOptions{
+		EntSize:          *entSize,
+		MinCorridorWidth: *width,
+		GridSize:         *grid,
+	}
+
+	var rooms []namedRoom
+	if *generate != "" {
+		rooms = generateRooms()
+	} else {
+		paths := flag.Args()
+		if len(paths) == 0 {
+			paths = []string{filepath.Join(*dataDir, "rooms")}
+		}
+		rooms = loadRooms(paths)
+	}
+
+	bad := 0
+	for _, room := range rooms {
+		problems := generator.Validate(room.room, options)
+		if len(problems) == 0 {
+			fmt.Printf("PASS %s\n", room.name)
+			continue
+		}
+		bad++
+		fmt.Printf("FAIL %s\n", room.name)
+		for _, problem := range problems {
+			fmt.Printf("  %v\n", problem)
+		}
+		if *pngDir != "" {
+			savePreview(room, problems)
+		}
+	}
+	fmt.Printf("%d of %d rooms have problems\n", bad, len(rooms))
+	if bad > 0 {
+		os.Exit(1)
+	}
+}
@@ -1,6 +1,7 @@
 package effects
 
 import (
+	"encoding/gob"
 	"github.com/runningwild/magnus/game"
 	"github.com/runningwild/magnus/stats"
 )
@@ -21,6 +22,10 @@ type silence struct {
 	Ticker   int
 }
 
+func init() {
+	gob.Register(&silence{})
+}
+
 func (s *silence) Supply(mana game.Mana) game.Mana {
 	return mana
 }
@@ -0,0 +1,123 @@
+package game
+
+import (
+	"fmt"
+	"github.com/runningwild/cgf"
+	"math"
+	"sort"
+	"strings"
+)
+
+type AiDifficulty int
+
+const (
+	AiEasy AiDifficulty = iota
+	AiNormal
+	AiHard
+	numAiDifficulties
+)
+
+func (d AiDifficulty) String() string {
+	switch d {
+	case AiEasy:
+		return "Easy"
+	case AiNormal:
+		return "Normal"
+	case AiHard:
+		return "Hard"
+	}
+	return "Unknown"
+}
+
+// ParseAiDifficulty returns the difficulty named s, ignoring case.
+func ParseAiDifficulty(s string) (AiDifficulty, error) {
+	for d := AiEasy; d < numAiDifficulties; d++ {
+		if strings.EqualFold(s, d.String()) {
+			return d, nil
+		}
+	}
+	return AiNormal, fmt.Errorf("unknown ai difficulty %q", s)
+}
+
+// closest returns the closest ent that isn't on player's side, that player's
+// side can see and that match returns true for, or nil if there isn't one.
+// Ais only go after what a player on their side would be able to see.
+func (ai *AiPlayerData) closest(g *Game, player *PlayerEnt, match func(ent Ent) bool) Ent {
+	var best Ent
+	bestDist := math.Inf(1)
+	for _, ent := range g.temp.AllEnts {
+		if ent.Side() == player.Side() || ent.Dead() || !match(ent) {
+			continue
+		}
+		if !g.IsVisibleTo(ent, player.Side()) {
+			continue
+		}
+		if dist := ent.Pos().Sub(player.Pos()).Mag2(); dist < bestDist {
+			best = ent
+			bestDist = dist
+		}
+	}
+	return best
+}
+
+// Think returns the events that the ai controlling gid wants to apply this
+// frame.  Easy ais just drive forward, anything harder heads for the closest
+// enemy player that its side can see, or the closest control point that its
+// side can see and doesn't control if there aren't any.  Only the host should
+// call this, everyone else gets the events.
+func (ai *AiPlayerData) Think(g *Game, gid Gid) []cgf.Event {
+	player, ok := g.Ents[gid].(*PlayerEnt)
+	if !ok {
+		return nil
+	}
+	if ai.Difficulty == AiEasy {
+		return []cgf.Event{Accelerate{gid, 150}}
+	}
+
+	target := ai.closest(g, player, func(ent Ent) bool {
+		_, ok := ent.(*PlayerEnt)
+		return ok
+	})
+	if target == nil {
+		target = ai.closest(g, player, func(ent Ent) bool {
+			_, ok := ent.(*ControlPoint)
+			return ok
+		})
+	}
+	if target == nil {
+		return []cgf.Event{Accelerate{gid, 150}}
+	}
+
+	// Turn toward the target, and only go full speed once we're mostly facing
+	// it.
+	turn := target.Pos().Sub(player.Pos()).Angle() - player.Angle
+	for turn > math.Pi {
+		turn -= 2 * math.Pi
+	}
+	for turn < -math.Pi {
+		turn += 2 * math.Pi
+	}
+	acc := 200.0
+	if ai.Difficulty == AiHard {
+		acc = 300
+	}
+	acc *= math.Max(0, math.Cos(turn))
+	return []cgf.Event{Turn{gid, turn}, Accelerate{gid, acc}}
+}
+
+// AiEvents returns the events that every ai player wants to apply this frame,
+// in order of their engine ids.  Only the host should call this.
+func (g *Game) AiEvents() []cgf.Event {
+	var ids []int64
+	for id, engineData := range g.Engines {
+		if engineData.Ai != nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(int64Slice(ids))
+	var events []cgf.Event
+	for _, id := range ids {
+		events = append(events, g.Engines[id].Ai.Think(g, g.Engines[id].PlayerGid)...)
+	}
+	return events
+}
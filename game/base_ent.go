@@ -16,17 +16,28 @@ type BaseEnt struct {
 		Speed float64
 		Angle float64
 	}
-	Gid          Gid
-	Side_        int
+	Gid   Gid
+	Side_ int
+	// The ent that created this one, if any.  For example, a mine is owned by
+	// the player that placed it.
+	Owner_       Gid
 	CurrentLevel Gid
 	// Processes contains all of the processes that this player is casting
 	// right now.
 	Processes map[int]Process
+
+	// While RevealFrames is positive this ent can be seen by the other sides
+	// even if it is cloaked.  LastHealth is used to notice when it takes damage.
+	RevealFrames int
+	LastHealth   float64
 }
 
 func (b *BaseEnt) Side() int {
 	return b.Side_
 }
+func (b *BaseEnt) Owner() Gid {
+	return b.Owner_
+}
 func (b *BaseEnt) OnDeath(g *Game) {
 }
 func (b *BaseEnt) Walls() [][]linear.Vec2 {
@@ -37,6 +48,24 @@ func (b *BaseEnt) ApplyForce(f linear.Vec2) {
 	b.Velocity = b.Velocity.Add(f.Scale(1 / b.Mass()))
 }
 
+func (b *BaseEnt) Restitution() float64 {
+	return 0.5
+}
+
+func (b *BaseEnt) Immovable() bool {
+	return false
+}
+
+func (b *BaseEnt) Reveal(frames int) {
+	if frames > b.RevealFrames {
+		b.RevealFrames = frames
+	}
+}
+
+func (b *BaseEnt) Revealed() bool {
+	return b.RevealFrames > 0
+}
+
 func (b *BaseEnt) Stats() *stats.Inst {
 	return &b.StatsInst
 }
@@ -81,6 +110,14 @@ func (b *BaseEnt) Think(g *Game) {
 	// This will clear out old conditions
 	b.StatsInst.Think()
 
+	if b.RevealFrames > 0 {
+		b.RevealFrames--
+	}
+	if b.StatsInst.HealthCur() < b.LastHealth {
+		b.Reveal(RevealOnDamageFrames)
+	}
+	b.LastHealth = b.StatsInst.HealthCur()
+
 	var dead []int
 	// Calling DoOrdered is too slow, so we just sort the Gids ourselves and go
 	// through them in order.
@@ -104,6 +141,8 @@ func (b *BaseEnt) Think(g *Game) {
 		delete(b.Processes, id)
 	}
 
+	friction := g.applyHazards(b)
+
 	if b.Delta.Speed > b.StatsInst.MaxAcc() {
 		b.Delta.Speed = b.StatsInst.MaxAcc()
 	}
@@ -121,7 +160,6 @@ func (b *BaseEnt) Think(g *Game) {
 	b.ApplyForce((linear.Vec2{1, 0}).Rotate(b.Angle).Scale(b.Delta.Speed))
 
 	mangle := math.Atan2(b.Velocity.Y, b.Velocity.X)
-	friction := g.Friction
 	b.Velocity = b.Velocity.Scale(
 		math.Pow(friction, 1+3*math.Abs(math.Sin(b.Angle-mangle))))
 
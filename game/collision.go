@@ -0,0 +1,85 @@
+package game
+
+import (
+	"github.com/runningwild/linear"
+	"math"
+)
+
+const (
+	// Fraction of the overlap between two ents that is corrected each frame.
+	// This is done by nudging their velocities apart rather than moving them
+	// directly so that the normal wall collision code still gets a say.
+	collisionBias = 0.1
+
+	// Ents may overlap by this much before any correction is applied, this
+	// keeps ents that are resting against each other from jittering.
+	collisionSlop = 0.5
+)
+
+// inverseMass returns 1/mass, or 0 if the ent can't be moved.
+func inverseMass(ent Ent) float64 {
+	if ent.Immovable() || ent.Mass() <= 0 {
+		return 0
+	}
+	return 1 / ent.Mass()
+}
+
+// Knockback applies impulse to ent, changing its velocity by impulse/mass.
+// Abilities should use this rather than calling ApplyForce directly so that
+// immovable ents are left alone.
+func (g *Game) Knockback(ent Ent, impulse linear.Vec2) {
+	if inverseMass(ent) == 0 {
+		return
+	}
+	ent.ApplyForce(impulse)
+}
+
+// collide resolves a collision between two ents with an impulse along the
+// line between their centers.  The impulse depends on both masses, so a light
+// ent bounces off of a heavy one without moving it much, and on the lower of
+// the two restitutions.
+func (g *Game) collide(a, b Ent) {
+	// Projectiles do their own hit detection, and nothing bumps into smoke.
+	switch a.(type) {
+	case *Projectile, *Smoke:
+		return
+	}
+	switch b.(type) {
+	case *Projectile, *Smoke:
+		return
+	}
+	distSq := a.Pos().Sub(b.Pos()).Mag2()
+	colDist := a.Stats().Size() + b.Stats().Size()
+	if distSq > colDist*colDist {
+		return
+	}
+	if distSq < 0.0001 {
+		return
+	}
+	invA := inverseMass(a)
+	invB := inverseMass(b)
+	if invA+invB == 0 {
+		return
+	}
+	dist := math.Sqrt(distSq)
+	normal := a.Pos().Sub(b.Pos()).Scale(1 / dist)
+
+	// Only bounce ents that are moving towards each other, otherwise they're
+	// already separating.
+	closing := a.Vel().Sub(b.Vel()).Dot(normal)
+	var j float64
+	if closing < 0 {
+		restitution := math.Min(a.Restitution(), b.Restitution())
+		j = -(1 + restitution) * closing / (invA + invB)
+	}
+
+	// Push overlapping ents apart a little bit every frame.
+	if overlap := colDist - dist - collisionSlop; overlap > 0 {
+		j += collisionBias * overlap / (invA + invB)
+	}
+	if j == 0 {
+		return
+	}
+	g.Knockback(a, normal.Scale(j))
+	g.Knockback(b, normal.Scale(-j))
+}
@@ -0,0 +1,130 @@
+package game
+
+import (
+	"fmt"
+	"github.com/runningwild/magnus/stats"
+	"strings"
+)
+
+// DamageSource is the kind of thing that caused some damage, friendly fire
+// can be turned on or off separately for each one.
+type DamageSource int
+
+const (
+	DamageSourceAbility DamageSource = iota
+	DamageSourceMine
+	DamageSourceTower
+	DamageSourceEnvironment
+	numDamageSources
+)
+
+func (s DamageSource) String() string {
+	switch s {
+	case DamageSourceAbility:
+		return "ability"
+	case DamageSourceMine:
+		return "mine"
+	case DamageSourceTower:
+		return "tower"
+	case DamageSourceEnvironment:
+		return "environment"
+	}
+	return "unknown"
+}
+
+// A FriendlyFirePolicy says, for each source of damage, whether or not it
+// hurts ents on the same side as whatever caused it.  Any source that isn't in
+// the map never does.
+type FriendlyFirePolicy map[DamageSource]bool
+
+// FriendlyFireChoices are the policies that the host can choose between in the
+// lobby.
+var FriendlyFireChoices = []FriendlyFirePolicy{
+	{},
+	{DamageSourceMine: true, DamageSourceEnvironment: true},
+	{DamageSourceAbility: true, DamageSourceMine: true, DamageSourceTower: true, DamageSourceEnvironment: true},
+}
+
+// ParseFriendlyFire parses a comma separated list of damage sources, like
+// "mine,environment".  "all" turns on every source and "off" turns them all
+// off.
+func ParseFriendlyFire(s string) (FriendlyFirePolicy, error) {
+	policy := make(FriendlyFirePolicy)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "" || strings.EqualFold(name, "off"):
+			continue
+		case strings.EqualFold(name, "all"):
+			for source := DamageSource(0); source < numDamageSources; source++ {
+				policy[source] = true
+			}
+			continue
+		}
+		found := false
+		for source := DamageSource(0); source < numDamageSources; source++ {
+			if strings.EqualFold(name, source.String()) {
+				policy[source] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown damage source %q", name)
+		}
+	}
+	return policy, nil
+}
+
+// String lists the sources that friendly fire is on for, in order, or returns
+// "off" if there aren't any.
+func (p FriendlyFirePolicy) String() string {
+	var names []string
+	for source := DamageSource(0); source < numDamageSources; source++ {
+		if p[source] {
+			names = append(names, source.String())
+		}
+	}
+	if len(names) == 0 {
+		return "off"
+	}
+	return strings.Join(names, ",")
+}
+
+func (p FriendlyFirePolicy) copy() FriendlyFirePolicy {
+	policy := make(FriendlyFirePolicy)
+	for source, on := range p {
+		policy[source] = on
+	}
+	return policy
+}
+
+func (g *Game) friendlyFirePolicy() FriendlyFirePolicy {
+	switch {
+	case g.Moba != nil:
+		return g.Moba.FriendlyFire
+	case g.Standard != nil:
+		return g.Standard.FriendlyFire
+	}
+	return nil
+}
+
+// CanDamage returns true if damage from source, caused by something on side,
+// should be dealt to target.  A side of -1 means that it was caused by
+// something neutral which can damage anyone.
+func (g *Game) CanDamage(side int, target Ent, source DamageSource) bool {
+	if side < 0 || target.Side() != side {
+		return true
+	}
+	return g.friendlyFirePolicy()[source]
+}
+
+// DealDamage applies damage to target if the friendly fire policy allows it,
+// and returns true if it did.  All damage that one ent does to another should
+// go through here.
+func (g *Game) DealDamage(side int, target Ent, source DamageSource, damage stats.Damage) bool {
+	if !g.CanDamage(side, target, source) {
+		return false
+	}
+	target.Stats().ApplyDamage(damage)
+	return true
+}
@@ -0,0 +1,66 @@
+package game
+
+import (
+	"github.com/runningwild/linear"
+	"github.com/runningwild/magnus/stats"
+	"testing"
+)
+
+func TestParseFriendlyFire(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		want string
+	}{
+		{"", "off"},
+		{"off", "off"},
+		{"Mine, environment", "mine,environment"},
+		{"all", "ability,mine,tower,environment"},
+	} {
+		policy, err := ParseFriendlyFire(c.s)
+		if err != nil {
+			t.Errorf("ParseFriendlyFire(%q): %v", c.s, err)
+			continue
+		}
+		if got := policy.String(); got != c.want {
+			t.Errorf("ParseFriendlyFire(%q): got %s, want %s", c.s, got, c.want)
+		}
+	}
+	if _, err := ParseFriendlyFire("mine,lava"); err == nil {
+		t.Errorf("ParseFriendlyFire accepted an unknown source")
+	}
+}
+
+func TestPlacedHazardsFollowFriendlyFire(t *testing.T) {
+	g := makeVisibilityGame(t)
+	addVisibilityPlayer(g, "Engine:1", 0, linear.Vec2{200, 200}, 0)
+	addVisibilityPlayer(g, "Engine:2", 1, linear.Vec2{200, 300}, 0)
+	room := &g.Levels[GidInvadersStart].Room
+	for _, y := range []float64{200, 300} {
+		room.AddHazard(Hazard{
+			Region: linear.Poly{{190, y - 10}, {190, y + 10}, {210, y + 10}, {210, y - 10}},
+			Damage: stats.Damage{stats.DamageCrushing, 10},
+			Placed: true,
+			Side:   0,
+		})
+	}
+	hurt := func() (own, other bool) {
+		for gid, ent := range g.Ents {
+			before := ent.Stats().HealthCur()
+			g.applyHazards(&ent.(*PlayerEnt).BaseEnt)
+			damaged := ent.Stats().HealthCur() < before
+			if gid == "Engine:1" {
+				own = damaged
+			} else {
+				other = damaged
+			}
+		}
+		return
+	}
+	if own, other := hurt(); own || !other {
+		t.Errorf("With friendly fire off: own side hurt %t, other side hurt %t", own, other)
+	}
+	g.Moba.FriendlyFire = FriendlyFirePolicy{DamageSourceEnvironment: true}
+	if own, other := hurt(); !own || !other {
+		t.Errorf("With friendly fire on: own side hurt %t, other side hurt %t", own, other)
+	}
+}
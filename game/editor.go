@@ -0,0 +1,742 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+	gl "github.com/chsc/gogl/gl21"
+	"github.com/runningwild/glop/gin"
+	"github.com/runningwild/glop/gui"
+	"github.com/runningwild/linear"
+	"github.com/runningwild/magnus/base"
+	"github.com/runningwild/magnus/generator"
+	g2 "github.com/runningwild/magnus/gui"
+	"github.com/runningwild/magnus/los"
+	"github.com/runningwild/magnus/texture"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+type editorTool int
+
+const (
+	editorToolSelect editorTool = iota
+	editorToolWall
+	editorToolStart
+	editorToolEnd
+	editorToolPortal
+	editorToolBase
+	editorToolTower
+	editorToolControlPoint
+	editorToolManaSeed
+	numEditorTools
+)
+
+var editorToolNames = [numEditorTools]string{
+	"Select",
+	"Wall",
+	"Start",
+	"End",
+	"Portal",
+	"Base",
+	"Tower",
+	"Control point",
+	"Mana seed",
+}
+
+// What the editor's value, which is changed with Q and E, means for each tool.
+var editorValueNames = [numEditorTools]string{
+	editorToolStart:    "Side",
+	editorToolPortal:   "Destination",
+	editorToolBase:     "Side",
+	editorToolTower:    "Side",
+	editorToolManaSeed: "Color",
+}
+
+type editorItemKind int
+
+const (
+	editorItemNone editorItemKind = iota
+	editorItemWall
+	editorItemPortal
+	editorItemStart
+	editorItemEnd
+	editorItemBase
+	editorItemTower
+	editorItemManaSeed
+)
+
+// An editorItem is anything in a room that can be selected, moved and
+// deleted.  Walls and portals are identified by id, everything else by side
+// and index.  Control points are the towers on the last, neutral, side.
+type editorItem struct {
+	kind  editorItemKind
+	id    string
+	side  int
+	index int
+}
+
+const (
+	// Anything within this distance of the cursor can be picked up.
+	editorPickDist = 24
+
+	// Undo history is limited to this many edits.
+	editorMaxUndo = 100
+)
+
+var editorGridSizes = []float64{16, 32, 64, 128}
+
+type localEditorData struct {
+	camera cameraInfo
+
+	// The mode to go back to when leaving the editor.
+	prevMode LocalMode
+
+	// The room being edited, which is a copy of the room in the game and isn't
+	// seen by anyone else until it's saved and loaded into a new game.
+	room *Room
+
+	tool editorTool
+	// Side, color or portal destination, depending on the tool.
+	value int
+
+	snap bool
+	grid int // Index into editorGridSizes
+
+	// Vertices of the wall that is being drawn.
+	wall linear.Poly
+
+	selected  editorItem
+	dragging  bool
+	dragFrom  linear.Vec2
+	dragMoved bool
+
+	// Rooms before each edit that can be undone, and after each edit that can
+	// be redone, as json.
+	undo, redo [][]byte
+
+	// Rooms in the rooms directory, and the one that the editor saves to.
+	files []string
+	file  int
+
+	// Info saved with the room, from the file it was loaded from.
+	info generator.RoomInfo
+
+	// Status line shown along the bottom of the editor.
+	message string
+}
+
+func (e *localEditorData) start(room *Room) {
+	e.room = &Room{}
+	data, err := json.Marshal(room)
+	if err == nil {
+		err = json.Unmarshal(data, e.room)
+	}
+	if err != nil {
+		base.Error().Printf("Unable to copy room into the editor: %v", err)
+	}
+	e.room.fixNextId()
+	e.snap = true
+	e.grid = 1
+	e.listFiles()
+	e.file = len(e.files)
+	e.camera.limit.mid = linear.Vec2{}
+}
+
+func (e *localEditorData) roomsDir() string {
+	return filepath.Join(base.GetDataDir(), "rooms")
+}
+
+func (e *localEditorData) fileName() string {
+	if e.file < len(e.files) {
+		return e.files[e.file]
+	}
+	for i := 0; ; i++ {
+		name := filepath.Join(e.roomsDir(), fmt.Sprintf("room%d.json", i))
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			return name
+		}
+	}
+}
+
+func (e *localEditorData) listFiles() {
+	files, err := filepath.Glob(filepath.Join(e.roomsDir(), "*.json"))
+	if err != nil {
+		base.Error().Printf("Unable to list rooms: %v", err)
+	}
+	e.files = files
+}
+
+func (e *localEditorData) save() {
+	name := e.fileName()
+	if err := os.MkdirAll(e.roomsDir(), 0755); err != nil {
+		e.setMessage("Unable to save %s: %v", name, err)
+		return
+	}
+	// The room might have changed since the info was loaded, so anything that
+	// depends on the room is found again.
+	info := e.info
+	info.Sides = 0
+	info.Modes = nil
+	if info.Author == "" {
+		info.Author = os.Getenv("USER")
+	}
+	if err := SaveRoomFile(name, RoomFile{Info: info, Room: *e.room}); err != nil {
+		e.setMessage("Unable to save %s: %v", name, err)
+		return
+	}
+	e.listFiles()
+	for i := range e.files {
+		if e.files[i] == name {
+			e.file = i
+		}
+	}
+	var room generator.Room
+	data, err := json.Marshal(e.room)
+	if err == nil {
+		err = json.Unmarshal(data, &room)
+	}
+	if err != nil {
+		e.setMessage("Saved %s but unable to validate it: %v", name, err)
+		return
+	}
+	problems := generator.Validate(room, generator.ValidateOptions{})
+	for _, problem := range problems {
+		base.Warn().Printf("%s: %v", name, problem)
+	}
+	if len(problems) > 0 {
+		e.setMessage("Saved %s, it has %d problems, the first is %v", name, len(problems), problems[0])
+	} else {
+		e.setMessage("Saved %s", name)
+	}
+}
+
+func (e *localEditorData) load() {
+	if e.file >= len(e.files) {
+		e.setMessage("Nothing to load, %s doesn't exist yet", e.fileName())
+		return
+	}
+	file, err := LoadRoomFile(e.files[e.file])
+	if err != nil {
+		e.setMessage("Unable to load %s: %v", e.files[e.file], err)
+		return
+	}
+	e.edit()
+	*e.room = file.Room
+	e.room.fixNextId()
+	e.info = file.Info
+	e.wall = nil
+	e.selected = editorItem{}
+	e.camera.limit.mid = linear.Vec2{}
+	e.setMessage("Loaded %s", e.files[e.file])
+}
+
+func (e *localEditorData) setMessage(format string, args ...interface{}) {
+	e.message = fmt.Sprintf(format, args...)
+	base.Log().Printf("Editor: %s", e.message)
+}
+
+// edit must be called before every change to the room so that it can be
+// undone.
+func (e *localEditorData) edit() {
+	data, err := json.Marshal(e.room)
+	if err != nil {
+		base.Error().Printf("Unable to save undo state: %v", err)
+		return
+	}
+	e.undo = append(e.undo, data)
+	if len(e.undo) > editorMaxUndo {
+		e.undo = e.undo[1:]
+	}
+	e.redo = nil
+}
+
+// swapHistory undoes the last edit if from is the undo stack, or redoes the
+// last undone edit if from is the redo stack.
+func (e *localEditorData) swapHistory(from, to *[][]byte) bool {
+	if len(*from) == 0 {
+		return false
+	}
+	current, err := json.Marshal(e.room)
+	if err != nil {
+		base.Error().Printf("Unable to save undo state: %v", err)
+		return false
+	}
+	var room Room
+	if err := json.Unmarshal((*from)[len(*from)-1], &room); err != nil {
+		base.Error().Printf("Unable to restore undo state: %v", err)
+		return false
+	}
+	*from = (*from)[:len(*from)-1]
+	*to = append(*to, current)
+	*e.room = room
+	e.selected = editorItem{}
+	return true
+}
+
+func (e *localEditorData) snapTo(v linear.Vec2) linear.Vec2 {
+	if !e.snap {
+		return v
+	}
+	size := editorGridSizes[e.grid]
+	return linear.Vec2{math.Floor(v.X/size+0.5) * size, math.Floor(v.Y/size+0.5) * size}
+}
+
+// sideData returns the moba data for side, adding sides as needed.  The last
+// side is always the neutral one.
+func (e *localEditorData) sideData(side int) *mobaRoomSideData {
+	data := &e.room.Moba.SideData
+	if len(*data) == 0 {
+		*data = append(*data, mobaRoomSideData{})
+	}
+	for len(*data) < side+2 {
+		neutral := (*data)[len(*data)-1]
+		(*data)[len(*data)-1] = mobaRoomSideData{}
+		*data = append(*data, neutral)
+	}
+	return &(*data)[side]
+}
+
+func (e *localEditorData) neutralData() *mobaRoomSideData {
+	if len(e.room.Moba.SideData) == 0 {
+		e.room.Moba.SideData = append(e.room.Moba.SideData, mobaRoomSideData{})
+	}
+	return &e.room.Moba.SideData[len(e.room.Moba.SideData)-1]
+}
+
+// points returns every item that is a single point, along with where it is.
+func (e *localEditorData) points() ([]editorItem, []linear.Vec2) {
+	var items []editorItem
+	var poss []linear.Vec2
+	for i, pos := range e.room.Starts {
+		items = append(items, editorItem{kind: editorItemStart, index: i})
+		poss = append(poss, pos)
+	}
+	if e.room.End != (linear.Vec2{}) {
+		items = append(items, editorItem{kind: editorItemEnd})
+		poss = append(poss, e.room.End)
+	}
+	for side, data := range e.room.Moba.SideData {
+		if data.Base != (linear.Vec2{}) {
+			items = append(items, editorItem{kind: editorItemBase, side: side})
+			poss = append(poss, data.Base)
+		}
+		for i, pos := range data.Towers {
+			items = append(items, editorItem{kind: editorItemTower, side: side, index: i})
+			poss = append(poss, pos)
+		}
+	}
+	for i, seed := range e.room.Mana.Seeds {
+		items = append(items, editorItem{kind: editorItemManaSeed, index: i})
+		poss = append(poss, seed.Pos)
+	}
+	return items, poss
+}
+
+// itemAt returns the item under v.  Points take priority over portals, which
+// take priority over walls.
+func (e *localEditorData) itemAt(v linear.Vec2) editorItem {
+	items, poss := e.points()
+	best, bestDist := editorItem{}, float64(editorPickDist)
+	for i := range items {
+		if dist := poss[i].Sub(v).Mag(); dist < bestDist {
+			best, bestDist = items[i], dist
+		}
+	}
+	if best.kind != editorItemNone {
+		return best
+	}
+	base.DoOrdered(e.room.Portals, func(a, b string) bool { return a < b }, func(id string, portal Portal) {
+		if best.kind == editorItemNone && los.PolyContains(portal.Region, v) {
+			best = editorItem{kind: editorItemPortal, id: id}
+		}
+	})
+	if best.kind != editorItemNone {
+		return best
+	}
+	// The outline of the room can't be selected.
+	base.DoOrdered(e.room.Walls, func(a, b string) bool { return a < b }, func(id string, wall linear.Poly) {
+		if best.kind != editorItemNone || generator.IsBoundary(wall, e.room.Dx, e.room.Dy) {
+			return
+		}
+		near := los.PolyContains(wall, v)
+		for i := range wall {
+			near = near || distSquaredToSeg(v, wall.Seg(i)) < editorPickDist*editorPickDist/4
+		}
+		if near {
+			best = editorItem{kind: editorItemWall, id: id}
+		}
+	})
+	return best
+}
+
+// itemPos returns a pointer to the position of a point item.
+func (e *localEditorData) itemPos(item editorItem) *linear.Vec2 {
+	switch item.kind {
+	case editorItemStart:
+		return &e.room.Starts[item.index]
+	case editorItemEnd:
+		return &e.room.End
+	case editorItemBase:
+		return &e.room.Moba.SideData[item.side].Base
+	case editorItemTower:
+		return &e.room.Moba.SideData[item.side].Towers[item.index]
+	case editorItemManaSeed:
+		return &e.room.Mana.Seeds[item.index].Pos
+	}
+	return nil
+}
+
+func (e *localEditorData) moveItem(item editorItem, delta linear.Vec2) {
+	var poly linear.Poly
+	switch item.kind {
+	case editorItemNone:
+		return
+	case editorItemWall:
+		poly = e.room.Walls[item.id]
+	case editorItemPortal:
+		poly = e.room.Portals[item.id].Region
+	default:
+		pos := e.itemPos(item)
+		*pos = pos.Add(delta)
+		return
+	}
+	for i := range poly {
+		poly[i] = poly[i].Add(delta)
+	}
+}
+
+func (e *localEditorData) deleteItem(item editorItem) {
+	switch item.kind {
+	case editorItemWall:
+		delete(e.room.Walls, item.id)
+	case editorItemPortal:
+		delete(e.room.Portals, item.id)
+	case editorItemStart:
+		e.room.Starts = append(e.room.Starts[:item.index], e.room.Starts[item.index+1:]...)
+	case editorItemEnd:
+		e.room.End = linear.Vec2{}
+	case editorItemBase:
+		e.room.Moba.SideData[item.side].Base = linear.Vec2{}
+	case editorItemTower:
+		towers := e.room.Moba.SideData[item.side].Towers
+		e.room.Moba.SideData[item.side].Towers = append(towers[:item.index], towers[item.index+1:]...)
+	case editorItemManaSeed:
+		e.room.Mana.Seeds = append(e.room.Mana.Seeds[:item.index], e.room.Mana.Seeds[item.index+1:]...)
+	}
+}
+
+// place does whatever the current tool does at pos, other than selecting.
+func (e *localEditorData) place(pos linear.Vec2) {
+	room := e.room
+	switch e.tool {
+	case editorToolWall:
+		e.wall = append(e.wall, pos)
+		return
+	case editorToolStart:
+		e.edit()
+		if e.value < len(room.Starts) {
+			room.Starts[e.value] = pos
+		} else {
+			room.Starts = append(room.Starts, pos)
+		}
+	case editorToolEnd:
+		e.edit()
+		room.End = pos
+	case editorToolPortal:
+		e.edit()
+		if room.Portals == nil {
+			room.Portals = make(map[string]Portal)
+		}
+		size := editorGridSizes[e.grid] / 2
+		room.Portals[fmt.Sprintf("%d", room.NextId)] = Portal{
+			Region: linear.Poly{
+				pos.Add(linear.Vec2{-size, size}),
+				pos.Add(linear.Vec2{size, size}),
+				pos.Add(linear.Vec2{size, -size}),
+				pos.Add(linear.Vec2{-size, -size}),
+			},
+			Dest: e.value,
+		}
+		room.NextId++
+	case editorToolBase:
+		e.edit()
+		e.sideData(e.value).Base = pos
+	case editorToolTower:
+		e.edit()
+		data := e.sideData(e.value)
+		data.Towers = append(data.Towers, pos)
+	case editorToolControlPoint:
+		e.edit()
+		data := e.neutralData()
+		data.Towers = append(data.Towers, pos)
+	case editorToolManaSeed:
+		e.edit()
+		room.Mana.Seeds = append(room.Mana.Seeds, ManaSeed{pos, e.value % len(AllColors)})
+	}
+}
+
+// finishWall adds the wall that is being drawn to the room, wound so that ents
+// collide with it from the outside.
+func (e *localEditorData) finishWall() {
+	if len(e.wall) < 3 {
+		e.setMessage("A wall needs at least 3 vertices")
+		return
+	}
+	wall := e.wall
+	if wall.IsCounterClockwise() {
+		for i, j := 0, len(wall)-1; i < j; i, j = i+1, j-1 {
+			wall[i], wall[j] = wall[j], wall[i]
+		}
+	}
+	e.edit()
+	if e.room.Walls == nil {
+		e.room.Walls = make(map[string]linear.Poly)
+	}
+	e.room.AddWall(wall)
+	e.wall = nil
+}
+
+func (l *LocalData) localThinkEditor(g *Game) {
+	e := &l.editor
+	if e.room == nil {
+		e.start(&g.Levels[GidInvadersStart].Room)
+	}
+	keys := []gin.KeyId{gin.AnyKey1, gin.AnyKey2, gin.AnyKey3, gin.AnyKey4, gin.AnyKey5, gin.AnyKey6, gin.AnyKey7, gin.AnyKey8, gin.AnyKey9}
+	for i, key := range keys {
+		if gin.In().GetKey(key).FramePressCount() > 0 {
+			e.tool = editorTool(i)
+			e.wall = nil
+			e.selected = editorItem{}
+		}
+	}
+	pressed := func(key gin.KeyId) bool {
+		return gin.In().GetKey(key).FramePressCount() > 0
+	}
+	if pressed(gin.AnyKeyQ) && e.value > 0 {
+		e.value--
+	}
+	if pressed(gin.AnyKeyE) {
+		e.value++
+	}
+	if pressed(gin.AnyKeyG) {
+		e.snap = !e.snap
+	}
+	if pressed(gin.AnyKeyT) {
+		e.grid = (e.grid + 1) % len(editorGridSizes)
+	}
+	if pressed(gin.AnyKeyZ) && !e.swapHistory(&e.undo, &e.redo) {
+		e.setMessage("Nothing to undo")
+	}
+	if pressed(gin.AnyKeyY) && !e.swapHistory(&e.redo, &e.undo) {
+		e.setMessage("Nothing to redo")
+	}
+	if pressed(gin.AnyKeyO) {
+		e.listFiles()
+		e.file = (e.file + 1) % (len(e.files) + 1)
+	}
+	if pressed(gin.AnyKeyL) {
+		e.load()
+	}
+	if pressed(gin.AnyKeyK) {
+		e.save()
+	}
+	if pressed(gin.AnyKeyN) {
+		e.edit()
+		dx, dy := e.room.Dx, e.room.Dy
+		*e.room = Room{Dx: dx, Dy: dy, Walls: make(map[string]linear.Poly)}
+		e.room.AddWall(linear.Poly{
+			linear.Vec2{0, 0},
+			linear.Vec2{float64(dx), 0},
+			linear.Vec2{float64(dx), float64(dy)},
+			linear.Vec2{0, float64(dy)},
+		})
+		e.file = len(e.files)
+		e.info = generator.RoomInfo{}
+		e.setMessage("New room, it will be saved to %s", e.fileName())
+	}
+	if pressed(gin.AnyReturn) && e.tool == editorToolWall {
+		e.finishWall()
+	}
+	if pressed(gin.AnyBackspace) || pressed(gin.AnyDelete) {
+		if e.tool == editorToolWall && len(e.wall) > 0 {
+			e.wall = e.wall[:len(e.wall)-1]
+		} else if e.selected.kind != editorItemNone {
+			e.edit()
+			e.deleteItem(e.selected)
+			e.selected = editorItem{}
+		}
+	}
+
+	mouse := e.snapTo(e.camera.mouseToWorld(l.sys))
+	button := gin.In().GetKey(gin.AnyMouseLButton)
+	if button.FramePressCount() > 0 {
+		if e.tool == editorToolSelect {
+			e.selected = e.itemAt(e.camera.mouseToWorld(l.sys))
+			e.dragging = e.selected.kind != editorItemNone
+			e.dragFrom = mouse
+			e.dragMoved = false
+		} else {
+			e.place(mouse)
+		}
+	}
+	if e.dragging {
+		if delta := mouse.Sub(e.dragFrom); delta != (linear.Vec2{}) {
+			if !e.dragMoved {
+				e.edit()
+				e.dragMoved = true
+			}
+			e.moveItem(e.selected, delta)
+			e.dragFrom = mouse
+		}
+		if !button.IsDown() {
+			e.dragging = false
+		}
+	}
+}
+
+var editorColors = [][3]byte{{255, 80, 80}, {80, 255, 80}, {80, 80, 255}}
+
+func renderEditorPoint(pos linear.Vec2, text string, color [3]byte, selected bool) {
+	alpha := gl.Ubyte(150)
+	if selected {
+		alpha = 255
+	}
+	base.EnableShader("circle")
+	base.SetUniformF("circle", "edge", 0.8)
+	gl.Color4ub(gl.Ubyte(color[0]), gl.Ubyte(color[1]), gl.Ubyte(color[2]), alpha)
+	texture.Render(pos.X-editorPickDist/2, pos.Y-editorPickDist/2, editorPickDist, editorPickDist)
+	base.EnableShader("")
+	gui.SetFontColor(float64(color[0])/255, float64(color[1])/255, float64(color[2])/255, 1)
+	base.GetDictionary("luxisr").RenderString(text, pos.X, pos.Y+editorPickDist, 0, 30, gui.Center)
+}
+
+func renderEditorPoly(poly linear.Poly, closed bool) {
+	gl.Begin(gl.LINES)
+	for i := range poly {
+		if i == len(poly)-1 && !closed {
+			break
+		}
+		seg := poly.Seg(i)
+		gl.Vertex2d(gl.Double(seg.P.X), gl.Double(seg.P.Y))
+		gl.Vertex2d(gl.Double(seg.Q.X), gl.Double(seg.Q.Y))
+	}
+	gl.End()
+}
+
+func (g *Game) renderLocalEditor(region g2.Region, local *LocalData) {
+	e := &local.editor
+	if e.room == nil {
+		return
+	}
+	room := e.room
+	e.camera.doArchitectFocusRegion(room, local.sys)
+	gl.MatrixMode(gl.PROJECTION)
+	gl.PushMatrix()
+	gl.LoadIdentity()
+	gl.PushAttrib(gl.VIEWPORT_BIT)
+	gl.Viewport(gl.Int(region.X), gl.Int(region.Y), gl.Sizei(region.Dx), gl.Sizei(region.Dy))
+	current := e.camera.current
+	gl.Ortho(
+		gl.Double(current.mid.X-current.dims.X/2),
+		gl.Double(current.mid.X+current.dims.X/2),
+		gl.Double(current.mid.Y+current.dims.Y/2),
+		gl.Double(current.mid.Y-current.dims.Y/2),
+		gl.Double(1000),
+		gl.Double(-1000),
+	)
+	gl.MatrixMode(gl.MODELVIEW)
+	gl.Enable(gl.BLEND)
+	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+	gl.Disable(gl.TEXTURE_2D)
+
+	if e.snap {
+		size := editorGridSizes[e.grid]
+		gl.Color4ub(255, 255, 255, 30)
+		gl.Begin(gl.LINES)
+		for x := 0.0; x <= float64(room.Dx); x += size {
+			gl.Vertex2d(gl.Double(x), 0)
+			gl.Vertex2d(gl.Double(x), gl.Double(room.Dy))
+		}
+		for y := 0.0; y <= float64(room.Dy); y += size {
+			gl.Vertex2d(0, gl.Double(y))
+			gl.Vertex2d(gl.Double(room.Dx), gl.Double(y))
+		}
+		gl.End()
+	}
+
+	base.DoOrdered(room.Walls, func(a, b string) bool { return a < b }, func(id string, wall linear.Poly) {
+		switch {
+		case e.selected.kind == editorItemWall && e.selected.id == id:
+			gl.Color4ub(255, 255, 0, 255)
+		case wall.IsCounterClockwise() && !generator.IsBoundary(wall, e.room.Dx, e.room.Dy):
+			// Ents can walk right through these.
+			gl.Color4ub(255, 0, 0, 255)
+		default:
+			gl.Color4ub(255, 255, 255, 255)
+		}
+		renderEditorPoly(wall, true)
+	})
+	base.DoOrdered(room.Portals, func(a, b string) bool { return a < b }, func(id string, portal Portal) {
+		if e.selected.kind == editorItemPortal && e.selected.id == id {
+			gl.Color4ub(255, 255, 0, 255)
+		} else {
+			gl.Color4ub(200, 0, 255, 255)
+		}
+		renderEditorPoly(portal.Region, true)
+	})
+	if len(e.wall) > 0 {
+		gl.Color4ub(0, 255, 255, 255)
+		renderEditorPoly(append(e.wall, e.snapTo(e.camera.mouseToWorld(local.sys))), false)
+	}
+
+	items, poss := e.points()
+	for i, item := range items {
+		selected := item == e.selected
+		switch item.kind {
+		case editorItemStart:
+			renderEditorPoint(poss[i], fmt.Sprintf("S%d", item.index), [3]byte{0, 255, 0}, selected)
+		case editorItemEnd:
+			renderEditorPoint(poss[i], "End", [3]byte{255, 255, 255}, selected)
+		case editorItemBase:
+			renderEditorPoint(poss[i], fmt.Sprintf("B%d", item.side), [3]byte{255, 200, 0}, selected)
+		case editorItemTower:
+			if item.side == len(room.Moba.SideData)-1 {
+				renderEditorPoint(poss[i], "CP", [3]byte{200, 200, 200}, selected)
+			} else {
+				renderEditorPoint(poss[i], fmt.Sprintf("T%d", item.side), [3]byte{255, 120, 0}, selected)
+			}
+		case editorItemManaSeed:
+			color := room.Mana.Seeds[item.index].Color
+			renderEditorPoint(poss[i], "Mana", editorColors[color%len(editorColors)], selected)
+		}
+	}
+	base.DoOrdered(room.Portals, func(a, b string) bool { return a < b }, func(id string, portal Portal) {
+		center := generator.Centroid(portal.Region)
+		gui.SetFontColor(0.8, 0, 1, 1)
+		base.GetDictionary("luxisr").RenderString(fmt.Sprintf("P%d", portal.Dest), center.X, center.Y, 0, 30, gui.Center)
+	})
+
+	gl.MatrixMode(gl.PROJECTION)
+	gl.PopMatrix()
+	gl.PopAttrib()
+	gl.MatrixMode(gl.MODELVIEW)
+
+	// Status along the bottom of the region.
+	dict := base.GetDictionary("luxisr")
+	size := 25.0
+	status := editorToolNames[e.tool]
+	if name := editorValueNames[e.tool]; name != "" {
+		status += fmt.Sprintf("  %s: %d", name, e.value)
+	}
+	if e.snap {
+		status += fmt.Sprintf("  Grid: %v", editorGridSizes[e.grid])
+	}
+	status += fmt.Sprintf("  File: %s", filepath.Base(e.fileName()))
+	gui.SetFontColor(1, 1, 1, 1)
+	x := float64(region.X) + size
+	y := float64(region.Y) + size
+	dict.RenderString(status, x, y, 0, size, gui.Left)
+	dict.RenderString(e.message, x, y+size, 0, size, gui.Left)
+	dict.RenderString("1-9 tools  Q/E value  G snap  T grid  Z/Y undo/redo  Return close wall  Delete remove  O file  K save  L load  N new", x, y+2*size, 0, size, gui.Left)
+}
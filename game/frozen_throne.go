@@ -1,6 +1,7 @@
 package game
 
 import (
+	"encoding/gob"
 	"github.com/runningwild/linear"
 	"github.com/runningwild/magnus/los"
 	"github.com/runningwild/magnus/stats"
@@ -12,6 +13,10 @@ type FrozenThrone struct {
 	Los *los.Los
 }
 
+func init() {
+	gob.Register(&FrozenThrone{})
+}
+
 func (g *Game) MakeFrozenThrones() {
 	for i, data := range g.Levels[GidInvadersStart].Room.Moba.SideData {
 		ft := FrozenThrone{
@@ -23,18 +28,24 @@ func (g *Game) MakeFrozenThrones() {
 			Los: los.Make(LosMaxDist),
 		}
 		ft.BaseEnt.StatsInst = stats.Make(stats.Base{
-			Health: 100000,
-			Mass:   1000000,
-			Rate:   1,
-			Size:   100,
-			Vision: 900,
+			Health:    100000,
+			Mass:      1000000,
+			Rate:      1,
+			Size:      100,
+			Vision:    900,
+			Detection: 500,
 		})
 		g.AddEnt(&ft)
 	}
 }
 
-func (ft *FrozenThrone) Draw(g *Game, side int) {}
-func (ft *FrozenThrone) Supply(mana Mana) Mana  { return Mana{} }
+func (ft *FrozenThrone) Draw(g *Game, side int)   {}
+func (ft *FrozenThrone) Supply(mana Mana) Mana    { return Mana{} }
+func (ft *FrozenThrone) Immovable() bool          { return true }
+func (ft *FrozenThrone) ApplyForce(f linear.Vec2) {}
+func (ft *FrozenThrone) Occlusion() (Occlusion, bool) {
+	return Occlusion{Poly: ft.Walls()[0]}, true
+}
 func (ft *FrozenThrone) Walls() [][]linear.Vec2 {
 	return [][]linear.Vec2{
 		[]linear.Vec2{
@@ -2,7 +2,6 @@ package game
 
 import (
 	"encoding/gob"
-	"encoding/json"
 	"fmt"
 	gl "github.com/chsc/gogl/gl21"
 	"github.com/runningwild/cgf"
@@ -12,12 +11,12 @@ import (
 	"github.com/runningwild/linear"
 	"github.com/runningwild/magnus/base"
 	"github.com/runningwild/magnus/champ"
-	"github.com/runningwild/magnus/generator"
 	"github.com/runningwild/magnus/gui"
+	"github.com/runningwild/magnus/los"
 	"github.com/runningwild/magnus/stats"
 	"github.com/runningwild/magnus/texture"
-	"math"
 	"path/filepath"
+	"sort"
 )
 
 // type Ability func(game *Game, player *PlayerEnt, params map[string]int) Process
@@ -50,6 +49,14 @@ type Ability interface {
 
 type AbilityMaker func(params map[string]int) Ability
 
+// An AbilityEvent is an event that an Ability applies for a single player.
+// Every event that an Ability returns should be one, a server that doesn't
+// trust its clients only accepts them from the client that plays Player.
+type AbilityEvent interface {
+	cgf.Event
+	Player() Gid
+}
+
 var ability_makers map[string]AbilityMaker
 
 func RegisterAbility(name string, maker AbilityMaker) {
@@ -59,6 +66,16 @@ func RegisterAbility(name string, maker AbilityMaker) {
 	ability_makers[name] = maker
 }
 
+// MakeAbility returns a new instance of the Ability registered under name, or
+// nil if there is no such Ability.
+func MakeAbility(name string, params map[string]int) Ability {
+	maker, ok := ability_makers[name]
+	if !ok {
+		return nil
+	}
+	return maker(params)
+}
+
 type EffectMaker func(params map[string]int) Process
 
 var effect_makers map[string]EffectMaker
@@ -184,7 +201,9 @@ func init() {
 func (p *PlayerEnt) Draw(game *Game, side int) {
 	var t *texture.Data
 	var alpha gl.Ubyte
-	if side == p.Side() {
+	// A cloaked player on another side is only drawn if it has been revealed or
+	// detected, in which case it is drawn just like a cloaked teammate.
+	if side == p.Side() || p.Stats().Cloaking() >= CloakedThreshold {
 		alpha = gl.Ubyte(255.0 * (1.0 - p.Stats().Cloaking()/2))
 	} else {
 		alpha = gl.Ubyte(255.0 * (1.0 - p.Stats().Cloaking()))
@@ -234,9 +253,20 @@ func (p *PlayerEnt) Think(g *Game) {
 	p.BaseEnt.Think(g)
 }
 
+func (p *PlayerEnt) DrainsMana() bool {
+	return true
+}
+
 func (p *PlayerEnt) Supply(supply Mana) Mana {
-	for _, process := range p.Processes {
-		supply = process.Supply(supply)
+	// Processes are supplied in a fixed order, otherwise which one gets the
+	// mana when there isn't enough to go around would differ between clients.
+	pids := make([]int, len(p.Processes))[0:0]
+	for pid := range p.Processes {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	for _, pid := range pids {
+		supply = p.Processes[pid].Supply(supply)
 	}
 	return supply
 }
@@ -246,6 +276,17 @@ type Ent interface {
 	Think(game *Game)
 	ApplyForce(force linear.Vec2)
 
+	// How bouncy this ent is when it collides with another ent, from 0 to 1.
+	Restitution() float64
+
+	// Immovable ents are never displaced by collisions or knockback.
+	Immovable() bool
+
+	// Reveal makes this ent visible to every side for at least frames frames,
+	// regardless of cloaking.
+	Reveal(frames int)
+	Revealed() bool
+
 	// If this Ent is immovable it may provide walls that will be considered just
 	// like normal walls.
 	// TODO: Decide whether or not to actually support this
@@ -262,7 +303,8 @@ type Ent interface {
 	SetId(Gid)
 	Pos() linear.Vec2
 	Level() Gid
-	Side() int // which side the ent belongs to
+	Side() int  // which side the ent belongs to
+	Owner() Gid // which ent created this one, if any
 
 	// Need to have a SetPos method because we don't want ents moving through
 	// walls.
@@ -290,6 +332,11 @@ type Level struct {
 type SetupSideData struct {
 	Side  int
 	Champ int
+	Name  string
+	Ready bool
+
+	// Non-nil for ai players added by the host.
+	Ai *AiPlayerData
 }
 
 // Used before the 'game' starts to choose sides and characters and whatnot.
@@ -298,10 +345,87 @@ type Setup struct {
 	EngineIds []int64                  // engine ids of the engines currently joined
 	Sides     map[int64]*SetupSideData // map from engineid to side data
 	Seed      int64                    // random seed
+	Map       SetupMap                 // map chosen by the host
+	LastAiId  int64                    // ai players get negative ids, counting down from here
+	Host      int64                    // engine id of the host, who doesn't have to be ready
+
+	// Friendly fire policy chosen by the host, for whichever mode is played.
+	FriendlyFire FriendlyFirePolicy
+}
+
+// Members returns the ids of every engine that is currently joined and every
+// ai player, ordered by side and then by id.
+func (s *Setup) Members() []int64 {
+	var ids []int64
+	for _, id := range s.EngineIds {
+		if s.Sides[id] != nil && s.Sides[id].Ai == nil {
+			ids = append(ids, id)
+		}
+	}
+	for id, data := range s.Sides {
+		if data.Ai != nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(int64Slice(ids))
+	sort.Stable(setupMembersBySide{ids, s.Sides})
+	return ids
+}
+
+type setupMembersBySide struct {
+	ids   []int64
+	sides map[int64]*SetupSideData
 }
 
+func (s setupMembersBySide) Len() int { return len(s.ids) }
+func (s setupMembersBySide) Less(i, j int) bool {
+	return s.sides[s.ids[i]].Side < s.sides[s.ids[j]].Side
+}
+func (s setupMembersBySide) Swap(i, j int) { s.ids[i], s.ids[j] = s.ids[j], s.ids[i] }
+
+// Waiting returns the ids of every engine that isn't ready yet, other than
+// except.  Ai players are always ready.
+func (s *Setup) Waiting(except int64) []int64 {
+	var ids []int64
+	for _, id := range s.Members() {
+		data := s.Sides[id]
+		if id != except && data.Ai == nil && !data.Ready {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// PlayerName returns the name to show for id.
+func (s *Setup) PlayerName(id int64) string {
+	data := s.Sides[id]
+	switch {
+	case data == nil:
+		return fmt.Sprintf("Engine %d", id)
+	case data.Ai != nil:
+		return fmt.Sprintf("Ai %d (%s)", -id, data.Ai.Difficulty)
+	case data.Name != "":
+		return data.Name
+	}
+	return fmt.Sprintf("Engine %d", id)
+}
+
+// numSides returns the number of sides that the map needs starts for.
+func (s *Setup) numSides() int {
+	numSides := 2
+	for _, id := range s.Members() {
+		if side := s.Sides[id].Side; side+1 > numSides {
+			numSides = side + 1
+		}
+	}
+	return numSides
+}
+
+// SetupSetEngineIds is sent by the host whenever the engines that are joined
+// change.
 type SetupSetEngineIds struct {
 	EngineIds []int64
+	Host      int64
 }
 
 func (s SetupSetEngineIds) Apply(_g interface{}) {
@@ -310,6 +434,7 @@ func (s SetupSetEngineIds) Apply(_g interface{}) {
 		return
 	}
 	g.Setup.EngineIds = s.EngineIds
+	g.Setup.Host = s.Host
 	for _, id := range g.Setup.EngineIds {
 		if _, ok := g.Setup.Sides[id]; !ok {
 			g.Setup.Sides[id] = &SetupSideData{}
@@ -330,7 +455,11 @@ func (s SetupChangeSides) Apply(_g interface{}) {
 	if g.Setup == nil {
 		return
 	}
-	g.Setup.Sides[s.EngineId].Side = s.Side
+	sideData := g.Setup.Sides[s.EngineId]
+	if sideData == nil {
+		return
+	}
+	sideData.Side = s.Side
 }
 func init() {
 	gob.Register(SetupChangeSides{})
@@ -362,6 +491,142 @@ func (s SetupChampSelect) Apply(_g interface{}) {
 	}
 }
 
+type SetupSetName struct {
+	EngineId int64
+	Name     string
+}
+
+func init() {
+	gob.Register(SetupSetName{})
+}
+func (s SetupSetName) Apply(_g interface{}) {
+	g := _g.(*Game)
+	if g.Setup == nil {
+		return
+	}
+	if sideData := g.Setup.Sides[s.EngineId]; sideData != nil {
+		sideData.Name = s.Name
+	}
+}
+
+type SetupSetReady struct {
+	EngineId int64
+	Ready    bool
+}
+
+func init() {
+	gob.Register(SetupSetReady{})
+}
+func (s SetupSetReady) Apply(_g interface{}) {
+	g := _g.(*Game)
+	if g.Setup == nil {
+		return
+	}
+	if sideData := g.Setup.Sides[s.EngineId]; sideData != nil {
+		sideData.Ready = s.Ready
+	}
+}
+
+// SetupAddAi adds an ai player to Side.  It gets the next unused negative id.
+type SetupAddAi struct {
+	Side       int
+	Champ      int
+	Difficulty AiDifficulty
+}
+
+func init() {
+	gob.Register(SetupAddAi{})
+}
+func (s SetupAddAi) Apply(_g interface{}) {
+	g := _g.(*Game)
+	if g.Setup == nil {
+		return
+	}
+	g.Setup.LastAiId--
+	g.Setup.Sides[g.Setup.LastAiId] = &SetupSideData{
+		Side:  s.Side,
+		Champ: s.Champ,
+		Ai:    &AiPlayerData{Difficulty: s.Difficulty},
+	}
+}
+
+type SetupRemoveAi struct {
+	EngineId int64
+}
+
+func init() {
+	gob.Register(SetupRemoveAi{})
+}
+func (s SetupRemoveAi) Apply(_g interface{}) {
+	g := _g.(*Game)
+	if g.Setup == nil {
+		return
+	}
+	if sideData := g.Setup.Sides[s.EngineId]; sideData != nil && sideData.Ai != nil {
+		delete(g.Setup.Sides, s.EngineId)
+	}
+}
+
+type SetupAiDifficulty struct {
+	EngineId   int64
+	Difficulty AiDifficulty
+}
+
+func init() {
+	gob.Register(SetupAiDifficulty{})
+}
+func (s SetupAiDifficulty) Apply(_g interface{}) {
+	g := _g.(*Game)
+	if g.Setup == nil {
+		return
+	}
+	if sideData := g.Setup.Sides[s.EngineId]; sideData != nil && sideData.Ai != nil {
+		sideData.Ai.Difficulty = s.Difficulty
+	}
+}
+
+type SetupSelectMap struct {
+	Map SetupMap
+}
+
+func init() {
+	gob.Register(SetupSelectMap{})
+}
+func (s SetupSelectMap) Apply(_g interface{}) {
+	g := _g.(*Game)
+	if g.Setup == nil {
+		return
+	}
+	g.Setup.Map = s.Map
+
+	// Everyone should get a chance to see the new map before the game starts.
+	for _, sideData := range g.Setup.Sides {
+		sideData.Ready = false
+	}
+}
+
+// SetupFriendlyFire sets the friendly fire policy for the match, only the host
+// sends it.
+type SetupFriendlyFire struct {
+	Policy FriendlyFirePolicy
+}
+
+func init() {
+	gob.Register(SetupFriendlyFire{})
+}
+func (s SetupFriendlyFire) Apply(_g interface{}) {
+	g := _g.(*Game)
+	if g.Setup == nil {
+		return
+	}
+	g.Setup.FriendlyFire = s.Policy.copy()
+
+	// Everyone should get a chance to see the new policy before the game starts.
+	for _, sideData := range g.Setup.Sides {
+		sideData.Ready = false
+	}
+}
+
 type SetupComplete struct {
 	Seed int64
 }
@@ -371,37 +636,38 @@ func (u SetupComplete) Apply(_g interface{}) {
 	if g.Setup == nil {
 		return
 	}
+	if waiting := g.Setup.Waiting(g.Setup.Host); len(waiting) > 0 {
+		base.Warn().Printf("Unable to start the game, still waiting for %v", waiting)
+		return
+	}
 
 	g.Engines = make(map[int64]*PlayerData)
-	for _, id := range g.Setup.EngineIds {
+	for _, id := range g.Setup.Members() {
+		sideData := g.Setup.Sides[id]
 		g.Engines[id] = &PlayerData{
 			PlayerGid: Gid(fmt.Sprintf("Engine:%d", id)),
-			Side:      g.Setup.Sides[id].Side,
+			Side:      sideData.Side,
+			Name:      g.Setup.PlayerName(id),
+			Champ:     sideData.Champ,
+			Ai:        sideData.Ai,
 		}
 	}
 
-	// Add a single Ai player to side 0
-	g.Engines[123123] = &PlayerData{
-		PlayerGid: Gid(fmt.Sprintf("Engine:%d", 123123)),
-		Side:      0,
-		Ai:        &AiPlayerData{},
-	}
-	g.Setup.Sides[123123] = &SetupSideData{
-		Champ: 0,
-		Side:  0,
+	numSides := g.Setup.numSides()
+	room, ok := g.Setup.Map.makeRoom(u.Seed, numSides)
+	if ok && g.Setup.Map.Room != nil && len(room.Starts) < numSides {
+		base.Warn().Printf("%s only has %d starts, not %d", g.Setup.Map.Name, len(room.Starts), numSides)
+		ok = false
 	}
-
-	var room Room
-	dx, dy := 1024, 1024
-	generated := generator.GenerateRoom(float64(dx), float64(dy), 100, 64, u.Seed)
-	data, err := json.Marshal(generated)
-	if err != nil {
-		base.Error().Fatalf("%v", err)
+	if ok && len(room.Moba.SideData) == 0 {
+		base.Warn().Printf("%s has no moba data", g.Setup.Map.Name)
+		ok = false
 	}
-	err = json.Unmarshal(data, &room)
-	// err = base.LoadJson(filepath.Join(base.GetDataDir(), "rooms/basic.json"), &room)
-	if err != nil {
-		base.Error().Fatalf("%v", err)
+	if !ok {
+		room, ok = defaultSetupMap.makeRoom(u.Seed, numSides)
+		if !ok {
+			base.Error().Fatalf("Unable to make a room for %d sides", numSides)
+		}
 	}
 	g.Levels = make(map[Gid]*Level)
 	g.Levels[GidInvadersStart] = &Level{}
@@ -410,20 +676,29 @@ func (u SetupComplete) Apply(_g interface{}) {
 	g.Rng.Seed(12313131)
 	g.Ents = make(map[Gid]Ent)
 	g.Friction = 0.97
-	// g.Standard = &GameModeStandard{}
+	// g.Standard = &GameModeStandard{FriendlyFire: g.Setup.FriendlyFire.copy()}
 	g.Moba = &GameModeMoba{
-		Sides: make(map[int]*GameModeMobaSideData),
+		Sides:        make(map[int]*GameModeMobaSideData),
+		FriendlyFire: g.Setup.FriendlyFire.copy(),
 	}
-	sides := make(map[int][]int64)
-	for id, data := range g.Engines {
-		sides[data.Side] = append(sides[data.Side], id)
+	// Engines are visited in a fixed order so that every client places the
+	// players in the same spots.
+	var engineIds []int64
+	for id := range g.Engines {
+		engineIds = append(engineIds, id)
 	}
-	for _, players := range sides {
-		var ids []int64
-		for _, id := range players {
-			ids = append(ids, id)
+	sort.Sort(int64Slice(engineIds))
+	sides := make(map[int][]int64)
+	var sideOrder []int
+	for _, id := range engineIds {
+		side := g.Engines[id].Side
+		if _, ok := sides[side]; !ok {
+			sideOrder = append(sideOrder, side)
 		}
-		side := g.Setup.Sides[ids[0]].Side
+		sides[side] = append(sides[side], id)
+	}
+	for _, side := range sideOrder {
+		ids := sides[side]
 		gids := g.AddPlayers(ids, side)
 		g.Moba.Sides[side] = &GameModeMobaSideData{}
 		for i := range ids {
@@ -431,7 +706,7 @@ func (u SetupComplete) Apply(_g interface{}) {
 			player.Champ = g.Setup.Sides[ids[i]].Champ
 		}
 	}
-	g.Moba.losCache = makeLosCache(dx, dy)
+	g.Moba.losCache = makeLosCache(room.Dx, room.Dy)
 
 	g.MakeControlPoints()
 	g.Init()
@@ -442,6 +717,12 @@ func init() {
 	gob.Register(SetupComplete{})
 }
 
+type int64Slice []int64
+
+func (s int64Slice) Len() int           { return len(s) }
+func (s int64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s int64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
 type PlayerData struct {
 	PlayerGid Gid
 
@@ -451,12 +732,18 @@ type PlayerData struct {
 
 	Side int
 
+	// Name and champ chosen during setup, kept for the results.
+	Name  string
+	Champ int
+
+	Deaths int
+
 	// If this is an ai controlled player then this will be non-nil.
 	Ai *AiPlayerData
 }
 
 type AiPlayerData struct {
-	N int
+	Difficulty AiDifficulty
 }
 
 type Game struct {
@@ -489,6 +776,10 @@ type Game struct {
 	// sent to clients to make debugging and tuning easier.
 	Champs []champ.Champion
 
+	// How much of the game state the host should send to each client, see
+	// StateForSide.
+	Sync SyncMode
+
 	temp struct {
 		// This include all room walls for each room, and all walls declared by any
 		// ents in that room.
@@ -510,6 +801,16 @@ type Game struct {
 		// All levels, in the order that they should be iterated in.
 		AllLevels      []*Level
 		AllLevelsDirty bool
+
+		// What each side can see, updated at the start of every think, and what
+		// each viewer's vision was last computed from.
+		Vision       map[int]*los.Grid
+		VisionStamps map[string]visionStamp
+
+		// Every ent that blocks los, and a count of how many times they've
+		// changed so that vision knows when to recompute everything.
+		Occluders        []occluder
+		OccludersChanged int
 	}
 }
 
@@ -518,13 +819,21 @@ func (g *Game) InitializeClientData() {
 }
 
 type GameModeStandard struct {
-	Architect architectData
-	Invaders  invadersData
+	Architect     architectData
+	ArchitectSide int // only this side is sent Architect with SyncVisible
+	Invaders      invadersData
+	FriendlyFire  FriendlyFirePolicy
 }
 type GameModeMoba struct {
 	// Map from side to the moba data for that side
-	Sides    map[int]*GameModeMobaSideData
-	losCache *losCache
+	Sides        map[int]*GameModeMobaSideData
+	FriendlyFire FriendlyFirePolicy
+	losCache     *losCache
+
+	// Mana events that have been announced and haven't ended, and the frame on
+	// which the next one will be announced.
+	ManaEvents    []ManaEvent
+	NextManaEvent int
 }
 type GameModeMobaSideData struct {
 	AppeaseGob struct{}
@@ -545,6 +854,7 @@ func MakeGame() *Game {
 	g.Setup = &Setup{}
 	g.Setup.Mode = "moba"
 	g.Setup.Sides = make(map[int64]*SetupSideData)
+	g.Setup.Map = defaultSetupMap
 
 	// NOTE: Obviously this isn't threadsafe, but I don't intend to be Init()ing
 	// multiple game objects at the same time.
@@ -564,26 +874,7 @@ func MakeGame() *Game {
 
 func (g *Game) Init() {
 	g.DoForLevels(func(gid Gid, level *Level) {
-		msOptions := ManaSourceOptions{
-			NumSeeds:    20,
-			NumNodeRows: level.Room.Dy / 32,
-			NumNodeCols: level.Room.Dx / 32,
-
-			BoardLeft:   0,
-			BoardTop:    0,
-			BoardRight:  float64(level.Room.Dx),
-			BoardBottom: float64(level.Room.Dy),
-
-			MaxDrainDistance: 120.0,
-			MaxDrainRate:     5.0,
-
-			RegenPerFrame:     0.002,
-			NodeMagnitude:     100,
-			MinNodeBrightness: 20,
-			MaxNodeBrightness: 150,
-
-			Rng: g.Rng,
-		}
+		msOptions := manaSourceOptions(&level.Room, g.Rng)
 		level.ManaSource.Init(&msOptions)
 	})
 	// Values less than this might be used for ability processes, ect...
@@ -649,36 +940,7 @@ func (g *Game) Think() {
 	}
 	defer base.StackCatcher()
 
-	// cache wall data
-	if g.temp.AllWalls == nil || g.temp.AllWallsDirty {
-		g.temp.AllWalls = make(map[Gid][]linear.Seg2)
-		g.temp.WallCache = make(map[Gid]*wallCache)
-		g.temp.VisibleWallCache = make(map[Gid]*wallCache)
-		for gid := range g.Levels {
-			var allWalls []linear.Seg2
-			base.DoOrdered(g.Levels[gid].Room.Walls, func(a, b string) bool { return a < b }, func(_ string, walls linear.Poly) {
-				for i := range walls {
-					allWalls = append(allWalls, walls.Seg(i))
-				}
-			})
-			// g.DoForEnts(func(entGid Gid, ent Ent) {
-			// 	if ent.Level() == gid {
-			// 		for _, walls := range ent.Walls() {
-			// 			for i := range walls {
-			// 				allWalls = append(allWalls, walls.Seg(i))
-			// 			}
-			// 		}
-			// 	}
-			// })
-			g.temp.AllWalls[gid] = allWalls
-			g.temp.WallCache[gid] = &wallCache{}
-			g.temp.WallCache[gid].SetWalls(g.Levels[gid].Room.Dx, g.Levels[gid].Room.Dy, allWalls, 100)
-			g.temp.VisibleWallCache[gid] = &wallCache{}
-			g.temp.VisibleWallCache[gid].SetWalls(g.Levels[gid].Room.Dx, g.Levels[gid].Room.Dy, allWalls, stats.LosPlayerHorizon)
-			base.Log().Printf("WallCache: %v", g.temp.WallCache)
-		}
-		g.Moba.losCache.SetWallCache(g.temp.VisibleWallCache[GidInvadersStart])
-	}
+	g.updateWallCaches()
 
 	// cache ent data
 	for _, ent := range g.temp.AllEnts {
@@ -694,6 +956,7 @@ func (g *Game) Think() {
 							base.Error().Printf("Unable to find engine %d for player %v", id, ent.Id())
 						} else {
 							engineData.CountdownFrames = 60 * 10
+							engineData.Deaths++
 						}
 					}
 				}
@@ -714,13 +977,8 @@ func (g *Game) Think() {
 		}
 	}
 
-	if g.temp.AllEnts == nil || g.temp.AllEntsDirty {
-		g.temp.AllEnts = g.temp.AllEnts[0:0]
-		g.DoForEnts(func(gid Gid, ent Ent) {
-			g.temp.AllEnts = append(g.temp.AllEnts, ent)
-		})
-		g.temp.AllEntsDirty = false
-	}
+	g.updateAllEnts()
+	g.updateVision()
 
 	for _, proc := range g.Processes {
 		proc.Think(g)
@@ -739,23 +997,7 @@ func (g *Game) Think() {
 
 	for i := 0; i < len(g.temp.AllEnts); i++ {
 		for j := i + 1; j < len(g.temp.AllEnts); j++ {
-			outerEnt := g.temp.AllEnts[i]
-			innerEnt := g.temp.AllEnts[j]
-			distSq := outerEnt.Pos().Sub(innerEnt.Pos()).Mag2()
-			colDist := outerEnt.Stats().Size() + innerEnt.Stats().Size()
-			if distSq > colDist*colDist {
-				continue
-			}
-			if distSq < 0.0001 {
-				continue
-			}
-			if distSq <= 0.25 {
-				distSq = 0.25
-			}
-			dist := math.Sqrt(distSq)
-			force := 50.0 * (colDist - dist)
-			outerEnt.ApplyForce(outerEnt.Pos().Sub(innerEnt.Pos()).Scale(force / dist))
-			innerEnt.ApplyForce(innerEnt.Pos().Sub(outerEnt.Pos()).Scale(force / dist))
+			g.collide(g.temp.AllEnts[i], g.temp.AllEnts[j])
 		}
 	}
 
@@ -770,8 +1012,61 @@ func (g *Game) Think() {
 	}
 }
 
+// updateWallCaches rebuilds the wall caches for every level if the walls have
+// changed.
+func (g *Game) updateWallCaches() {
+	if g.temp.AllWalls == nil || g.temp.AllWallsDirty {
+		g.temp.AllWalls = make(map[Gid][]linear.Seg2)
+		g.temp.WallCache = make(map[Gid]*wallCache)
+		g.temp.VisibleWallCache = make(map[Gid]*wallCache)
+		var losKey string
+		for gid := range g.Levels {
+			allWalls := roomWalls(&g.Levels[gid].Room)
+			// g.DoForEnts(func(entGid Gid, ent Ent) {
+			// 	if ent.Level() == gid {
+			// 		for _, walls := range ent.Walls() {
+			// 			for i := range walls {
+			// 				allWalls = append(allWalls, walls.Seg(i))
+			// 			}
+			// 		}
+			// 	}
+			// })
+			g.temp.AllWalls[gid] = allWalls
+			g.temp.WallCache[gid] = &wallCache{}
+			g.temp.WallCache[gid].SetWalls(g.Levels[gid].Room.Dx, g.Levels[gid].Room.Dy, allWalls, 100)
+			visibleWalls := roomVisibleWalls(&g.Levels[gid].Room)
+			g.temp.VisibleWallCache[gid] = &wallCache{}
+			g.temp.VisibleWallCache[gid].SetWalls(g.Levels[gid].Room.Dx, g.Levels[gid].Room.Dy, visibleWalls, stats.LosPlayerHorizon)
+			if gid == GidInvadersStart {
+				losKey = roomLosCacheKey(&g.Levels[gid].Room)
+			}
+			base.Log().Printf("WallCache: %v", g.temp.WallCache)
+		}
+		g.Moba.losCache.SetWallCache(g.temp.VisibleWallCache[GidInvadersStart], losKey)
+		g.temp.AllWallsDirty = false
+		// Everything that anything could see may have changed.
+		g.temp.Vision = nil
+	}
+}
+
+// updateAllEnts rebuilds the ordered list of ents if any have been added or
+// removed.
+func (g *Game) updateAllEnts() {
+	if g.temp.AllEnts == nil || g.temp.AllEntsDirty {
+		g.temp.AllEnts = g.temp.AllEnts[0:0]
+		g.DoForEnts(func(gid Gid, ent Ent) {
+			g.temp.AllEnts = append(g.temp.AllEnts, ent)
+		})
+		g.temp.AllEntsDirty = false
+	}
+}
+
 func (g *Game) ThinkMoba() {
+	g.thinkManaEvents()
 	g.Levels[GidInvadersStart].ManaSource.Think(g.Ents)
+	if g.GameThinks%(60*60) == 0 {
+		base.Log().Printf("LosCache: %v", g.LosCacheMetrics())
+	}
 }
 
 // Returns true iff a has los to b, regardless of distance, except that nothing
@@ -780,11 +1075,11 @@ func (g *Game) ExistsLos(a, b linear.Vec2) bool {
 	if g.Moba == nil {
 		panic("Not implemented except in mobas")
 	}
-	vps := g.Moba.losCache.Get(int(a.X), int(a.Y), stats.LosPlayerHorizon)
+	vps := g.losTo("", a, stats.LosPlayerHorizon)
 	x := int(b.X / LosGridSize)
 	y := int(b.Y / LosGridSize)
 	for _, vp := range vps {
-		if vp.X == x && vp.Y == y {
+		if int(vp.X) == x && int(vp.Y) == y {
 			return true
 		}
 	}
@@ -837,8 +1132,20 @@ func (a Accelerate) Apply(_g interface{}) {
 	player.Delta.Speed = a.Delta / 2
 }
 
+// Engine runs the game for a client, either a cgf engine that simulates
+// everything or a connection to a server that sends the state.
+type Engine interface {
+	ApplyEvent(event cgf.Event)
+	Id() int64
+	Ids() []int64
+	Pause()
+	Unpause()
+	GetState() interface{}
+	Kill()
+}
+
 type GameWindow struct {
-	Engine *cgf.Engine
+	Engine Engine
 	Local  *LocalData
 	Dims   gui.Dims
 	game   *Game
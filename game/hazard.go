@@ -0,0 +1,244 @@
+package game
+
+import (
+	"encoding/gob"
+	"fmt"
+	gl "github.com/chsc/gogl/gl21"
+	"github.com/runningwild/linear"
+	"github.com/runningwild/magnus/base"
+	"github.com/runningwild/magnus/los"
+	"github.com/runningwild/magnus/stats"
+	"sort"
+	"strconv"
+)
+
+// A Hazard is a region of a room that does something to every ent inside of
+// it, like lava, a slow field or a trap.
+type Hazard struct {
+	Region linear.Poly
+
+	// Damage dealt every frame to ents inside the region.
+	Damage stats.Damage
+
+	// Multiplies the acceleration of ents inside the region.  Zero is treated as
+	// one so that hazards don't have to specify it.
+	AccMultiplier float64
+
+	// If positive, this replaces the game's friction for ents inside the
+	// region.
+	Friction float64
+
+	// If true the region blocks line of sight just like a wall does, but ents
+	// can still move through it.
+	BlockVision bool
+
+	// If Placed, this hazard was placed by Side during the game and the
+	// friendly fire policy decides whether it hurts that side.  Otherwise it is
+	// part of the map and affects everyone.  Owner is the ent that placed it,
+	// if any.
+	Placed bool
+	Side   int
+	Owner  Gid
+
+	// Hidden hazards can't be seen by the other sides until they are revealed
+	// to them, which happens automatically when one of their ents sets one
+	// off.  RevealedTo has every side that it has been revealed to.
+	Hidden     bool
+	RevealedTo map[int]bool
+}
+
+// VisibleTo returns true if side can see this hazard.
+func (h *Hazard) VisibleTo(side int) bool {
+	return !h.Hidden || h.RevealedTo[side] || side == h.side()
+}
+
+func (h *Hazard) revealTo(side int) {
+	if h.RevealedTo == nil {
+		h.RevealedTo = make(map[int]bool)
+	}
+	h.RevealedTo[side] = true
+}
+
+func (h *Hazard) side() int {
+	if !h.Placed {
+		return -1
+	}
+	return h.Side
+}
+
+func (h *Hazard) contains(v linear.Vec2) bool {
+	return los.PolyContains(h.Region, v)
+}
+
+func (r *Room) AddHazard(hazard Hazard) string {
+	if r.Hazards == nil {
+		r.Hazards = make(map[string]*Hazard)
+	}
+	name := fmt.Sprintf("%d", r.NextId)
+	r.Hazards[name] = &hazard
+	r.NextId++
+	return name
+}
+
+// hazardCondition applies the stat changes from a hazard to an ent for one
+// frame.
+type hazardCondition struct {
+	AccMultiplier float64
+}
+
+func (hc hazardCondition) ModifyBase(b stats.Base) stats.Base {
+	b.Acc *= hc.AccMultiplier
+	return b
+}
+func (hc hazardCondition) ModifyDamage(damage stats.Damage) stats.Damage {
+	return damage
+}
+func (hc hazardCondition) CauseDamage() stats.Damage {
+	return stats.Damage{}
+}
+
+// applyHazards applies every hazard that b is standing in and returns the
+// friction that b should use this frame.  This is called from BaseEnt.Think
+// after conditions have been reset, otherwise the hazard conditions would be
+// cleared before they did anything.
+func (g *Game) applyHazards(b *BaseEnt) float64 {
+	friction := g.Friction
+	level, ok := g.Levels[b.CurrentLevel]
+	if !ok {
+		return friction
+	}
+	base.DoOrdered(level.Room.Hazards, func(x, y string) bool { return x < y }, func(_ string, hazard *Hazard) {
+		if !hazard.contains(b.Position) {
+			return
+		}
+		if hazard.AccMultiplier != 0 {
+			b.StatsInst.ApplyCondition(hazardCondition{hazard.AccMultiplier})
+		}
+		if hazard.Friction > 0 {
+			friction = hazard.Friction
+		}
+		if ent, ok := g.Ents[b.Id()]; ok && hazard.Damage.Amt > 0 {
+			g.DealDamage(hazard.side(), ent, DamageSourceEnvironment, hazard.Damage)
+		}
+		if hazard.Hidden && b.Side() != hazard.side() {
+			hazard.revealTo(b.Side())
+		}
+	})
+	return friction
+}
+
+// PlaceHazard adds a hazard to a level while the game is running.
+type PlaceHazard struct {
+	Level  Gid
+	Hazard Hazard
+}
+
+func init() {
+	gob.Register(PlaceHazard{})
+}
+
+func (p PlaceHazard) Apply(_g interface{}) {
+	g := _g.(*Game)
+	level, ok := g.Levels[p.Level]
+	if !ok {
+		return
+	}
+	level.Room.AddHazard(p.Hazard)
+	if p.Hazard.BlockVision {
+		g.temp.AllWallsDirty = true
+	}
+}
+
+// PlaceOwnedHazard adds hazard, which was placed by hazard.Owner, to level.
+// Each owner can have at most maxOwned hazards at a time, if it already has
+// that many its oldest ones are removed.
+func (g *Game) PlaceOwnedHazard(level Gid, hazard Hazard, maxOwned int) {
+	l, ok := g.Levels[level]
+	if !ok {
+		return
+	}
+	var owned []int
+	for name, other := range l.Room.Hazards {
+		id, err := strconv.Atoi(name)
+		if err == nil && other.Owner == hazard.Owner {
+			owned = append(owned, id)
+		}
+	}
+	sort.Ints(owned)
+	for ; maxOwned > 0 && len(owned) >= maxOwned; owned = owned[1:] {
+		name := fmt.Sprintf("%d", owned[0])
+		if l.Room.Hazards[name].BlockVision {
+			g.temp.AllWallsDirty = true
+		}
+		delete(l.Room.Hazards, name)
+	}
+	PlaceHazard{level, hazard}.Apply(g)
+}
+
+// RevealHazards reveals any hidden hazards within radius of pos that side has
+// line of sight to.
+func (g *Game) RevealHazards(side int, level Gid, pos linear.Vec2, radius float64) {
+	l, ok := g.Levels[level]
+	if !ok {
+		return
+	}
+	for _, hazard := range l.Room.Hazards {
+		if !g.isPolyVisibleTo(hazard.Region, side) {
+			continue
+		}
+		if hazard.contains(pos) {
+			hazard.revealTo(side)
+			continue
+		}
+		for i := range hazard.Region {
+			if distSquaredToSeg(pos, hazard.Region.Seg(i)) <= radius*radius {
+				hazard.revealTo(side)
+				break
+			}
+		}
+	}
+}
+
+// visionBlockingSegs returns the edges of every hazard in room that blocks
+// vision, in a fixed order.
+func visionBlockingSegs(room *Room) []linear.Seg2 {
+	var segs []linear.Seg2
+	base.DoOrdered(room.Hazards, func(a, b string) bool { return a < b }, func(_ string, hazard *Hazard) {
+		if !hazard.BlockVision {
+			return
+		}
+		for i := range hazard.Region {
+			segs = append(segs, hazard.Region.Seg(i))
+		}
+	})
+	return segs
+}
+
+func (g *Game) renderHazards(room *Room, side int) {
+	gl.Disable(gl.TEXTURE_2D)
+	base.DoOrdered(room.Hazards, func(a, b string) bool { return a < b }, func(_ string, hazard *Hazard) {
+		if !hazard.VisibleTo(side) {
+			return
+		}
+		switch {
+		case hazard.Damage.Amt > 0:
+			gl.Color4ub(255, 80, 0, 120)
+		case hazard.AccMultiplier != 0 && hazard.AccMultiplier < 1:
+			gl.Color4ub(80, 80, 255, 120)
+		case hazard.BlockVision:
+			gl.Color4ub(100, 100, 100, 200)
+		default:
+			gl.Color4ub(200, 200, 0, 120)
+		}
+		// Hidden hazards are drawn fainter for the side that placed them, until
+		// some other side finds them.
+		if hazard.Hidden && len(hazard.RevealedTo) == 0 {
+			gl.Color4ub(200, 200, 200, 60)
+		}
+		gl.Begin(gl.TRIANGLE_FAN)
+		for _, v := range hazard.Region {
+			gl.Vertex2d(gl.Double(v.X), gl.Double(v.Y))
+		}
+		gl.End()
+	})
+}
@@ -3,6 +3,8 @@ package game
 import (
 	"fmt"
 	"github.com/runningwild/linear"
+	"github.com/runningwild/magnus/los"
+	"strconv"
 )
 
 type Portal struct {
@@ -15,9 +17,13 @@ type Room struct {
 	Starts  []linear.Vec2
 	End     linear.Vec2
 	Portals map[string]Portal
+	Hazards map[string]*Hazard
 	Dx, Dy  int
 	NextId  int
 
+	// Where mana comes from in this room, anything left out gets a default.
+	Mana RoomMana
+
 	// Only filled for moba rooms
 	Moba struct {
 		SideData []mobaRoomSideData
@@ -25,12 +31,46 @@ type Room struct {
 }
 
 type mobaRoomSideData struct {
-	Base   linear.Vec2   // Position of the base for this side
-	Towers []linear.Vec2 // Positions of the towers for this side
-	// Will also need waypoints for units, production and whatnot.
+	Base   linear.Vec2     // Position of the base for this side
+	Towers []linear.Vec2   // Positions of the towers for this side
+	Lanes  [][]linear.Vec2 // Waypoints along each lane, starting at the base
+	// Will also need production and whatnot.
 }
 
 func (r *Room) AddWall(wall linear.Poly) {
 	r.Walls[fmt.Sprintf("%d", r.NextId)] = wall
 	r.NextId++
 }
+
+// blocked returns true if v is inside of a wall or outside of the room.
+func (r *Room) blocked(v linear.Vec2) bool {
+	if v.X < 0 || v.Y < 0 || v.X > float64(r.Dx) || v.Y > float64(r.Dy) {
+		return true
+	}
+	for _, wall := range r.Walls {
+		if los.PolyContains(wall, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// fixNextId moves NextId past every id in the room.  Older generated and
+// imported rooms stored the last id they used rather than the next free one,
+// so adding to them would overwrite something.
+func (r *Room) fixNextId() {
+	bump := func(name string) {
+		if id, err := strconv.Atoi(name); err == nil && id >= r.NextId {
+			r.NextId = id + 1
+		}
+	}
+	for name := range r.Walls {
+		bump(name)
+	}
+	for name := range r.Portals {
+		bump(name)
+	}
+	for name := range r.Hazards {
+		bump(name)
+	}
+}
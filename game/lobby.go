@@ -0,0 +1,355 @@
+package game
+
+import (
+	"fmt"
+	"github.com/runningwild/glop/gin"
+	"github.com/runningwild/glop/gui"
+	"github.com/runningwild/magnus/base"
+	g2 "github.com/runningwild/magnus/gui"
+	"os"
+	"strings"
+	"time"
+)
+
+// Most sides that players can choose between in the lobby.
+const setupMaxSides = 4
+
+type localSetupData struct {
+	index int
+
+	// Maps that the host can choose from, these are only found again when the
+	// number of sides changes.
+	maps      []SetupMap
+	mapsSides int
+
+	// Set once this engine has told everyone its name.
+	named bool
+}
+
+type setupRowKind int
+
+const (
+	setupRowMember setupRowKind = iota
+	setupRowMap
+	setupRowSize
+	setupRowFriendlyFire
+	setupRowAddAi
+	setupRowReady
+	setupRowStart
+)
+
+// A row in the lobby, id is only set for members.
+type setupRow struct {
+	kind setupRowKind
+	id   int64
+}
+
+// playerName is the name this engine shows in the lobby.
+func playerName() string {
+	if name := os.Getenv("MAGNUS_NAME"); name != "" {
+		return name
+	}
+	return os.Getenv("USER")
+}
+
+func (l *LocalData) isHost() bool {
+	return len(l.engine.Ids()) > 0
+}
+
+// setupRows returns every row in the lobby.  Everyone sees every member and
+// the map, but only the host can add ais and start the game.
+func (l *LocalData) setupRows(g *Game) []setupRow {
+	var rows []setupRow
+	for _, id := range g.Setup.Members() {
+		rows = append(rows, setupRow{setupRowMember, id})
+	}
+	rows = append(rows, setupRow{kind: setupRowMap}, setupRow{kind: setupRowSize}, setupRow{kind: setupRowFriendlyFire})
+	if l.isHost() {
+		rows = append(rows, setupRow{kind: setupRowAddAi}, setupRow{kind: setupRowStart})
+	} else {
+		rows = append(rows, setupRow{kind: setupRowReady})
+	}
+	return rows
+}
+
+// canSelect returns true if this engine can change anything in row.  Players
+// can change themselves, and the host can also move anyone to another side
+// and change ais.
+func (l *LocalData) canSelect(g *Game, row setupRow) bool {
+	switch row.kind {
+	case setupRowMember:
+		return row.id == l.engine.Id() || l.isHost()
+	case setupRowMap, setupRowSize, setupRowFriendlyFire:
+		return l.isHost()
+	}
+	return true
+}
+
+// updateMaps finds the maps that can be played with the current sides, and
+// makes sure that the host has chosen one of them.
+func (l *LocalData) updateMaps(g *Game) {
+	sides := g.Setup.numSides()
+	if l.setup.maps != nil && l.setup.mapsSides == sides {
+		return
+	}
+	l.setup.maps = MapChoices(g.Setup.Mode, sides)
+	l.setup.mapsSides = sides
+	if len(l.setup.maps) > 0 && l.mapIndex(g) == -1 {
+		l.selectMap(g, l.setup.maps[0], g.Setup.Map.Dx)
+	}
+}
+
+// mapIndex returns the index in the choices of the map that the host chose,
+// or -1 if it isn't one of them.
+func (l *LocalData) mapIndex(g *Game) int {
+	for i, m := range l.setup.maps {
+		if m.Name == g.Setup.Map.Name && m.Preset == g.Setup.Map.Preset {
+			return i
+		}
+	}
+	return -1
+}
+
+func (l *LocalData) selectMap(g *Game, m SetupMap, size int) {
+	if m.Preset != "" {
+		if size == 0 {
+			size = defaultSetupMap.Dx
+		}
+		m.Dx, m.Dy = size, size
+	}
+	l.engine.ApplyEvent(SetupSelectMap{m})
+}
+
+// cycleMap changes the host's map choice by delta.
+func (l *LocalData) cycleMap(g *Game, delta int) {
+	if len(l.setup.maps) == 0 {
+		return
+	}
+	index := (l.mapIndex(g) + delta + len(l.setup.maps)) % len(l.setup.maps)
+	l.selectMap(g, l.setup.maps[index], g.Setup.Map.Dx)
+}
+
+// cycleSize changes the size of a generated map by delta.
+func (l *LocalData) cycleSize(g *Game, delta int) {
+	if g.Setup.Map.Preset == "" {
+		return
+	}
+	index := 0
+	for i, size := range MapSizes {
+		if size == g.Setup.Map.Dx {
+			index = i
+		}
+	}
+	index = (index + delta + len(MapSizes)) % len(MapSizes)
+	l.selectMap(g, g.Setup.Map, MapSizes[index])
+}
+
+// cycleFriendlyFire changes the host's friendly fire policy by delta.
+func (l *LocalData) cycleFriendlyFire(g *Game, delta int) {
+	index := 0
+	for i, policy := range FriendlyFireChoices {
+		if policy.String() == g.Setup.FriendlyFire.String() {
+			index = i
+		}
+	}
+	index = (index + delta + len(FriendlyFireChoices)) % len(FriendlyFireChoices)
+	l.engine.ApplyEvent(SetupFriendlyFire{FriendlyFireChoices[index]})
+}
+
+// smallestSide returns the side with the fewest members, which is where new
+// ais go.
+func (g *Game) smallestSide() int {
+	counts := make([]int, g.Setup.numSides())
+	for _, id := range g.Setup.Members() {
+		if side := g.Setup.Sides[id].Side; side < len(counts) {
+			counts[side]++
+		}
+	}
+	best := 0
+	for side := range counts {
+		if counts[side] < counts[best] {
+			best = side
+		}
+	}
+	return best
+}
+
+func (l *LocalData) Setup(g *Game) {
+	id := l.engine.Id()
+	if l.isHost() {
+		// This is the host engine - so update the list of ids in case it's changed
+		l.engine.ApplyEvent(SetupSetEngineIds{l.engine.Ids(), id})
+		l.updateMaps(g)
+	}
+	if !l.setup.named && g.Setup.Sides[id] != nil {
+		l.engine.ApplyEvent(SetupSetName{id, playerName()})
+		l.setup.named = true
+	}
+
+	rows := l.setupRows(g)
+	move := 0
+	if gin.In().GetKey(gin.AnyUp).FramePressCount() > 0 {
+		move--
+	}
+	if gin.In().GetKey(gin.AnyDown).FramePressCount() > 0 {
+		move++
+	}
+	// Skip over anything that can't be changed, and make sure that the cursor
+	// is on something that can be if the rows changed under it.
+	for index := l.setup.index + move; move != 0 && index >= 0 && index < len(rows); index += move {
+		if l.canSelect(g, rows[index]) {
+			l.setup.index = index
+			break
+		}
+	}
+	if l.setup.index >= len(rows) || !l.canSelect(g, rows[l.setup.index]) {
+		for index := len(rows) - 1; index >= 0; index-- {
+			if l.canSelect(g, rows[index]) {
+				l.setup.index = index
+				break
+			}
+		}
+	}
+	row := rows[l.setup.index]
+	data := g.Setup.Sides[row.id]
+
+	delta := 0
+	if gin.In().GetKey(gin.AnyLeft).FramePressCount() > 0 {
+		delta--
+	}
+	if gin.In().GetKey(gin.AnyRight).FramePressCount() > 0 {
+		delta++
+	}
+	pressed := func(key gin.KeyId) bool {
+		return gin.In().GetKey(key).FramePressCount() > 0
+	}
+	switch row.kind {
+	case setupRowMember:
+		ai := data != nil && data.Ai != nil && l.isHost()
+		if delta != 0 && (row.id == id || ai) {
+			l.engine.ApplyEvent(SetupChampSelect{row.id, delta})
+		}
+		if pressed(gin.AnyReturn) && data != nil {
+			l.engine.ApplyEvent(SetupChangeSides{row.id, (data.Side + 1) % setupMaxSides})
+		}
+		if ai && pressed(gin.AnyKeyD) {
+			l.engine.ApplyEvent(SetupAiDifficulty{row.id, (data.Ai.Difficulty + 1) % numAiDifficulties})
+		}
+		if ai && (pressed(gin.AnyBackspace) || pressed(gin.AnyDelete)) {
+			l.engine.ApplyEvent(SetupRemoveAi{row.id})
+		}
+
+	case setupRowMap:
+		if delta != 0 {
+			l.cycleMap(g, delta)
+		}
+
+	case setupRowSize:
+		if delta != 0 {
+			l.cycleSize(g, delta)
+		}
+
+	case setupRowFriendlyFire:
+		if delta != 0 {
+			l.cycleFriendlyFire(g, delta)
+		}
+
+	case setupRowAddAi:
+		if pressed(gin.AnyReturn) {
+			l.engine.ApplyEvent(SetupAddAi{g.smallestSide(), 0, AiNormal})
+		}
+
+	case setupRowReady:
+		if pressed(gin.AnyReturn) && g.Setup.Sides[id] != nil {
+			l.engine.ApplyEvent(SetupSetReady{id, !g.Setup.Sides[id].Ready})
+		}
+
+	case setupRowStart:
+		if pressed(gin.AnyReturn) && len(g.Setup.Waiting(id)) == 0 {
+			l.engine.ApplyEvent(SetupComplete{time.Now().UnixNano()})
+		}
+	}
+}
+
+var lobbySideColors = [][3]float64{
+	{0.5, 0.7, 1},
+	{1, 0.5, 0.5},
+	{0.5, 1, 0.5},
+	{1, 0.9, 0.4},
+}
+
+func (g *Game) RenderLocalSetup(region g2.Region, local *LocalData) {
+	dict := base.GetDictionary("luxisr")
+	size := 40.0
+	y := 60.0
+	gui.SetFontColor(1, 1, 1, 1)
+	dict.RenderString("Lobby", size, y, 0, 1.5*size, gui.Left)
+	y += 1.5 * size
+	for i, row := range local.setupRows(g) {
+		y += size
+		gui.SetFontColor(0.7, 0.7, 0.7, 1)
+		var text string
+		switch row.kind {
+		case setupRowMember:
+			data := g.Setup.Sides[row.id]
+			color := lobbySideColors[data.Side%len(lobbySideColors)]
+			gui.SetFontColor(color[0], color[1], color[2], 1)
+			champ := "No champ"
+			if data.Champ < len(g.Champs) {
+				champ = g.Champs[data.Champ].Name
+			}
+			status := "Not ready"
+			if data.Ai != nil || data.Ready {
+				status = "Ready"
+			}
+			text = fmt.Sprintf("Side %d   %s   %s   %s", data.Side, g.Setup.PlayerName(row.id), champ, status)
+			if row.id == local.engine.Id() {
+				text += "   (you)"
+			}
+
+		case setupRowMap:
+			text = fmt.Sprintf("Map: %s", g.Setup.Map.Name)
+
+		case setupRowSize:
+			if g.Setup.Map.Preset != "" {
+				text = fmt.Sprintf("Size: %dx%d", g.Setup.Map.Dx, g.Setup.Map.Dy)
+			} else if g.Setup.Map.Room != nil {
+				text = fmt.Sprintf("Size: %dx%d", g.Setup.Map.Room.Dx, g.Setup.Map.Room.Dy)
+			}
+
+		case setupRowFriendlyFire:
+			text = fmt.Sprintf("Friendly fire: %s", g.Setup.FriendlyFire)
+
+		case setupRowAddAi:
+			text = "Add ai"
+
+		case setupRowReady:
+			text = "Ready"
+			if data := g.Setup.Sides[local.engine.Id()]; data != nil && data.Ready {
+				text = "Not ready"
+			}
+
+		case setupRowStart:
+			var names []string
+			for _, id := range g.Setup.Waiting(local.engine.Id()) {
+				names = append(names, g.Setup.PlayerName(id))
+			}
+			text = "Start!"
+			if len(names) > 0 {
+				text = fmt.Sprintf("Waiting for %s", strings.Join(names, ", "))
+			}
+		}
+		dict.RenderString(text, size, y, 0, size, gui.Left)
+		if i == local.setup.index {
+			gui.SetFontColor(1, 1, 1, 1)
+			dict.RenderString(">", size-10, y, 0, size, gui.Right)
+		}
+	}
+	y += 2 * size
+	gui.SetFontColor(0.5, 0.5, 0.5, 1)
+	help := "Up/Down select  Left/Right champ  Return side"
+	if local.isHost() {
+		help += "  D ai difficulty  Delete remove ai"
+	}
+	dict.RenderString(help, size, y, 0, size/2, gui.Left)
+}
@@ -3,10 +3,8 @@ package game
 import (
 	"fmt"
 	gl "github.com/chsc/gogl/gl21"
-	"github.com/runningwild/cgf"
 	"github.com/runningwild/glop/gin"
 	"github.com/runningwild/glop/gui"
-	"time"
 	// "github.com/runningwild/glop/render"
 	"github.com/runningwild/glop/system"
 	"github.com/runningwild/linear"
@@ -52,10 +50,6 @@ type cameraInfo struct {
 	cursorHidden bool
 }
 
-type localEditorData struct {
-	camera cameraInfo
-}
-
 type mobaSideData struct {
 	side int
 }
@@ -80,6 +74,9 @@ type localMobaData struct {
 
 	// Debug stuff
 	aiPlayers []*mobaAiPlayerData
+
+	// Only the host sends its los cache, and only once.
+	sentLosCache bool
 }
 
 func (lmd *localMobaData) setCurrentPlayerByGid(gid Gid) {
@@ -108,13 +105,9 @@ type localArchitectData struct {
 	place linear.Poly
 }
 
-type localSetupData struct {
-	index int
-}
-
 type LocalData struct {
 	// The engine running this game, so that the game can apply events to itself.
-	engine *cgf.Engine
+	engine Engine
 
 	mode LocalMode
 
@@ -132,6 +125,9 @@ type LocalData struct {
 }
 
 func (l *LocalData) DebugCyclePlayers() {
+	if l.mode == LocalModeEditor {
+		return
+	}
 	if l.mode != LocalModeMoba {
 		panic("Can't DebugCyclePlayers except in LocalModeMoba")
 	}
@@ -148,6 +144,17 @@ func (l *LocalData) DebugChangeMode(mode LocalMode) {
 	l.mode = mode
 }
 
+// DebugToggleEditor switches to the level editor, or back to whatever mode was
+// active before it.
+func (l *LocalData) DebugToggleEditor() {
+	if l.mode == LocalModeEditor {
+		l.mode = l.editor.prevMode
+		return
+	}
+	l.editor.prevMode = l.mode
+	l.mode = LocalModeEditor
+}
+
 type gameResponderWrapper struct {
 	l *LocalData
 }
@@ -168,21 +175,21 @@ const (
 	LocalModeEditor
 )
 
-func NewLocalDataMoba(engine *cgf.Engine, index gin.DeviceIndex, sys system.System) *LocalData {
+func NewLocalDataMoba(engine Engine, index gin.DeviceIndex, sys system.System) *LocalData {
 	local := newLocalDataHelper(engine, sys, LocalModeMoba)
 	local.moba.deviceIndex = index
 	return local
 }
 
-func NewLocalDataInvaders(engine *cgf.Engine, sys system.System) *LocalData {
+func NewLocalDataInvaders(engine Engine, sys system.System) *LocalData {
 	return newLocalDataHelper(engine, sys, LocalModeInvaders)
 }
 
-func NewLocalDataArchitect(engine *cgf.Engine, sys system.System) *LocalData {
+func NewLocalDataArchitect(engine Engine, sys system.System) *LocalData {
 	return newLocalDataHelper(engine, sys, LocalModeArchitect)
 }
 
-func newLocalDataHelper(engine *cgf.Engine, sys system.System, mode LocalMode) *LocalData {
+func newLocalDataHelper(engine Engine, sys system.System, mode LocalMode) *LocalData {
 	var local LocalData
 	if local.engine != nil {
 		base.Error().Fatalf("Engine has already been set.")
@@ -206,50 +213,42 @@ func newLocalDataHelper(engine *cgf.Engine, sys system.System, mode LocalMode) *
 	return &local
 }
 
+// renderLosMask covers everything that the current player's side can't see.
+// This is the union of what all of the players and wards on that side can see,
+// so it is drawn from the los grid rather than from the walls around the
+// player.
 func (g *Game) renderLosMask(local *LocalData) {
-	ent := g.Ents[local.moba.currentPlayer.gid]
-	if ent == nil {
+	if g.Ents[local.moba.currentPlayer.gid] == nil {
+		return
+	}
+	sv, ok := g.sideVisions()[local.moba.currentPlayer.side]
+	if !ok {
 		return
 	}
-	walls := g.temp.VisibleWallCache[GidInvadersStart].GetWalls(int(ent.Pos().X), int(ent.Pos().Y))
 	gl.Disable(gl.TEXTURE_2D)
 	gl.Color4ub(0, 0, 0, 255)
-	gl.Begin(gl.TRIANGLES)
-	for _, wall := range walls {
-		if wall.Right(ent.Pos()) {
-			continue
+	gl.Begin(gl.QUADS)
+	for y := 0; y < sv.Dy; y++ {
+		// Draw each run of hidden cells in a row as a single quad.
+		for x := 0; x < sv.Dx; x++ {
+			if sv.CellVisible(x, y) {
+				continue
+			}
+			start := x
+			for x < sv.Dx && !sv.CellVisible(x, y) {
+				x++
+			}
+			x0 := gl.Int(start * LosGridSize)
+			x1 := gl.Int(x * LosGridSize)
+			y0 := gl.Int(y * LosGridSize)
+			y1 := gl.Int((y + 1) * LosGridSize)
+			gl.Vertex2i(x0, y0)
+			gl.Vertex2i(x1, y0)
+			gl.Vertex2i(x1, y1)
+			gl.Vertex2i(x0, y1)
 		}
-		a := wall.P
-		b := ent.Pos().Sub(wall.P).Norm().Scale(-10000.0).Add(wall.P)
-		mid := wall.P.Add(wall.Q).Scale(0.5)
-		c := ent.Pos().Sub(mid).Norm().Scale(-10000.0).Add(mid)
-		d := ent.Pos().Sub(wall.Q).Norm().Scale(-10000.0).Add(wall.Q)
-		e := wall.Q
-		gl.Vertex2d(gl.Double(a.X), gl.Double(a.Y))
-		gl.Vertex2d(gl.Double(b.X), gl.Double(b.Y))
-		gl.Vertex2d(gl.Double(c.X), gl.Double(c.Y))
-
-		gl.Vertex2d(gl.Double(a.X), gl.Double(a.Y))
-		gl.Vertex2d(gl.Double(c.X), gl.Double(c.Y))
-		gl.Vertex2d(gl.Double(d.X), gl.Double(d.Y))
-
-		gl.Vertex2d(gl.Double(a.X), gl.Double(a.Y))
-		gl.Vertex2d(gl.Double(d.X), gl.Double(d.Y))
-		gl.Vertex2d(gl.Double(e.X), gl.Double(e.Y))
 	}
 	gl.End()
-	base.EnableShader("horizon")
-	base.SetUniformV2("horizon", "center", ent.Pos())
-	base.SetUniformF("horizon", "horizon", LosMaxDist)
-	gl.Begin(gl.QUADS)
-	dx := gl.Int(g.Levels[GidInvadersStart].Room.Dx)
-	dy := gl.Int(g.Levels[GidInvadersStart].Room.Dy)
-	gl.Vertex2i(0, 0)
-	gl.Vertex2i(dx, 0)
-	gl.Vertex2i(dx, dy)
-	gl.Vertex2i(0, dy)
-	gl.End()
-	base.EnableShader("")
 }
 
 func (g *Game) renderLocalInvaders(region g2.Region, local *LocalData) {
@@ -324,6 +323,8 @@ func (g *Game) renderLocalHelper(region g2.Region, local *LocalData, camera *cam
 	level := g.Levels[GidInvadersStart]
 	zoom := camera.current.dims.X / float64(region.Dims.Dx)
 	level.ManaSource.Draw(local, zoom, float64(level.Room.Dx), float64(level.Room.Dy))
+	g.renderHazards(&level.Room, side)
+	g.renderManaEvents()
 
 	gl.Color4d(1, 1, 1, 1)
 	var expandedPoly linear.Poly
@@ -352,6 +353,9 @@ func (g *Game) renderLocalHelper(region g2.Region, local *LocalData, camera *cam
 
 	gl.Color4d(1, 1, 1, 1)
 	for _, ent := range g.temp.AllEnts {
+		if !g.IsVisibleTo(ent, side) {
+			continue
+		}
 		ent.Draw(g, side)
 	}
 	gl.Disable(gl.TEXTURE_2D)
@@ -373,13 +377,13 @@ func (g *Game) renderLocalHelper(region g2.Region, local *LocalData, camera *cam
 	g.renderLosMask(local)
 }
 
-func (camera *cameraInfo) doArchitectFocusRegion(g *Game, sys system.System) {
+func (camera *cameraInfo) doArchitectFocusRegion(room *Room, sys system.System) {
 	if camera.limit.mid.X == 0 && camera.limit.mid.Y == 0 {
 		// On the very first frame the limit midpoint will be (0,0), which should
 		// never happen after the game begins.  We use this as an opportunity to
 		// init the data now that we know the region we're working with.
-		rdx := float64(g.Levels[GidInvadersStart].Room.Dx)
-		rdy := float64(g.Levels[GidInvadersStart].Room.Dy)
+		rdx := float64(room.Dx)
+		rdy := float64(room.Dy)
 		if camera.regionDims.X/camera.regionDims.Y > rdx/rdy {
 			camera.limit.dims.Y = rdy
 			camera.limit.dims.X = rdy * camera.regionDims.X / camera.regionDims.Y
@@ -422,8 +426,24 @@ func (camera *cameraInfo) doArchitectFocusRegion(g *Game, sys system.System) {
 	}
 }
 
+// mouseToWorld returns the position in the room that the cursor is over.
+func (camera *cameraInfo) mouseToWorld(sys system.System) linear.Vec2 {
+	var mouse linear.Vec2
+	mx, my := sys.GetCursorPos()
+	mouse.X = float64(mx)
+	mouse.Y = float64(my)
+	mouse = mouse.Sub(camera.regionPos)
+	mouse.X /= camera.regionDims.X
+	mouse.Y /= camera.regionDims.Y
+	mouse.X *= camera.current.dims.X
+	mouse.Y *= camera.current.dims.Y
+	mouse = mouse.Sub(camera.current.dims.Scale(0.5))
+	mouse = mouse.Add(camera.current.mid)
+	return mouse
+}
+
 func (g *Game) renderLocalArchitect(region g2.Region, local *LocalData) {
-	local.architect.camera.doArchitectFocusRegion(g, local.sys)
+	local.architect.camera.doArchitectFocusRegion(&g.Levels[GidInvadersStart].Room, local.sys)
 	gl.MatrixMode(gl.PROJECTION)
 	gl.PushMatrix()
 	gl.LoadIdentity()
@@ -457,6 +477,7 @@ func (g *Game) renderLocalArchitect(region g2.Region, local *LocalData) {
 	zoom := local.architect.camera.current.dims.X / float64(region.Dims.Dx)
 	level := g.Levels[GidInvadersStart]
 	level.ManaSource.Draw(local, zoom, float64(level.Room.Dx), float64(level.Room.Dy))
+	g.renderHazards(&level.Room, -1)
 
 	gl.Begin(gl.LINES)
 	gl.Color4d(1, 1, 1, 1)
@@ -486,40 +507,6 @@ func (g *Game) renderLocalArchitect(region g2.Region, local *LocalData) {
 	}
 }
 
-func (g *Game) RenderLocalSetup(region g2.Region, local *LocalData) {
-	dict := base.GetDictionary("luxisr")
-	size := 60.0
-	y := 100.0
-	dict.RenderString("Engines:", size, y, 0, size, gui.Left)
-	for i, id := range g.Setup.EngineIds {
-		y += size
-		if id == local.engine.Id() {
-			gui.SetFontColor(0.7, 0.7, 1, 1)
-		} else {
-			gui.SetFontColor(0.7, 0.7, 0.7, 1)
-		}
-		dataStr := fmt.Sprintf("Engine %d, Side %d, %s", id, g.Setup.Sides[id].Side, g.Champs[g.Setup.Sides[id].Champ].Name)
-		dict.RenderString(dataStr, size, y, 0, size, gui.Left)
-		if local.engine.Id() == 1 && i == local.setup.index {
-			dict.RenderString(">", 50, y, 0, size, gui.Right)
-		}
-	}
-	y += size
-	gui.SetFontColor(0.7, 0.7, 0.7, 1)
-	if local.engine.Id() == 1 {
-		dict.RenderString("Start!", size, y, 0, size, gui.Left)
-		if local.setup.index == len(g.Setup.EngineIds) {
-			dict.RenderString(">", 50, y, 0, size, gui.Right)
-		}
-	}
-	ids := local.engine.Ids()
-	if len(ids) > 0 {
-		// This is the host engine - so update the list of ids in case it's changed
-		local.engine.ApplyEvent(SetupSetEngineIds{ids})
-	}
-
-}
-
 // Draws everything that is relevant to the players on a computer, but not the
 // players across the network.  Any ui used to determine how to place an object
 // or use an ability, for example.
@@ -530,6 +517,7 @@ func (g *Game) RenderLocal(region g2.Region, local *LocalData) {
 	}
 	switch {
 	case local.mode == LocalModeMoba:
+	case local.mode == LocalModeEditor:
 	default:
 		panic("Not implemented!!!")
 	}
@@ -553,6 +541,8 @@ func (g *Game) RenderLocal(region g2.Region, local *LocalData) {
 		g.renderLocalInvaders(region, local)
 	case LocalModeMoba:
 		g.renderLocalMoba(region, local)
+	case LocalModeEditor:
+		g.renderLocalEditor(region, local)
 	}
 }
 
@@ -591,16 +581,7 @@ func (l *LocalData) thinkAbility(g *Game, abs *personalAbilities, gid Gid) {
 	}
 	var mouse linear.Vec2
 	if l.mode == LocalModeArchitect {
-		mx, my := l.sys.GetCursorPos()
-		mouse.X = float64(mx)
-		mouse.Y = float64(my)
-		mouse = mouse.Sub(l.architect.camera.regionPos)
-		mouse.X /= l.architect.camera.regionDims.X
-		mouse.Y /= l.architect.camera.regionDims.Y
-		mouse.X *= l.architect.camera.current.dims.X
-		mouse.Y *= l.architect.camera.current.dims.Y
-		mouse = mouse.Sub(l.architect.camera.current.dims.Scale(0.5))
-		mouse = mouse.Add(l.architect.camera.current.mid)
+		mouse = l.architect.camera.mouseToWorld(l.sys)
 	}
 	events, die := abs.activeAbility.Think(gid, g, mouse)
 	for _, event := range events {
@@ -691,10 +672,16 @@ func (l *LocalData) localThinkInvaders(g *Game) {
 
 	// Ais
 	if l.engine.Ids() != nil {
-		for _, engineData := range g.Engines {
-			if engineData.Ai != nil {
-				l.engine.ApplyEvent(Accelerate{engineData.PlayerGid, 300})
-			}
+		for _, event := range g.AiEvents() {
+			l.engine.ApplyEvent(event)
+		}
+	}
+
+	// Share the host's saved los cache, if it has one.
+	if l.engine.Ids() != nil && !l.moba.sentLosCache && g.Moba.losCache.Key() != "" {
+		l.moba.sentLosCache = true
+		if event, ok := g.LosCacheEvent(); ok {
+			l.engine.ApplyEvent(event)
 		}
 	}
 }
@@ -772,46 +759,6 @@ func (camera *cameraInfo) doInvadersFocusRegion(g *Game, side int) {
 	}
 }
 
-func (l *LocalData) Setup(g *Game) {
-	if len(l.engine.Ids()) > 0 {
-		if gin.In().GetKey(gin.AnyUp).FramePressCount() > 0 {
-			l.setup.index--
-			if l.setup.index < 0 {
-				l.setup.index = 0
-			}
-		}
-		if gin.In().GetKey(gin.AnyDown).FramePressCount() > 0 {
-			l.setup.index++
-			if l.setup.index > len(g.Setup.EngineIds) {
-				l.setup.index = len(g.Setup.EngineIds)
-			}
-		}
-	} else {
-		for i, v := range g.Setup.EngineIds {
-			if v == l.engine.Id() {
-				l.setup.index = i
-			}
-		}
-	}
-	if gin.In().GetKey(gin.AnyLeft).FramePressCount() > 0 {
-		l.engine.ApplyEvent(SetupChampSelect{l.engine.Id(), -1})
-	}
-	if gin.In().GetKey(gin.AnyRight).FramePressCount() > 0 {
-		l.engine.ApplyEvent(SetupChampSelect{l.engine.Id(), 1})
-	}
-	if gin.In().GetKey(gin.AnyReturn).FramePressCount() > 0 {
-		if l.setup.index < len(g.Setup.EngineIds) {
-			id := g.Setup.EngineIds[l.setup.index]
-			side := (g.Setup.Sides[id].Side + 1) % 2
-			l.engine.ApplyEvent(SetupChangeSides{id, side})
-		} else {
-			if len(l.engine.Ids()) > 0 {
-				l.engine.ApplyEvent(SetupComplete{time.Now().UnixNano()})
-			}
-		}
-	}
-}
-
 func (l *LocalData) Think(g *Game) {
 	if g.Setup != nil {
 		l.Setup(g)
@@ -824,6 +771,8 @@ func (l *LocalData) Think(g *Game) {
 		l.localThinkInvaders(g)
 	case LocalModeMoba:
 		l.localThinkInvaders(g)
+	case LocalModeEditor:
+		l.localThinkEditor(g)
 	}
 }
 
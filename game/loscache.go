@@ -1,34 +1,123 @@
 package game
 
 import (
+	"container/list"
+	"fmt"
 	"github.com/runningwild/linear"
 	"github.com/runningwild/magnus/los"
 	"github.com/runningwild/magnus/stats"
 	"math"
+	"runtime"
 	"sort"
 	"sync"
+	"time"
 )
 
 const LosGridSize = 16
 
+// Rough number of bytes used by each visiblePos in the cache.
+const losCacheBytesPerPos = 12
+
+// Los is only cached once for each LosGridSize by LosGridSize cell of a room,
+// from the center of the cell, so anything anywhere in a cell sees what the
+// center of it sees.  The only exception is a viewer that can't see the center
+// of its own cell, which gets los from exactly where it is instead.
+type LosCacheOptions struct {
+	// The cache will evict the least recently used entries to stay under this
+	// many bytes.
+	MaxBytes int
+
+	// If Precompute is set then every time the walls change the cache is filled
+	// in the background by Workers goroutines, until it is full.  If Workers is
+	// zero then one is used for each cpu.
+	Precompute bool
+	Workers    int
+
+	// If Persist is set the cache is saved to the data directory, keyed by the
+	// walls that it was computed with, and loaded in the background the next
+	// time those same walls are used.  If there isn't a saved cache for the
+	// walls one is precomputed and saved.  The host sends its saved cache to
+	// everyone else when the game starts, see LosCacheData, so only the host
+	// needs this.  It is off by default since precomputing uses every cpu.
+	Persist bool
+}
+
+// LosCacheSettings is used by every los cache that is created after it is set.
+// The default limit fits every cell of the bundled rooms, or of an empty
+// 1024x1024 room, which take around 150 to 180MB.
+var LosCacheSettings = LosCacheOptions{
+	MaxBytes: 256 << 20,
+}
+
+// LosCacheMetrics is a snapshot of how well a los cache is doing, it's only
+// meant for debugging.
+type LosCacheMetrics struct {
+	// Gets that were answered from the cache, that had to compute an entry, and
+	// that had to be computed without the cache because the viewer couldn't see
+	// the center of its grid cell.
+	Hits, Misses, Uncached int64
+
+	Evictions   int64
+	Precomputed int64
+
+	Entries int
+	Bytes   int
+
+	// Total time spent computing los, both for misses and precomputation.
+	ComputeTime time.Duration
+}
+
+func (m LosCacheMetrics) HitRate() float64 {
+	total := m.Hits + m.Misses + m.Uncached
+	if total == 0 {
+		return 0
+	}
+	return float64(m.Hits) / float64(total)
+}
+
+func (m LosCacheMetrics) String() string {
+	return fmt.Sprintf("hit rate %.3f (%d hits, %d misses, %d uncached), %d entries, %d KB, %d evicted, %d precomputed, %v computing",
+		m.HitRate(), m.Hits, m.Misses, m.Uncached, m.Entries, m.Bytes>>10, m.Evictions, m.Precomputed, m.ComputeTime)
+}
+
 type losCache struct {
 	losBuffers      []*los.Los
 	losBuffersMutex sync.Mutex
 
-	cache      map[losCacheViewerPos][]visiblePos
+	// Entries are keyed by grid cell and computed from the center of that cell.
+	// lru has the most recently used entries at the front.
+	cache      map[losCacheViewerPos]*list.Element
+	lru        *list.List
+	bytes      int
+	metrics    LosCacheMetrics
 	cacheMutex sync.Mutex
 
+	// The walls that entries are computed with, and their key as returned by
+	// losCacheKey.  generation is incremented every time they change so that
+	// background workers know to stop.  All of these are protected by
+	// cacheMutex.
+	wallCache  *wallCache
+	key        string
+	generation int
+
 	// These values are read-only after creation so not mutexes are needed.
-	wallCache *wallCache
-	dx, dy    int
+	dx, dy  int
+	options LosCacheOptions
+}
+
+type losCacheEntry struct {
+	pos losCacheViewerPos
+	vps []visiblePos
 }
 
 func makeLosCache(dx, dy int) *losCache {
 	var lc losCache
-	lc.cache = make(map[losCacheViewerPos][]visiblePos)
+	lc.cache = make(map[losCacheViewerPos]*list.Element)
+	lc.lru = list.New()
 	lc.wallCache = &wallCache{}
 	lc.dx = dx / LosGridSize
 	lc.dy = dy / LosGridSize
+	lc.options = LosCacheSettings
 	return &lc
 }
 
@@ -36,10 +125,12 @@ type losCacheViewerPos struct {
 	X, Y int
 }
 
+// visiblePos is kept small since the cache holds thousands of them for every
+// cell in the room.
 type visiblePos struct {
-	X, Y int
+	X, Y int16
 	Val  byte
-	Dist float64
+	Dist float32
 }
 
 type visiblePosInternal struct {
@@ -55,9 +146,63 @@ func (vps visiblePosInternalSlice) Len() int           { return len(vps) }
 func (vps visiblePosInternalSlice) Less(i, j int) bool { return vps[i].Dist < vps[j].Dist }
 func (vps visiblePosInternalSlice) Swap(i, j int)      { vps[i], vps[j] = vps[j], vps[i] }
 
-func (lc *losCache) SetWallCache(wc *wallCache) {
+// SetWallCache sets the walls that los is computed with.  key identifies the
+// walls, if it is the same as the key for the entries that are already in the
+// cache then they are kept.
+func (lc *losCache) SetWallCache(wc *wallCache, key string) {
+	lc.cacheMutex.Lock()
 	lc.wallCache = wc
-	lc.cache = make(map[losCacheViewerPos][]visiblePos)
+	if key != "" && key == lc.key {
+		lc.cacheMutex.Unlock()
+		return
+	}
+	lc.reset(key)
+	generation := lc.generation
+	lc.cacheMutex.Unlock()
+
+	if !lc.options.Precompute && !lc.options.Persist {
+		return
+	}
+	go func() {
+		loaded := lc.options.Persist && lc.load(savedLosCache(key))
+		if lc.options.Precompute || (lc.options.Persist && !loaded) {
+			lc.precompute(wc, generation)
+		}
+	}()
+}
+
+// Key returns the key of the walls that the cache was computed with.
+func (lc *losCache) Key() string {
+	lc.cacheMutex.Lock()
+	defer lc.cacheMutex.Unlock()
+	return lc.key
+}
+
+// reset throws away every entry.  cacheMutex must be held.
+func (lc *losCache) reset(key string) {
+	lc.cache = make(map[losCacheViewerPos]*list.Element)
+	lc.lru.Init()
+	lc.bytes = 0
+	lc.key = key
+	lc.generation++
+}
+
+// Metrics returns the metrics for this cache so far.
+func (lc *losCache) Metrics() LosCacheMetrics {
+	lc.cacheMutex.Lock()
+	defer lc.cacheMutex.Unlock()
+	metrics := lc.metrics
+	metrics.Entries = len(lc.cache)
+	metrics.Bytes = lc.bytes
+	return metrics
+}
+
+// LosCacheMetrics returns the metrics for the los cache, if there is one.
+func (g *Game) LosCacheMetrics() LosCacheMetrics {
+	if g.Moba == nil || g.Moba.losCache == nil {
+		return LosCacheMetrics{}
+	}
+	return g.Moba.losCache.Metrics()
 }
 
 // Includes all possible visiblePosInternal values in order of distance
@@ -85,20 +230,137 @@ func init() {
 	sort.Sort(maxVps)
 }
 
+func cellCenter(x, y int) linear.Vec2 {
+	return linear.Vec2{(float64(x) + 0.5) * LosGridSize, (float64(y) + 0.5) * LosGridSize}
+}
+
 // losCache.Get() is Thread-Safe
 func (lc *losCache) Get(i, j int, maxDist float64) []visiblePos {
-	vp := losCacheViewerPos{i, j}
-	var vps []visiblePos
-	var ok bool
+	vp := losCacheViewerPos{i / LosGridSize, j / LosGridSize}
+	pos := linear.Vec2{float64(i) + 0.5, float64(j) + 0.5}
+
 	lc.cacheMutex.Lock()
-	vps, ok = lc.cache[vp]
-	lc.cacheMutex.Unlock()
+	wc := lc.wallCache
+	elem, ok := lc.cache[vp]
 	if ok {
-		var max int
-		for max = 0; max < len(vps) && vps[max].Dist <= maxDist; max++ {
+		lc.lru.MoveToFront(elem)
+		lc.metrics.Hits++
+		vps := elem.Value.(*losCacheEntry).vps
+		lc.cacheMutex.Unlock()
+		return sliceToDist(vps, maxDist)
+	}
+	lc.cacheMutex.Unlock()
+
+	// Cached entries are computed from the center of the cell.  If a wall is in
+	// the way of the center, for example if the center is inside of the wall,
+	// then the viewer would see the wrong thing, so we compute this one exactly
+	// and don't cache it.
+	center := cellCenter(vp.X, vp.Y)
+	toCenter := linear.Seg2{pos, center}
+	for _, wall := range wc.GetWalls(i, j) {
+		if toCenter.DoesIsect(wall) {
+			vps := lc.compute(wc, pos)
+			lc.cacheMutex.Lock()
+			lc.metrics.Uncached++
+			lc.cacheMutex.Unlock()
+			return sliceToDist(vps, maxDist)
+		}
+	}
+
+	vps := lc.compute(wc, center)
+	lc.cacheMutex.Lock()
+	lc.metrics.Misses++
+	if lc.wallCache == wc {
+		lc.insert(vp, vps)
+	}
+	lc.cacheMutex.Unlock()
+	return sliceToDist(vps, maxDist)
+}
+
+// sliceToDist returns the prefix of vps, which is sorted by distance, that is
+// within maxDist.
+func sliceToDist(vps []visiblePos, maxDist float64) []visiblePos {
+	var max int
+	for max = 0; max < len(vps) && float64(vps[max].Dist) <= maxDist; max++ {
+	}
+	return vps[0:max]
+}
+
+// insert adds an entry to the cache and evicts the least recently used entries
+// until the cache fits in its memory limit again.  cacheMutex must be held.
+func (lc *losCache) insert(vp losCacheViewerPos, vps []visiblePos) {
+	if _, ok := lc.cache[vp]; ok {
+		return
+	}
+	lc.cache[vp] = lc.lru.PushFront(&losCacheEntry{vp, vps})
+	lc.bytes += len(vps) * losCacheBytesPerPos
+	for lc.bytes > lc.options.MaxBytes && lc.lru.Len() > 1 {
+		back := lc.lru.Back()
+		entry := back.Value.(*losCacheEntry)
+		lc.lru.Remove(back)
+		delete(lc.cache, entry.pos)
+		lc.bytes -= len(entry.vps) * losCacheBytesPerPos
+		lc.metrics.Evictions++
+	}
+}
+
+// precompute fills the cache using a pool of workers.  It stops once the cache
+// is full, or as soon as the walls change.
+func (lc *losCache) precompute(wc *wallCache, generation int) {
+	workers := lc.options.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	cells := make(chan losCacheViewerPos)
+	computed := 0
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for vp := range cells {
+				vps := lc.compute(wc, cellCenter(vp.X, vp.Y))
+				lc.cacheMutex.Lock()
+				if lc.generation == generation {
+					lc.insert(vp, vps)
+					lc.metrics.Precomputed++
+					computed++
+				}
+				lc.cacheMutex.Unlock()
+			}
+		}()
+	}
+fill:
+	for y := 0; y < lc.dy; y++ {
+		for x := 0; x < lc.dx; x++ {
+			lc.cacheMutex.Lock()
+			done := lc.generation != generation || lc.bytes >= lc.options.MaxBytes
+			_, ok := lc.cache[losCacheViewerPos{x, y}]
+			lc.cacheMutex.Unlock()
+			if done {
+				break fill
+			}
+			if !ok {
+				cells <- losCacheViewerPos{x, y}
+			}
 		}
-		return vps[0:max]
 	}
+	close(cells)
+	wg.Wait()
+	if lc.options.Persist && computed > 0 {
+		lc.save(generation)
+	}
+}
+
+// compute finds everything visible from pos with the walls in wc, sorted by
+// distance.
+func (lc *losCache) compute(wc *wallCache, pos linear.Vec2) []visiblePos {
+	start := time.Now()
+	defer func() {
+		lc.cacheMutex.Lock()
+		lc.metrics.ComputeTime += time.Since(start)
+		lc.cacheMutex.Unlock()
+	}()
 
 	lc.losBuffersMutex.Lock()
 	var losBuffer *los.Los
@@ -110,10 +372,9 @@ func (lc *losCache) Get(i, j int, maxDist float64) []visiblePos {
 	}
 	lc.losBuffersMutex.Unlock()
 
-	pos := linear.Vec2{float64(i) + 0.5, float64(j) + 0.5}
 	losBuffer.Reset(pos)
-	vps = make([]visiblePos, len(maxVps))[0:0]
-	for _, wall := range lc.wallCache.GetWalls(i, j) {
+	vps := make([]visiblePos, len(maxVps))[0:0]
+	for _, wall := range wc.GetWalls(int(pos.X), int(pos.Y)) {
 		mid := wall.P.Add(wall.Q).Scale(0.5)
 		if mid.Sub(pos).Mag() < stats.LosPlayerHorizon+wall.Ray().Mag() {
 			losBuffer.DrawSeg(wall, "")
@@ -149,23 +410,16 @@ func (lc *losCache) Get(i, j int, maxDist float64) []visiblePos {
 		}
 		if val < 255 {
 			vps = append(vps, visiblePos{
-				X:    x,
-				Y:    y,
+				X:    int16(x),
+				Y:    int16(y),
 				Val:  byte(val),
-				Dist: dist,
+				Dist: float32(dist),
 			})
 		}
 	}
-	lc.cacheMutex.Lock()
-	lc.cache[vp] = vps
-	lc.cacheMutex.Unlock()
 
 	lc.losBuffersMutex.Lock()
 	lc.losBuffers = append(lc.losBuffers, losBuffer)
 	lc.losBuffersMutex.Unlock()
-
-	// Call this function again because now the values are cached.  We do this
-	// because we also need to slice the return value appropriately for maxDist
-	// and shouldn't have that logic in two places.
-	return lc.Get(i, j, maxDist)
+	return vps
 }
@@ -0,0 +1,221 @@
+package game
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"github.com/runningwild/linear"
+	"github.com/runningwild/magnus/base"
+	"github.com/runningwild/magnus/los"
+	"github.com/runningwild/magnus/stats"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Number of saved los caches to keep around, the least recently written ones
+// are deleted once there are more than this.
+const losCacheMaxFiles = 20
+
+// losCacheFile is what gets written to disk for each set of walls.
+type losCacheFile struct {
+	Key     string
+	Entries []losCacheFileEntry
+}
+
+type losCacheFileEntry struct {
+	Pos losCacheViewerPos
+	Vps []visiblePos
+}
+
+// losCacheKey returns a key that identifies everything that the contents of a
+// los cache depend on: the dimensions of the room, the walls that block vision,
+// and the resolution of the los grid.
+func losCacheKey(dx, dy int, walls []linear.Seg2) string {
+	h := sha1.New()
+	for _, v := range []int64{LosGridSize, stats.LosPlayerHorizon, los.Resolution, int64(dx), int64(dy)} {
+		binary.Write(h, binary.LittleEndian, v)
+	}
+	for _, wall := range walls {
+		for _, f := range []float64{wall.P.X, wall.P.Y, wall.Q.X, wall.Q.Y} {
+			binary.Write(h, binary.LittleEndian, math.Float64bits(f))
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+func losCacheDir() string {
+	return filepath.Join(base.GetDataDir(), "loscache")
+}
+
+func losCachePath(key string) string {
+	return filepath.Join(losCacheDir(), key+".gob.gz")
+}
+
+// savedLosCache returns the saved cache for key as it is on disk, or nil if
+// there isn't one.
+func savedLosCache(key string) []byte {
+	if key == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(losCachePath(key))
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// load adds the entries from a saved cache, and returns true if it did.  Saved
+// caches for other walls are ignored.  Since the entries for a set of walls
+// never change it doesn't matter whether they were computed here or on some
+// other machine.
+func (lc *losCache) load(data []byte) bool {
+	if data == nil {
+		return false
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		base.Warn().Printf("Unable to read los cache: %v", err)
+		return false
+	}
+	var file losCacheFile
+	err = gob.NewDecoder(gz).Decode(&file)
+	if err != nil {
+		base.Warn().Printf("Unable to read los cache: %v", err)
+		return false
+	}
+
+	lc.cacheMutex.Lock()
+	defer lc.cacheMutex.Unlock()
+	if file.Key != lc.key {
+		base.Warn().Printf("Ignoring los cache %s, the walls are %s", file.Key, lc.key)
+		return false
+	}
+	for _, entry := range file.Entries {
+		if entry.Pos.X < 0 || entry.Pos.Y < 0 || entry.Pos.X >= lc.dx || entry.Pos.Y >= lc.dy {
+			continue
+		}
+		lc.insert(entry.Pos, entry.Vps)
+	}
+	base.Log().Printf("Loaded los cache %s with %d entries", file.Key, len(file.Entries))
+	return true
+}
+
+// LosCacheData is a saved los cache that the host sends once the game has
+// started, so that everyone starts with the host's cache rather than an empty
+// one.  It is loaded in the background, it doesn't change the game, only how
+// long vision takes to compute.
+type LosCacheData struct {
+	Data []byte
+}
+
+func init() {
+	gob.Register(LosCacheData{})
+}
+
+func (l LosCacheData) Apply(_g interface{}) {
+	g := _g.(*Game)
+	g.LoadLosCache(l.Data)
+}
+
+// LoadLosCache loads a saved los cache, like the one in LosCacheData, in the
+// background.
+func (g *Game) LoadLosCache(data []byte) {
+	if g.Moba == nil || g.Moba.losCache == nil {
+		return
+	}
+	go g.Moba.losCache.load(data)
+}
+
+// LosCacheEvent returns a LosCacheData with the saved cache for the current
+// walls, if there is one.  Only the host should call this, once, after the
+// game has thought at least once so that the walls are known.
+func (g *Game) LosCacheEvent() (LosCacheData, bool) {
+	if g.Moba == nil || g.Moba.losCache == nil {
+		return LosCacheData{}, false
+	}
+	data := savedLosCache(g.Moba.losCache.Key())
+	return LosCacheData{data}, data != nil
+}
+
+// save writes the cache to disk, as long as the walls haven't changed since
+// generation.
+func (lc *losCache) save(generation int) {
+	lc.cacheMutex.Lock()
+	if lc.generation != generation || lc.key == "" {
+		lc.cacheMutex.Unlock()
+		return
+	}
+	file := losCacheFile{Key: lc.key}
+	// Oldest first, so that the entries end up in the same order when they're
+	// loaded again.
+	for elem := lc.lru.Back(); elem != nil; elem = elem.Prev() {
+		entry := elem.Value.(*losCacheEntry)
+		file.Entries = append(file.Entries, losCacheFileEntry{entry.pos, entry.vps})
+	}
+	lc.cacheMutex.Unlock()
+
+	err := os.MkdirAll(losCacheDir(), 0777)
+	if err != nil {
+		base.Warn().Printf("Unable to save los cache: %v", err)
+		return
+	}
+	// Write to a temporary file first so that nothing ever reads a partially
+	// written cache.
+	tmp, err := ioutil.TempFile(losCacheDir(), "tmp")
+	if err != nil {
+		base.Warn().Printf("Unable to save los cache: %v", err)
+		return
+	}
+	gz := gzip.NewWriter(tmp)
+	err = gob.NewEncoder(gz).Encode(file)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), losCachePath(file.Key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		base.Warn().Printf("Unable to save los cache: %v", err)
+		return
+	}
+	base.Log().Printf("Saved los cache %s with %d entries", file.Key, len(file.Entries))
+	pruneLosCacheFiles()
+}
+
+type fileInfosByTime []os.FileInfo
+
+func (f fileInfosByTime) Len() int           { return len(f) }
+func (f fileInfosByTime) Less(i, j int) bool { return f[i].ModTime().After(f[j].ModTime()) }
+func (f fileInfosByTime) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+
+// pruneLosCacheFiles deletes all but the most recently written saved caches.
+func pruneLosCacheFiles() {
+	infos, err := ioutil.ReadDir(losCacheDir())
+	if err != nil {
+		return
+	}
+	var caches []os.FileInfo
+	for _, info := range infos {
+		if filepath.Ext(info.Name()) == ".gz" {
+			caches = append(caches, info)
+		}
+	}
+	sort.Sort(fileInfosByTime(caches))
+	for i := losCacheMaxFiles; i < len(caches); i++ {
+		os.Remove(filepath.Join(losCacheDir(), caches[i].Name()))
+	}
+}
+
+func roomLosCacheKey(room *Room) string {
+	return losCacheKey(room.Dx, room.Dy, roomVisibleWalls(room))
+}
@@ -0,0 +1,228 @@
+package game
+
+import (
+	"fmt"
+	gl "github.com/chsc/gogl/gl21"
+	"github.com/runningwild/glop/gui"
+	"github.com/runningwild/linear"
+	"github.com/runningwild/magnus/base"
+	"github.com/runningwild/magnus/texture"
+	"math"
+	"math/rand"
+)
+
+type ManaEventKind int
+
+const (
+	// A surge fills the nodes in a region with one color of mana, well past
+	// what they can normally hold.
+	ManaSurge ManaEventKind = iota
+
+	// A drought steadily drains every color of mana from a region.
+	ManaDrought
+
+	// A migration slowly moves a seed, and the mana around it, somewhere else.
+	ManaMigration
+)
+
+func (k ManaEventKind) String() string {
+	switch k {
+	case ManaSurge:
+		return "Surge"
+	case ManaDrought:
+		return "Drought"
+	case ManaMigration:
+		return "Migration"
+	}
+	return fmt.Sprintf("ManaEventKind(%d)", int(k))
+}
+
+const (
+	// Events are announced this many frames before they start.
+	ManaEventAnnounceFrames = 60 * 10
+
+	// Default number of frames from the end of one event to the announcement
+	// of the next one.
+	defaultManaEventInterval = 60 * 45
+
+	// Fraction of a node's max mana that a surge adds every frame at its
+	// center, and the most that a surge can fill a node to.
+	manaSurgeRate = 0.01
+	manaSurgeMax  = 3.0
+
+	// Fraction of a node's mana that a drought drains every frame at its
+	// center.
+	manaDroughtRate = 0.01
+
+	// Migrating seeds only update the nodes this often, since that means
+	// recomputing every node.
+	manaMigrationStep = 10
+)
+
+// A ManaEvent changes the mana in part of a room for a while.  Times are in
+// terms of Game.GameThinks.
+type ManaEvent struct {
+	Kind ManaEventKind
+
+	Announce, Start, End int
+
+	// The region that a surge or drought affects, and the color that a surge
+	// adds.
+	Center linear.Vec2
+	Radius float64
+	Color  Color
+
+	// The seed that a migration moves, and where it moves from and to.
+	Seed     int
+	From, To linear.Vec2
+}
+
+// Active returns true if the event is happening on frame thinks.
+func (e *ManaEvent) Active(thinks int) bool {
+	return thinks >= e.Start && thinks < e.End
+}
+
+// where returns where the event is happening on frame thinks, which only
+// changes for migrations.
+func (e *ManaEvent) where(thinks int) linear.Vec2 {
+	if e.Kind != ManaMigration {
+		return e.Center
+	}
+	frac := float64(thinks-e.Start) / float64(e.End-e.Start)
+	frac = math.Max(0, math.Min(1, frac))
+	return e.From.Add(e.To.Sub(e.From).Scale(frac))
+}
+
+// ManaEvents returns every event that has been announced and hasn't ended yet.
+func (g *Game) ManaEvents() []ManaEvent {
+	if g.Moba == nil {
+		return nil
+	}
+	return g.Moba.ManaEvents
+}
+
+// thinkManaEvents announces new mana events, applies the ones that are
+// happening, and forgets the ones that are over.  Everything is driven by
+// g.Rng so every client sees the same events.
+func (g *Game) thinkManaEvents() {
+	level := g.Levels[GidInvadersStart]
+	interval := level.Room.Mana.EventInterval
+	if interval < 0 {
+		return
+	}
+	if interval == 0 {
+		interval = defaultManaEventInterval
+	}
+	moba := g.Moba
+	if moba.NextManaEvent == 0 {
+		moba.NextManaEvent = g.GameThinks + interval
+	}
+	if g.GameThinks >= moba.NextManaEvent {
+		event := g.makeManaEvent(level)
+		moba.ManaEvents = append(moba.ManaEvents, event)
+		moba.NextManaEvent = event.End + interval
+		base.Log().Printf("Mana event: %v at %v starting on frame %d", event.Kind, event.Center, event.Start)
+	}
+
+	ms := &level.ManaSource
+	var remaining []ManaEvent
+	for i := range moba.ManaEvents {
+		event := &moba.ManaEvents[i]
+		if event.Active(g.GameThinks) {
+			ms.applyEvent(event, g.GameThinks)
+		}
+		if g.GameThinks < event.End {
+			remaining = append(remaining, *event)
+		} else if event.Kind == ManaMigration {
+			ms.moveSeed(event.Seed, event.To)
+		}
+	}
+	moba.ManaEvents = remaining
+}
+
+func (g *Game) makeManaEvent(level *Level) ManaEvent {
+	r := rand.New(g.Rng)
+	dx := float64(level.Room.Dx)
+	dy := float64(level.Room.Dy)
+	var event ManaEvent
+	event.Kind = ManaEventKind(r.Intn(3))
+	event.Announce = g.GameThinks
+	event.Start = g.GameThinks + ManaEventAnnounceFrames
+	event.Center = linear.Vec2{r.Float64() * dx, r.Float64() * dy}
+	event.Radius = 150 + r.Float64()*150
+	event.Color = AllColors[r.Intn(len(AllColors))]
+	seeds := level.ManaSource.Seeds()
+	if event.Kind == ManaMigration && len(seeds) == 0 {
+		event.Kind = ManaSurge
+	}
+	switch event.Kind {
+	case ManaSurge, ManaDrought:
+		event.End = event.Start + 60*(10+r.Intn(11))
+	case ManaMigration:
+		event.Seed = r.Intn(len(seeds))
+		event.From = seeds[event.Seed].Pos
+		event.To = event.From.Add((linear.Vec2{200 + r.Float64()*200, 0}).Rotate(r.Float64() * 2 * math.Pi))
+		event.To.X = clamp(event.To.X, 0, dx)
+		event.To.Y = clamp(event.To.Y, 0, dy)
+		event.Center = event.From
+		event.Radius = 100
+		event.Color = Color(seeds[event.Seed].Color)
+		event.End = event.Start + 60*30
+	}
+	return event
+}
+
+func (ms *ManaSource) applyEvent(event *ManaEvent, thinks int) {
+	switch event.Kind {
+	case ManaSurge:
+		c := int(event.Color)
+		ms.alterRegion(event.Center, event.Radius, func(n *node, weight float64) {
+			max := n.MaxMana[c] * manaSurgeMax
+			if n.Mana[c] < max {
+				n.Mana[c] = math.Min(max, n.Mana[c]+n.MaxMana[c]*manaSurgeRate*weight)
+			}
+		})
+	case ManaDrought:
+		ms.alterRegion(event.Center, event.Radius, func(n *node, weight float64) {
+			for c := range n.Mana {
+				n.Mana[c] -= n.Mana[c] * manaDroughtRate * weight
+			}
+		})
+	case ManaMigration:
+		if (thinks-event.Start)%manaMigrationStep == 0 {
+			ms.moveSeed(event.Seed, event.where(thinks))
+		}
+	}
+}
+
+var manaEventColors = map[ManaEventKind][3]byte{
+	ManaSurge:     {255, 255, 255},
+	ManaDrought:   {120, 60, 0},
+	ManaMigration: {200, 200, 50},
+}
+
+// renderManaEvents outlines every announced mana event along with a countdown
+// to when it starts.
+func (g *Game) renderManaEvents() {
+	dict := base.GetDictionary("luxisr")
+	for _, event := range g.ManaEvents() {
+		pos := event.where(g.GameThinks)
+		color := manaEventColors[event.Kind]
+		alpha := 100
+		if event.Active(g.GameThinks) {
+			alpha = 200
+		}
+		base.EnableShader("circle")
+		base.SetUniformF("circle", "edge", 0.95)
+		gl.Color4ub(gl.Ubyte(color[0]), gl.Ubyte(color[1]), gl.Ubyte(color[2]), gl.Ubyte(alpha))
+		texture.Render(pos.X-event.Radius, pos.Y-event.Radius, 2*event.Radius, 2*event.Radius)
+		base.EnableShader("")
+
+		text := event.Kind.String()
+		if !event.Active(g.GameThinks) {
+			text = fmt.Sprintf("%s in %d", text, (event.Start-g.GameThinks+59)/60)
+		}
+		gui.SetFontColor(float64(color[0])/255, float64(color[1])/255, float64(color[2])/255, 1)
+		dict.RenderString(text, pos.X, pos.Y, 0, 40, gui.Center)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/runningwild/magnus/texture"
 	"math"
 	"math/rand"
+	"sort"
 	"sync"
 )
 
@@ -85,8 +86,99 @@ func (m Mana) Magnitude() float64 {
 	return m[0] + m[1] + m[2]
 }
 
+// A ManaSeed is a point that one color of mana is centered around.
+type ManaSeed struct {
+	Pos   linear.Vec2
+	Color int
+}
+
+// RoomMana is the mana configuration for a room.  Any field that is left as
+// zero gets a default value, so a room that doesn't say anything about mana
+// gets randomly placed seeds.
+type RoomMana struct {
+	// If Seeds is set these are used instead of placing NumSeeds seeds
+	// randomly.
+	Seeds    []ManaSeed
+	NumSeeds int
+
+	// Distance between adjacent nodes.
+	NodeSpacing int
+
+	MaxDrainDistance float64
+	MaxDrainRate     float64
+
+	// Fraction of each color of mana that a node regains each frame.
+	RegenPerFrame Mana
+	NodeMagnitude float64
+
+	// Frames between the end of one mana event and the announcement of the
+	// next, or negative for no events.
+	EventInterval int
+}
+
+var defaultRoomMana = RoomMana{
+	NumSeeds:         20,
+	NodeSpacing:      32,
+	MaxDrainDistance: 120.0,
+	MaxDrainRate:     5.0,
+	RegenPerFrame:    Mana{0.002, 0.002, 0.002},
+	NodeMagnitude:    100,
+}
+
+// manaSourceOptions returns the options for the mana source in room, filling
+// in the defaults for anything the room doesn't specify.
+func manaSourceOptions(room *Room, rng *cmwc.Cmwc) ManaSourceOptions {
+	rm := room.Mana
+	if rm.NumSeeds == 0 {
+		rm.NumSeeds = defaultRoomMana.NumSeeds
+	}
+	if rm.NodeSpacing <= 0 {
+		rm.NodeSpacing = defaultRoomMana.NodeSpacing
+	}
+	if rm.MaxDrainDistance == 0 {
+		rm.MaxDrainDistance = defaultRoomMana.MaxDrainDistance
+	}
+	if rm.MaxDrainRate == 0 {
+		rm.MaxDrainRate = defaultRoomMana.MaxDrainRate
+	}
+	if rm.RegenPerFrame == (Mana{}) {
+		rm.RegenPerFrame = defaultRoomMana.RegenPerFrame
+	}
+	if rm.NodeMagnitude == 0 {
+		rm.NodeMagnitude = defaultRoomMana.NodeMagnitude
+	}
+	for _, seed := range rm.Seeds {
+		if seed.Color < 0 || seed.Color >= len(Mana{}) {
+			base.Warn().Printf("Ignoring mana seed with invalid color %d", seed.Color)
+		}
+	}
+	return ManaSourceOptions{
+		NumSeeds:    rm.NumSeeds,
+		Seeds:       rm.Seeds,
+		NumNodeRows: room.Dy / rm.NodeSpacing,
+		NumNodeCols: room.Dx / rm.NodeSpacing,
+
+		BoardLeft:   0,
+		BoardTop:    0,
+		BoardRight:  float64(room.Dx),
+		BoardBottom: float64(room.Dy),
+
+		MaxDrainDistance: rm.MaxDrainDistance,
+		MaxDrainRate:     rm.MaxDrainRate,
+
+		RegenPerFrame:     rm.RegenPerFrame,
+		NodeMagnitude:     rm.NodeMagnitude,
+		MinNodeBrightness: 20,
+		MaxNodeBrightness: 150,
+
+		Rng: rng,
+	}
+}
+
 type ManaSourceOptions struct {
-	NumSeeds    int
+	NumSeeds int
+	// If set these are used instead of NumSeeds random seeds.
+	Seeds       []ManaSeed
 	NumNodeRows int
 	NumNodeCols int
 
@@ -98,7 +190,7 @@ type ManaSourceOptions struct {
 	MaxDrainDistance float64
 	MaxDrainRate     float64
 
-	RegenPerFrame     float64
+	RegenPerFrame     Mana
 	NodeMagnitude     float64
 	MinNodeBrightness int
 	MaxNodeBrightness int
@@ -108,22 +200,23 @@ type ManaSourceOptions struct {
 
 type node struct {
 	X, Y          float64
-	RegenPerFrame float64
+	RegenPerFrame Mana
 	Mana          Mana
 	MaxMana       Mana
 }
 
-type nodeSeed struct {
-	x, y  float64
-	color int
-}
-
 type ManaSource struct {
 	options ManaSourceOptions
 
 	nodes    [][]node
 	rawNodes []node // the underlying array for nodes
 
+	// Where each color of mana comes from, MaxMana for every node is computed
+	// from these.
+	seeds []ManaSeed
+
+	thinkData manaThinkData
+
 	thinks int
 }
 
@@ -147,6 +240,9 @@ func (ms *ManaSource) GobEncode() ([]byte, error) {
 	if err == nil {
 		err = enc.Encode(ms.rawNodes)
 	}
+	if err == nil {
+		err = enc.Encode(ms.seeds)
+	}
 	return buf.Bytes(), err
 }
 
@@ -176,6 +272,9 @@ func (ms *ManaSource) GobDecode(data []byte) error {
 	if err == nil {
 		err = dec.Decode(&ms.rawNodes)
 	}
+	if err == nil {
+		err = dec.Decode(&ms.seeds)
+	}
 	if err == nil {
 		ms.nodes = make([][]node, d1)
 		for i := range ms.nodes {
@@ -185,6 +284,29 @@ func (ms *ManaSource) GobDecode(data []byte) error {
 	return err
 }
 
+// copyHidden returns a copy of ms that only shows how much mana is left in
+// the nodes that visible returns true for, every other node is shown as full.
+// The copy is only meant to be sent to a client, it can't think.
+func (ms *ManaSource) copyHidden(visible func(pos linear.Vec2) bool) ManaSource {
+	cp := *ms
+	cp.options.Rng = nil
+	cp.thinkData = manaThinkData{}
+	cp.rawNodes = make([]node, len(ms.rawNodes))
+	copy(cp.rawNodes, ms.rawNodes)
+	cp.nodes = make([][]node, len(ms.nodes))
+	for i := range ms.nodes {
+		rows := len(ms.nodes[i])
+		cp.nodes[i] = cp.rawNodes[i*rows : (i+1)*rows]
+	}
+	for i := range cp.rawNodes {
+		n := &cp.rawNodes[i]
+		if !visible(linear.Vec2{n.X, n.Y}) {
+			n.Mana = n.MaxMana
+		}
+	}
+	return cp
+}
+
 func normalizeWeights(desiredSum float64, weights []float64) {
 	sum := 0.0
 	for i := range weights {
@@ -204,12 +326,22 @@ func (ms *ManaSource) Init(options *ManaSourceOptions) {
 
 	r := rand.New(options.Rng)
 
-	seeds := make([]nodeSeed, options.NumSeeds)
-	for i := range seeds {
-		seed := &seeds[i]
-		seed.x = options.BoardLeft + r.Float64()*(options.BoardRight-options.BoardLeft)
-		seed.y = options.BoardTop + r.Float64()*(options.BoardBottom-options.BoardTop)
-		seed.color = r.Intn(3)
+	ms.seeds = nil
+	if len(options.Seeds) > 0 {
+		for _, seed := range options.Seeds {
+			if seed.Color < 0 || seed.Color >= len(Mana{}) {
+				continue
+			}
+			ms.seeds = append(ms.seeds, seed)
+		}
+	} else {
+		ms.seeds = make([]ManaSeed, options.NumSeeds)
+		for i := range ms.seeds {
+			seed := &ms.seeds[i]
+			seed.Pos.X = options.BoardLeft + r.Float64()*(options.BoardRight-options.BoardLeft)
+			seed.Pos.Y = options.BoardTop + r.Float64()*(options.BoardBottom-options.BoardTop)
+			seed.Color = r.Intn(3)
+		}
 	}
 
 	ms.rawNodes = newNodes(options.NumNodeCols * options.NumNodeRows)
@@ -221,33 +353,66 @@ func (ms *ManaSource) Init(options *ManaSourceOptions) {
 			x := options.BoardLeft + float64(col)/float64(options.NumNodeCols-1)*(options.BoardRight-options.BoardLeft)
 			y := options.BoardTop + float64(row)/float64(options.NumNodeRows-1)*(options.BoardBottom-options.BoardTop)
 
-			maxWeightByColor := [3]float64{0.0, 0.0, 0.0}
-			for _, seed := range seeds {
-				c := seed.color
-				dx := x - seed.x
-				dy := y - seed.y
-				distSquared := dx*dx + dy*dy
-				weight := 1 / (distSquared + 1.0)
-				if weight > maxWeightByColor[c] {
-					maxWeightByColor[c] = weight
-				}
-			}
-
-			normalizeWeights(options.NodeMagnitude, maxWeightByColor[:])
-			var weightsCopy [3]float64
-			copy(weightsCopy[:], maxWeightByColor[:])
-
+			maxMana := ms.maxManaAt(x, y)
 			ms.nodes[col][row] = node{
 				X:             x,
 				Y:             y,
 				RegenPerFrame: options.RegenPerFrame,
-				Mana:          maxWeightByColor,
-				MaxMana:       weightsCopy,
+				Mana:          maxMana,
+				MaxMana:       maxMana,
 			}
 		}
 	}
 }
 
+// maxManaAt returns the most mana of each color that a node at x, y can hold
+// given where the seeds are.
+func (ms *ManaSource) maxManaAt(x, y float64) Mana {
+	var maxWeightByColor Mana
+	for _, seed := range ms.seeds {
+		c := seed.Color
+		dx := x - seed.Pos.X
+		dy := y - seed.Pos.Y
+		distSquared := dx*dx + dy*dy
+		weight := 1 / (distSquared + 1.0)
+		if weight > maxWeightByColor[c] {
+			maxWeightByColor[c] = weight
+		}
+	}
+	normalizeWeights(ms.options.NodeMagnitude, maxWeightByColor[:])
+	return maxWeightByColor
+}
+
+// Seeds returns where each color of mana currently comes from.
+func (ms *ManaSource) Seeds() []ManaSeed {
+	return ms.seeds
+}
+
+// moveSeed moves a seed and updates the nodes to match.  Nodes keep the mana
+// that they have and regenerate towards their new maximum.
+func (ms *ManaSource) moveSeed(i int, pos linear.Vec2) {
+	if i < 0 || i >= len(ms.seeds) || ms.seeds[i].Pos == pos {
+		return
+	}
+	ms.seeds[i].Pos = pos
+	for j := range ms.rawNodes {
+		node := &ms.rawNodes[j]
+		node.MaxMana = ms.maxManaAt(node.X, node.Y)
+	}
+}
+
+// alterRegion calls f on every node within radius of center with a weight
+// that is 1 at center and falls off to 0 at radius.
+func (ms *ManaSource) alterRegion(center linear.Vec2, radius float64, f func(n *node, weight float64)) {
+	for i := range ms.rawNodes {
+		node := &ms.rawNodes[i]
+		dist := linear.MakeVec2(node.X, node.Y).Sub(center).Mag()
+		if dist < radius {
+			f(node, 1-dist/radius)
+		}
+	}
+}
+
 // func (src *ManaSource) ReleaseResources() {
 // 	deleteNodes(src.rawNodes)
 // }
@@ -313,32 +478,52 @@ func (ms *ManaSource) Draw(local *LocalData, zoom float64, dx float64, dy float6
 	gl.Disable(gl.TEXTURE_2D)
 }
 
-type nodeThinkData struct {
-	// playerDistSquared and playerControl are both 0 if the node is not in the playerThinkData
-	// range.
-	playerDistSquared    []float64
-	playerControl        []float64
-	playerDrain          []Mana
-	hasSomePlayerControl bool
+// A ManaDrainer is an Ent that draws mana from the nodes around it, at a rate
+// that depends on its MaxRate stat.  Players drain mana, but so can anything
+// else that implements this, like AI controlled ents or minions.
+type ManaDrainer interface {
+	Ent
+
+	// DrainsMana returns true if this ent should draw mana this frame.
+	DrainsMana() bool
 }
 
-type playerThinkData struct {
-	minX       int
-	maxX       int
-	minY       int
-	maxY       int
-	drain      Mana
-	rateFactor float64
+// manaContribution is how much of one node's mana a drainer is draining this
+// frame.
+type manaContribution struct {
+	node        int
+	distSquared float64
+	control     float64
+	drain       Mana
 }
 
-func (p playerThinkData) isValid() bool {
-	return p.minX != -1 && p.maxX != -1 && p.minY != -1 && p.maxY != -1
+// manaDrainerData is the range of nodes that a drainer can reach and the
+// total mana that it is draining this frame.  Its contributions are
+// contributions[start:end].
+type manaDrainerData struct {
+	drainer    ManaDrainer
+	valid      bool
+	start, end int
+	drain      Mana
 }
 
-type thinkData struct {
-	nodeThinkData    [][]nodeThinkData
-	rawNodeThinkData []nodeThinkData // Underlying array for nodeThinkData
-	playerThinkData  []playerThinkData
+type manaDrainersByGid []manaDrainerData
+
+func (m manaDrainersByGid) Len() int           { return len(m) }
+func (m manaDrainersByGid) Less(i, j int) bool { return lessGids(m[i].drainer.Id(), m[j].drainer.Id()) }
+func (m manaDrainersByGid) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+
+// manaThinkData is scratch space for ManaSource.Think, it is kept around
+// between frames so that it doesn't have to be reallocated every time.  Only
+// nodes that are within MaxDrainDistance of some drainer are ever touched.
+type manaThinkData struct {
+	drainers      []manaDrainerData
+	contributions []manaContribution
+
+	// Sum of the control of every drainer over each node, and the nodes that
+	// have a non-zero sum and need to be reset before the next frame.
+	totalControl []float64
+	touched      []int
 }
 
 func (ms *ManaSource) regenerateMana() {
@@ -348,7 +533,7 @@ func (ms *ManaSource) regenerateMana() {
 			if node.MaxMana[c] == 0 {
 				continue
 			}
-			maxRecovery := node.MaxMana[c] * node.RegenPerFrame
+			maxRecovery := node.MaxMana[c] * node.RegenPerFrame[c]
 			scale := (node.MaxMana[c] - node.Mana[c]) / node.MaxMana[c]
 			node.Mana[c] += scale * maxRecovery
 			if scale != scale || maxRecovery != maxRecovery {
@@ -358,43 +543,6 @@ func (ms *ManaSource) regenerateMana() {
 	}
 }
 
-func (ms *ManaSource) initThinkData(td *thinkData, numPlayers int) {
-	if len(td.nodeThinkData) != len(ms.nodes) {
-		td.rawNodeThinkData = make([]nodeThinkData, len(ms.rawNodes))
-		td.nodeThinkData = make([][]nodeThinkData, len(ms.nodes))
-		for i := range td.nodeThinkData {
-			td.nodeThinkData[i] =
-				td.rawNodeThinkData[i*ms.options.NumNodeRows : (i+1)*ms.options.NumNodeRows]
-		}
-	}
-
-	for i := range ms.rawNodes {
-		node := &td.rawNodeThinkData[i]
-		if len(node.playerDistSquared) != numPlayers {
-			node.playerDistSquared = make([]float64, numPlayers)
-			node.playerControl = make([]float64, numPlayers)
-			node.playerDrain = make([]Mana, numPlayers)
-		}
-		for i := 0; i < numPlayers; i++ {
-			node.playerDistSquared[i] = 0
-			node.playerControl[i] = 0
-			node.playerDrain[i][0] = 0
-			node.playerDrain[i][1] = 0
-			node.playerDrain[i][2] = 0
-		}
-		node.hasSomePlayerControl = false
-	}
-
-	if len(td.playerThinkData) != numPlayers {
-		td.playerThinkData = make([]playerThinkData, numPlayers)
-	}
-	for i := 0; i < numPlayers; i++ {
-		td.playerThinkData[i].drain[0] = 0
-		td.playerThinkData[i].drain[1] = 0
-		td.playerThinkData[i].drain[2] = 0
-	}
-}
-
 func (ms *ManaSource) getMaxDrainRate(distSquared float64) float64 {
 	maxDistSquared := ms.options.MaxDrainDistance * ms.options.MaxDrainDistance
 	if distSquared > maxDistSquared {
@@ -404,139 +552,130 @@ func (ms *ManaSource) getMaxDrainRate(distSquared float64) float64 {
 	return distRatio * distRatio * ms.options.MaxDrainRate
 }
 
-func (ms *ManaSource) getPlayerRanges(td *thinkData, players []*PlayerEnt) {
-	i := -1
-	for _, player := range players {
-		i++
-		playerThinkData := &td.playerThinkData[i]
-
-		playerThinkData.minX = -1
-		playerThinkData.minY = -1
-		playerThinkData.maxX = -1
-		playerThinkData.maxY = -1
-
-		playerThinkData.rateFactor = player.Stats().MaxRate()
-
-		for x := range ms.nodes {
-			dx := ms.nodes[x][0].X - player.Pos().X
-			if dx >= -ms.options.MaxDrainDistance && playerThinkData.minX == -1 {
-				playerThinkData.minX = x
-			}
-			if dx <= ms.options.MaxDrainDistance {
-				playerThinkData.maxX = x
-			} else {
-				break
-			}
-		}
-
-		for y := range ms.nodes[0] {
-			dy := ms.nodes[0][y].Y - player.Pos().Y
-			if dy >= -ms.options.MaxDrainDistance && playerThinkData.minY == -1 {
-				playerThinkData.minY = y
-			}
-			if dy <= ms.options.MaxDrainDistance {
-				playerThinkData.maxY = y
-			} else {
-				break
-			}
-		}
+// nodeRange returns the indices of the first and last nodes along one axis
+// that are within dist of pos, where the nodes are evenly spaced from low to
+// high.  If there aren't any then last is less than first.
+func nodeRange(pos, dist, low, high float64, count int) (first, last int) {
+	step := (high - low) / float64(count-1)
+	coord := func(i int) float64 {
+		return low + float64(i)/float64(count-1)*(high-low)
 	}
+	first = int(math.Ceil((pos - dist - low) / step))
+	if first < 0 {
+		first = 0
+	}
+	last = int(math.Floor((pos + dist - low) / step))
+	if last > count-1 {
+		last = count - 1
+	}
+	// Fix up anything that was off by one from floating point error, using the
+	// same positions that the nodes have.
+	for first > 0 && coord(first-1)-pos >= -dist {
+		first--
+	}
+	for first < count && coord(first)-pos < -dist {
+		first++
+	}
+	for last < count-1 && coord(last+1)-pos <= dist {
+		last++
+	}
+	for last >= 0 && coord(last)-pos > dist {
+		last--
+	}
+	return
 }
 
-func (ms *ManaSource) setPlayerControl(td *thinkData, players []*PlayerEnt) {
+// findContributions finds every node that each drainer can reach and how
+// much control it has over each one.
+func (ms *ManaSource) findContributions(td *manaThinkData) {
 	maxDistSquared := ms.options.MaxDrainDistance * ms.options.MaxDrainDistance
-	i := -1
-	for _, player := range players {
-		i++
-		playerThinkData := &td.playerThinkData[i]
-		if !playerThinkData.isValid() {
+	td.contributions = td.contributions[0:0]
+	for i := range td.drainers {
+		data := &td.drainers[i]
+		data.start = len(td.contributions)
+		data.end = data.start
+		pos := data.drainer.Pos()
+		minX, maxX := nodeRange(pos.X, ms.options.MaxDrainDistance, ms.options.BoardLeft, ms.options.BoardRight, ms.options.NumNodeCols)
+		minY, maxY := nodeRange(pos.Y, ms.options.MaxDrainDistance, ms.options.BoardTop, ms.options.BoardBottom, ms.options.NumNodeRows)
+		data.valid = minX <= maxX && minY <= maxY
+		if !data.valid {
 			continue
 		}
-		for x := playerThinkData.minX; x <= playerThinkData.maxX; x++ {
-			for y := playerThinkData.minY; y <= playerThinkData.maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			for y := minY; y <= maxY; y++ {
 				node := &ms.nodes[x][y]
-				nodeThinkData := &td.nodeThinkData[x][y]
-				distSquared := player.Pos().Sub(linear.MakeVec2(node.X, node.Y)).Mag2()
-				if distSquared <= maxDistSquared {
-					nodeThinkData.playerDistSquared[i] = distSquared
-					nodeThinkData.playerControl[i] = 1.0 / (distSquared + 1.0)
-					nodeThinkData.hasSomePlayerControl = true
+				distSquared := pos.Sub(linear.MakeVec2(node.X, node.Y)).Mag2()
+				if distSquared > maxDistSquared {
+					continue
 				}
+				index := x*ms.options.NumNodeRows + y
+				control := 1.0 / (distSquared + 1.0)
+				if td.totalControl[index] == 0 {
+					td.touched = append(td.touched, index)
+				}
+				td.totalControl[index] += control
+				td.contributions = append(td.contributions, manaContribution{
+					node:        index,
+					distSquared: distSquared,
+					control:     control,
+				})
 			}
 		}
-	}
-
-	for x := range ms.nodes {
-		for y := range ms.nodes[x] {
-			nodeThinkData := &td.nodeThinkData[x][y]
-			if nodeThinkData.hasSomePlayerControl {
-				normalizeWeights(1.0, nodeThinkData.playerControl)
-			}
-		}
+		data.end = len(td.contributions)
 	}
 }
 
-func (ms *ManaSource) setPlayerDrain(td *thinkData) {
-	i := -1
-	for _ = range td.playerThinkData {
-		i++
-		playerThinkData := &td.playerThinkData[i]
-		if !playerThinkData.isValid() {
+// findDrains splits the mana in each node between the drainers that can reach
+// it, according to how much control each one has over it.
+func (ms *ManaSource) findDrains(td *manaThinkData) {
+	for i := range td.drainers {
+		data := &td.drainers[i]
+		data.drain = Mana{}
+		if !data.valid {
 			continue
 		}
-		for x := playerThinkData.minX; x <= playerThinkData.maxX; x++ {
-			for y := playerThinkData.minY; y <= playerThinkData.maxY; y++ {
-				node := &ms.nodes[x][y]
-				nodeThinkData := td.nodeThinkData[x][y]
-				control := nodeThinkData.playerControl[i]
-				if control > 0 {
-					maxDrainRate := ms.getMaxDrainRate(nodeThinkData.playerDistSquared[i])
-					for c := range node.Mana {
-						amountScale := node.MaxMana[c] / float64(ms.options.NodeMagnitude)
-						if amountScale != amountScale {
-							panic("amount")
-						}
-						nodeThinkData.playerDrain[i][c] =
-							math.Min(amountScale*maxDrainRate*playerThinkData.rateFactor, node.Mana[c]) * control
-						if nodeThinkData.playerDrain[i][c] != nodeThinkData.playerDrain[i][c] {
-							panic("nodeThinkData.playerDrain[i][c]")
-						}
-						playerThinkData.drain[c] += nodeThinkData.playerDrain[i][c]
-					}
-				}
+		rateFactor := data.drainer.Stats().MaxRate()
+		for j := data.start; j < data.end; j++ {
+			contribution := &td.contributions[j]
+			node := &ms.rawNodes[contribution.node]
+			control := contribution.control * (1.0 / td.totalControl[contribution.node])
+			maxDrainRate := ms.getMaxDrainRate(contribution.distSquared)
+			for c := range node.Mana {
+				amountScale := node.MaxMana[c] / float64(ms.options.NodeMagnitude)
+				contribution.drain[c] = math.Min(amountScale*maxDrainRate*rateFactor, node.Mana[c]) * control
+				data.drain[c] += contribution.drain[c]
 			}
 		}
 	}
 }
 
-func (ms *ManaSource) supplyPlayers(td *thinkData, players []*PlayerEnt) {
-	i := -1
-	for _, player := range players {
-		i++
-		playerThinkData := &td.playerThinkData[i]
-		if !playerThinkData.isValid() {
+// supplyDrainers gives each drainer the mana that it is draining and takes
+// whatever it actually used out of the nodes.
+func (ms *ManaSource) supplyDrainers(td *manaThinkData) {
+	for i := range td.drainers {
+		data := &td.drainers[i]
+		if !data.valid {
 			continue
 		}
-		drainUsed := player.Supply(playerThinkData.drain)
-		for x := playerThinkData.minX; x <= playerThinkData.maxX; x++ {
-			for y := playerThinkData.minY; y <= playerThinkData.maxY; y++ {
-				node := &ms.nodes[x][y]
-				nodeThinkData := td.nodeThinkData[x][y]
-				if nodeThinkData.playerControl[i] > 0 {
-					for c := range node.Mana {
-						if playerThinkData.drain[c] > 0 {
-							usedFrac := 1.0 - drainUsed[c]/playerThinkData.drain[c]
-							node.Mana[c] = math.Max(0.0, node.Mana[c]-nodeThinkData.playerDrain[i][c]*usedFrac)
-						}
-					}
+		drainUsed := data.drainer.Supply(data.drain)
+		var usedFrac Mana
+		for c := range data.drain {
+			if data.drain[c] > 0 {
+				usedFrac[c] = 1.0 - drainUsed[c]/data.drain[c]
+			}
+		}
+		for j := data.start; j < data.end; j++ {
+			contribution := &td.contributions[j]
+			node := &ms.rawNodes[contribution.node]
+			for c := range node.Mana {
+				if data.drain[c] > 0 {
+					node.Mana[c] = math.Max(0.0, node.Mana[c]-contribution.drain[c]*usedFrac[c])
 				}
 			}
 		}
 	}
 }
 
-var globalThinkData thinkData
-
 func (ms *ManaSource) Think(ents map[Gid]Ent) {
 	ms.thinks++
 	// If regenerateMana takes too long we can just do it every other frame and
@@ -545,15 +684,25 @@ func (ms *ManaSource) Think(ents map[Gid]Ent) {
 	if ms.thinks%1 == 0 {
 		ms.regenerateMana()
 	}
-	var players []*PlayerEnt
+
+	td := &ms.thinkData
+	if len(td.totalControl) != len(ms.rawNodes) {
+		td.totalControl = make([]float64, len(ms.rawNodes))
+	}
+	// Drainers are visited in a fixed order so that the floating point math
+	// works out the same on every client.
+	td.drainers = td.drainers[0:0]
 	for _, ent := range ents {
-		if player, ok := ent.(*PlayerEnt); ok {
-			players = append(players, player)
+		if drainer, ok := ent.(ManaDrainer); ok && drainer.DrainsMana() {
+			td.drainers = append(td.drainers, manaDrainerData{drainer: drainer})
 		}
 	}
-	ms.initThinkData(&globalThinkData, len(players))
-	ms.getPlayerRanges(&globalThinkData, players)
-	ms.setPlayerControl(&globalThinkData, players)
-	ms.setPlayerDrain(&globalThinkData)
-	ms.supplyPlayers(&globalThinkData, players)
+	sort.Sort(manaDrainersByGid(td.drainers))
+	ms.findContributions(td)
+	ms.findDrains(td)
+	ms.supplyDrainers(td)
+	for _, index := range td.touched {
+		td.totalControl[index] = 0
+	}
+	td.touched = td.touched[0:0]
 }
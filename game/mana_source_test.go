@@ -0,0 +1,46 @@
+package game
+
+import (
+	"fmt"
+	"github.com/runningwild/cmwc"
+	"github.com/runningwild/linear"
+	"github.com/runningwild/magnus/stats"
+	"math/rand"
+	"testing"
+)
+
+// benchmarkManaSourceThink measures a single frame of a 1024x1024 room's mana
+// source with n drainers spread out over the room.
+func benchmarkManaSourceThink(b *testing.B, n int) {
+	rng := cmwc.MakeGoodCmwc()
+	rng.Seed(123)
+	room := Room{Dx: 1024, Dy: 1024}
+	options := manaSourceOptions(&room, rng)
+	var ms ManaSource
+	ms.Init(&options)
+
+	r := rand.New(rng)
+	ents := make(map[Gid]Ent)
+	for i := 0; i < n; i++ {
+		p := &PlayerEnt{}
+		p.StatsInst = stats.Make(stats.Base{
+			Health: 1000,
+			Mass:   750,
+			Rate:   0.5,
+			Size:   12,
+		})
+		p.Position = linear.Vec2{r.Float64() * 1024, r.Float64() * 1024}
+		p.Gid = Gid(fmt.Sprintf("Engine:%d", i))
+		p.Processes = make(map[int]Process)
+		ents[p.Gid] = p
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ms.Think(ents)
+	}
+}
+
+func BenchmarkManaSourceThink2(b *testing.B)  { benchmarkManaSourceThink(b, 2) }
+func BenchmarkManaSourceThink10(b *testing.B) { benchmarkManaSourceThink(b, 10) }
+func BenchmarkManaSourceThink50(b *testing.B) { benchmarkManaSourceThink(b, 50) }
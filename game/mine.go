@@ -1,6 +1,7 @@
 package game
 
 import (
+	"encoding/gob"
 	gl "github.com/chsc/gogl/gl21"
 	"github.com/runningwild/linear"
 	"github.com/runningwild/magnus/base"
@@ -11,14 +12,20 @@ import (
 // Moba base ent
 type Mine struct {
 	BaseEnt
-	Damage  float64
-	Trigger float64
+	Damage   float64
+	Trigger  float64
+	Exploded bool
 }
 
-func (g *Game) MakeMine(pos, vel linear.Vec2, health, mass, damage, trigger float64) {
+func init() {
+	gob.Register(&Mine{})
+}
+
+func (g *Game) MakeMine(owner Gid, side int, pos, vel linear.Vec2, health, mass, damage, trigger float64) {
 	mine := Mine{
 		BaseEnt: BaseEnt{
-			Side_:        10,
+			Side_:        side,
+			Owner_:       owner,
 			CurrentLevel: GidInvadersStart,
 			Position:     pos,
 			Velocity:     vel,
@@ -38,7 +45,8 @@ func (m *Mine) Think(g *Game) {
 	m.BaseEnt.Think(g)
 	prox := 50.0
 	for _, ent := range g.temp.AllEnts {
-		if ent == m {
+		// Mines aren't set off by anything on their own team.
+		if ent == m || ent.Side() == m.Side() {
 			continue
 		}
 		if ent.Pos().Sub(m.Position).Mag2() < prox*prox {
@@ -47,13 +55,21 @@ func (m *Mine) Think(g *Game) {
 	}
 	if m.Trigger <= 0 {
 		for _, ent := range g.temp.AllEnts {
+			if ent == m {
+				continue
+			}
 			if ent.Pos().Sub(m.Position).Mag() < prox {
-				ent.Stats().ApplyDamage(stats.Damage{stats.DamageFire, m.Damage})
+				g.DealDamage(m.Side(), ent, DamageSourceMine, stats.Damage{stats.DamageFire, m.Damage})
 			}
 		}
+		m.Exploded = true
 	}
 }
 
+func (m *Mine) Dead() bool {
+	return m.Exploded || m.BaseEnt.Dead()
+}
+
 func (m *Mine) Draw(g *Game, side int) {
 	base.EnableShader("status_bar")
 	base.SetUniformF("status_bar", "inner", 0.01)
@@ -78,6 +94,9 @@ func (m *Mine) Draw(g *Game, side int) {
 	base.EnableShader("")
 }
 func (m *Mine) Supply(mana Mana) Mana { return Mana{} }
+
+// Mines are heavy and shouldn't roll around much after being bumped.
+func (m *Mine) Restitution() float64 { return 0.1 }
 func (m *Mine) Walls() [][]linear.Vec2 {
 	return nil
 }
@@ -1,11 +1,13 @@
 package game
 
 import (
+	"encoding/gob"
 	gl "github.com/chsc/gogl/gl21"
 	"github.com/runningwild/linear"
 	"github.com/runningwild/magnus/base"
 	"github.com/runningwild/magnus/stats"
 	"github.com/runningwild/magnus/texture"
+	"math"
 )
 
 type ControlPoint struct {
@@ -28,6 +30,10 @@ type ControlPoint struct {
 	AttackTimer int
 }
 
+func init() {
+	gob.Register(&ControlPoint{})
+}
+
 func (g *Game) MakeControlPoints() {
 	data := g.Levels[GidInvadersStart].Room.Moba.SideData
 	neutralData := data[len(data)-1]
@@ -38,11 +44,12 @@ func (g *Game) MakeControlPoints() {
 				CurrentLevel: GidInvadersStart,
 				Position:     towerPos,
 				StatsInst: stats.Make(stats.Base{
-					Health: 100000,
-					Mass:   1000000,
-					Rate:   1,
-					Size:   50,
-					Vision: 900,
+					Health:    100000,
+					Mass:      1000000,
+					Rate:      1,
+					Size:      50,
+					Vision:    900,
+					Detection: 300,
 				}),
 			},
 		}
@@ -124,12 +131,15 @@ func (cp *ControlPoint) Think(g *Game) {
 			if _, ok := ent.(*PlayerEnt); !ok || ent.Side() == cp.Side() {
 				continue
 			}
+			if !g.IsVisibleTo(ent, cp.Side()) {
+				continue
+			}
 			x := int(ent.Pos().X+0.5) / LosGridSize
 			y := int(ent.Pos().Y+0.5) / LosGridSize
-			res := g.Moba.losCache.Get(int(cp.Position.X), int(cp.Position.Y), cp.Stats().Vision())
+			res := g.losTo(cp.Id(), cp.Position, cp.Stats().Vision())
 			hit := false
 			for _, v := range res {
-				if v.X == x && v.Y == y {
+				if int(v.X) == x && int(v.Y) == y {
 					hit = true
 					break
 				}
@@ -144,6 +154,7 @@ func (cp *ControlPoint) Think(g *Game) {
 					FireTime:    60,
 					ProjPos:     cp.Position,
 					ProjSpeed:   8.0,
+					Owner:       cp.Id(),
 					BlastRadius: 50,
 				})
 			}
@@ -197,7 +208,9 @@ func (cp *ControlPoint) Draw(g *Game, side int) {
 		side == cp.Controller)
 	base.EnableShader("")
 }
-func (cp *ControlPoint) Supply(mana Mana) Mana { return Mana{} }
+func (cp *ControlPoint) Supply(mana Mana) Mana    { return Mana{} }
+func (cp *ControlPoint) Immovable() bool          { return true }
+func (cp *ControlPoint) ApplyForce(f linear.Vec2) {}
 func (cp *ControlPoint) Walls() [][]linear.Vec2 {
 	return nil
 }
@@ -212,11 +225,16 @@ type controlPointAttackProcess struct {
 	FireTime  int
 	ProjPos   linear.Vec2
 	ProjSpeed float64
+	Owner     Gid
 
 	BlastRadius float64
 	Killed      bool
 }
 
+func init() {
+	gob.Register(&controlPointAttackProcess{})
+}
+
 func (cpap *controlPointAttackProcess) Supply(supply Mana) Mana {
 	return supply
 }
@@ -232,23 +250,28 @@ func (cpap *controlPointAttackProcess) Think(g *Game) {
 	}
 	if cpap.Timer >= cpap.FireTime {
 		dir := cpap.LockPos.Sub(cpap.ProjPos)
-		max := dir.Mag()
-		hit := false
-		if max < cpap.ProjSpeed {
-			cpap.ProjPos = cpap.LockPos
-			hit = true
-		} else {
-			cpap.ProjPos = cpap.ProjPos.Add(dir.Norm().Scale(cpap.ProjSpeed))
-		}
-		if hit {
-			for _, ent := range g.temp.AllEnts {
-				if ent.Pos().Sub(cpap.ProjPos).Mag() < cpap.BlastRadius {
-					ent.Stats().ApplyDamage(stats.Damage{stats.DamageFire, 100})
-				}
-			}
-			cpap.Killed = true
+		var vel linear.Vec2
+		if dir.Mag2() > 0 {
+			vel = dir.Norm().Scale(cpap.ProjSpeed)
 		}
+		g.MakeProjectile(cpap.Owner, cpap.Side, cpap.ProjPos, vel, ProjectileParams{
+			Motion:    ProjectileStraight,
+			Speed:     cpap.ProjSpeed,
+			Size:      5,
+			Timer:     int(math.Ceil(dir.Mag()/cpap.ProjSpeed)) + 1,
+			DieOnWall: true,
+			Aoe:       cpap.BlastRadius,
+			Source:    DamageSourceTower,
+			Damages:   []stats.Damage{{stats.DamageFire, 100}},
+		})
+		cpap.Killed = true
+	}
+}
+func (cpap *controlPointAttackProcess) Location() (int, []linear.Vec2) {
+	if cpap.Timer >= cpap.LockTime {
+		return cpap.Side, []linear.Vec2{cpap.ProjPos, cpap.LockPos}
 	}
+	return cpap.Side, []linear.Vec2{cpap.ProjPos}
 }
 func (cpap *controlPointAttackProcess) Kill(g *Game) {
 	cpap.Killed = true
@@ -282,15 +305,5 @@ func (cpap *controlPointAttackProcess) Draw(id Gid, g *Game, side int) {
 			2*50,
 			2*50)
 	}
-
-	// This draws the projectile itself.
-	if cpap.Timer >= cpap.FireTime {
-		gl.Color4ub(255, 50, 50, 240)
-		texture.Render(
-			cpap.ProjPos.X-5,
-			cpap.ProjPos.Y-5,
-			2*5,
-			2*5)
-	}
 	base.EnableShader("")
 }
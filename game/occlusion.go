@@ -0,0 +1,167 @@
+package game
+
+import (
+	"github.com/runningwild/linear"
+	"github.com/runningwild/magnus/los"
+	"math"
+)
+
+// An Occlusion is a shape that blocks line of sight, a circle around Center
+// unless Poly is set.
+type Occlusion struct {
+	Center linear.Vec2
+	Radius float64
+	Poly   linear.Poly
+
+	// If Conceals is set then anything inside of the shape is hidden as well as
+	// anything behind it, and anything inside of it can't see out.  A cloud of
+	// smoke conceals, a building doesn't.
+	Conceals bool
+}
+
+// An Occluder is an Ent that blocks line of sight.  The los cache only knows
+// about walls, occluders are applied on top of whatever it returns so they can
+// come and go and move around without invalidating it.
+type Occluder interface {
+	// Occlusion returns the shape that the ent is currently blocking, or false
+	// if it isn't blocking anything right now.
+	Occlusion() (Occlusion, bool)
+}
+
+// reach returns a circle that contains the entire occlusion.
+func (o Occlusion) reach() (linear.Vec2, float64) {
+	if len(o.Poly) == 0 {
+		return o.Center, o.Radius
+	}
+	var center linear.Vec2
+	for _, v := range o.Poly {
+		center = center.Add(v)
+	}
+	center = center.Scale(1 / float64(len(o.Poly)))
+	radius := 0.0
+	for _, v := range o.Poly {
+		radius = math.Max(radius, v.Sub(center).Mag())
+	}
+	return center, radius
+}
+
+func (o Occlusion) contains(v linear.Vec2) bool {
+	if len(o.Poly) == 0 {
+		return v.Sub(o.Center).Mag2() <= o.Radius*o.Radius
+	}
+	return los.PolyContains(o.Poly, v)
+}
+
+func (o Occlusion) crosses(seg linear.Seg2) bool {
+	if len(o.Poly) == 0 {
+		return distSquaredToSeg(o.Center, seg) <= o.Radius*o.Radius
+	}
+	for i := range o.Poly {
+		if seg.DoesIsect(o.Poly.Seg(i)) {
+			return true
+		}
+	}
+	return false
+}
+
+// blocks returns true if this occlusion keeps something at from from seeing
+// to.  Something that doesn't conceal can be seen, and can see out, but can't
+// be seen through.
+func (o Occlusion) blocks(from, to linear.Vec2) bool {
+	if o.contains(to) || o.contains(from) {
+		return o.Conceals
+	}
+	return o.crosses(linear.Seg2{from, to})
+}
+
+// occluder is an Occlusion along with the ent that it belongs to and a circle
+// that contains it, for quickly skipping the ones that are out of range.
+type occluder struct {
+	gid    Gid
+	occ    Occlusion
+	center linear.Vec2
+	radius float64
+}
+
+// updateOccluders finds every occluder in the game and returns true if they
+// have changed since the last time this was called.
+func (g *Game) updateOccluders() bool {
+	var occluders []occluder
+	g.DoForEnts(func(gid Gid, ent Ent) {
+		o, ok := ent.(Occluder)
+		if !ok || ent.Level() != GidInvadersStart {
+			return
+		}
+		occ, ok := o.Occlusion()
+		if !ok {
+			return
+		}
+		center, radius := occ.reach()
+		occluders = append(occluders, occluder{gid, occ, center, radius})
+	})
+	changed := len(occluders) != len(g.temp.Occluders)
+	for i := 0; !changed && i < len(occluders); i++ {
+		changed = !occluders[i].equals(g.temp.Occluders[i])
+	}
+	g.temp.Occluders = occluders
+	return changed
+}
+
+func (a occluder) equals(b occluder) bool {
+	if a.gid != b.gid || a.center != b.center || a.radius != b.radius || a.occ.Conceals != b.occ.Conceals || len(a.occ.Poly) != len(b.occ.Poly) {
+		return false
+	}
+	for i := range a.occ.Poly {
+		if a.occ.Poly[i] != b.occ.Poly[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// occlude removes everything in vps, which is what viewer can see from pos
+// according to the los cache, that is blocked by an occluder.  vps is not
+// modified.
+func (g *Game) occlude(viewer Gid, pos linear.Vec2, vps []visiblePos) []visiblePos {
+	var near []Occlusion
+	if len(vps) > 0 {
+		// Distances are measured from the corner of the viewer's cell, so add a
+		// little slack.
+		maxDist := float64(vps[len(vps)-1].Dist) + 2*LosGridSize
+		for _, o := range g.temp.Occluders {
+			if o.gid == viewer {
+				continue
+			}
+			if o.center.Sub(pos).Mag() <= maxDist+o.radius {
+				near = append(near, o.occ)
+			}
+		}
+	}
+	if len(near) == 0 {
+		return vps
+	}
+	own := losCacheViewerPos{int(pos.X / LosGridSize), int(pos.Y / LosGridSize)}
+	ret := make([]visiblePos, 0, len(vps))
+	for _, vp := range vps {
+		blocked := false
+		if int(vp.X) != own.X || int(vp.Y) != own.Y {
+			center := cellCenter(int(vp.X), int(vp.Y))
+			for _, occ := range near {
+				if occ.blocks(pos, center) {
+					blocked = true
+					break
+				}
+			}
+		}
+		if !blocked {
+			ret = append(ret, vp)
+		}
+	}
+	return ret
+}
+
+// losTo returns everything within maxDist that viewer can see from pos,
+// taking both walls and occluders into account.
+func (g *Game) losTo(viewer Gid, pos linear.Vec2, maxDist float64) []visiblePos {
+	return g.occlude(viewer, pos, g.Moba.losCache.Get(int(pos.X), int(pos.Y), maxDist))
+}
@@ -50,7 +50,7 @@ func (p *Pest) OnDeath(g *Game) {
 	g.DoForEnts(func(gid Gid, ent Ent) {
 		d := ent.Pos().Sub(p.Pos()).Mag2()
 		if d < 100*100 {
-			ent.Stats().ApplyDamage(stats.Damage{stats.DamageFire, 100})
+			g.DealDamage(p.Side(), ent, DamageSourceAbility, stats.Damage{stats.DamageFire, 100})
 			// var s Sludge = 400
 			// ent.Stats().ApplyCondition(&s)
 		}
@@ -75,7 +75,7 @@ func (p *Pest) Think(g *Game) {
 		return
 	}
 	if dist < 50*50 {
-		p.Stats().ApplyDamage(stats.Damage{stats.DamageFire, 1})
+		g.DealDamage(target.Side(), p, DamageSourceEnvironment, stats.Damage{stats.DamageFire, 1})
 	}
 	dir := target.Pos().Sub(p.Pos()).Norm().Scale(1.0)
 	p.ApplyForce(dir.Scale(10.0))
@@ -0,0 +1,356 @@
+package game
+
+import (
+	"encoding/gob"
+	gl "github.com/chsc/gogl/gl21"
+	"github.com/runningwild/linear"
+	"github.com/runningwild/magnus/base"
+	"github.com/runningwild/magnus/stats"
+	"github.com/runningwild/magnus/texture"
+	"math"
+)
+
+type ProjectileMotion int
+
+const (
+	// Flies in a straight line in the direction of its initial velocity.
+	ProjectileStraight ProjectileMotion = iota
+
+	// Turns towards Target by at most TurnRate radians every frame.
+	ProjectileHoming
+
+	// Lobbed at Dest, it flies over walls and ents and only affects anything
+	// when it lands.
+	ProjectileArcing
+)
+
+type ProjectileParams struct {
+	Motion ProjectileMotion
+
+	// Distance travelled every frame.
+	Speed float64
+
+	// Radius used when checking for hits against ents.
+	Size float64
+
+	// Homing projectiles chase Target.  A TurnRate of zero means that it turns
+	// instantly.
+	Target   Gid
+	TurnRate float64
+
+	// Arcing projectiles land at Dest and peak at Height halfway there.
+	Dest   linear.Vec2
+	Height float64
+
+	// Number of frames until the projectile expires.
+	Timer int
+
+	// If true the projectile passes through every ent it hits, affecting each
+	// one once, otherwise it stops at the first one.
+	Pierce bool
+
+	// Whether or not hitting a wall will kill it.  If not it stops at the wall
+	// until it expires or, if it is homing, turns away from it.
+	DieOnWall bool
+
+	// If true the projectile only affects anything if it hits its Target, it
+	// won't do anything when it expires, hits a wall, or hits any other ent.
+	EffectOnlyOnTarget bool
+
+	// If Aoe is positive everything within Aoe of the impact is affected,
+	// otherwise only the ent that was hit.  Ents that the friendly fire policy
+	// protects from Source are never affected.  If AoePlayersOnly is set the
+	// Aoe skips everything that isn't a player, like towers, mines and wards.
+	Aoe             float64
+	AoePlayersOnly  bool
+	Source          DamageSource
+	Damages         []stats.Damage
+	ConditionMakers []ConditionMaker
+}
+
+// A Projectile is anything that is fired, flies and hits things, like heat
+// seekers and control point shots, which should all be made with
+// MakeProjectile.  Fire, pull and mines don't use it since nothing of theirs
+// flies: fire's explosions appear where they land, pull pushes everything in
+// a cone, and mines are ents that sit where they stop until they're set off.
+type Projectile struct {
+	BaseEnt
+	NonManaUser
+	ProjectileParams
+
+	// Where an arcing projectile was launched from, and how long it has been in
+	// the air.
+	Start  linear.Vec2
+	Frames int
+
+	// Ents already hit by a piercing projectile.
+	Hit map[Gid]bool
+
+	Done bool
+}
+
+func init() {
+	gob.Register(&Projectile{})
+}
+
+// MakeProjectile fires a projectile from pos on behalf of owner, which it will
+// never hit.  vel is only used by straight projectiles, the others compute
+// their own heading.
+func (g *Game) MakeProjectile(owner Gid, side int, pos, vel linear.Vec2, params ProjectileParams) *Projectile {
+	p := Projectile{
+		BaseEnt: BaseEnt{
+			Side_:        side,
+			Owner_:       owner,
+			CurrentLevel: GidInvadersStart,
+			Position:     pos,
+			Velocity:     vel,
+		},
+		ProjectileParams: params,
+		Start:            pos,
+		Hit:              make(map[Gid]bool),
+	}
+	p.StatsInst = stats.Make(stats.Base{
+		Health: 1,
+		Mass:   1,
+	})
+	if ownerEnt, ok := g.Ents[owner]; ok {
+		p.CurrentLevel = ownerEnt.Level()
+	}
+	if p.Motion == ProjectileArcing && p.Speed > 0 {
+		p.Timer = int(math.Ceil(p.Dest.Sub(pos).Mag() / p.Speed))
+	}
+	g.AddEnt(&p)
+	return &p
+}
+
+func (p *Projectile) Dead() bool {
+	return p.Done || p.BaseEnt.Dead()
+}
+
+func (p *Projectile) Think(g *Game) {
+	// Projectiles don't use BaseEnt.Think since they don't slide along walls
+	// and aren't slowed by friction.
+	p.StatsInst.Think()
+	if p.Done {
+		return
+	}
+	p.Frames++
+	if p.Motion == ProjectileArcing {
+		p.thinkArcing(g)
+		return
+	}
+
+	if p.Motion == ProjectileHoming {
+		target, ok := g.Ents[p.Target]
+		if !ok {
+			p.expire(g)
+			return
+		}
+		p.Velocity = p.steer(target.Pos().Sub(p.Position))
+	}
+	if p.Velocity.Mag2() > 0 {
+		p.Angle = p.Velocity.Angle()
+	}
+
+	move := linear.Seg2{p.Position, p.Position.Add(p.Velocity)}
+	hitWall := false
+	if wc, ok := g.temp.WallCache[p.CurrentLevel]; ok {
+		for _, wall := range wc.GetWallsAlong(move) {
+			if !move.DoesIsect(wall) {
+				continue
+			}
+			hitWall = true
+			isect := move.Isect(wall)
+			// Back off a little so that the projectile stays on this side of the
+			// wall.
+			if ray := isect.Sub(move.P); ray.Mag2() > 1 {
+				move.Q = isect.Sub(ray.Norm())
+			} else {
+				move.Q = move.P
+			}
+		}
+	}
+
+	if p.checkEnts(g, move) {
+		return
+	}
+	p.Position = move.Q
+	if hitWall {
+		if p.DieOnWall {
+			p.expire(g)
+			return
+		}
+		p.Velocity = linear.Vec2{}
+	}
+
+	p.Timer--
+	if p.Timer <= 0 {
+		p.expire(g)
+	}
+}
+
+func (p *Projectile) thinkArcing(g *Game) {
+	if p.Frames >= p.Timer {
+		p.Position = p.Dest
+		p.detonate(g, nil)
+		return
+	}
+	frac := float64(p.Frames) / float64(p.Timer)
+	p.Position = p.Start.Add(p.Dest.Sub(p.Start).Scale(frac))
+}
+
+// steer turns the projectile's velocity towards dir by at most TurnRate.
+func (p *Projectile) steer(dir linear.Vec2) linear.Vec2 {
+	if dir.Mag2() == 0 {
+		return p.Velocity
+	}
+	if p.TurnRate <= 0 || p.Velocity.Mag2() == 0 {
+		return dir.Norm().Scale(p.Speed)
+	}
+	angle := dir.Angle() - p.Velocity.Angle()
+	for angle > math.Pi {
+		angle -= 2 * math.Pi
+	}
+	for angle < -math.Pi {
+		angle += 2 * math.Pi
+	}
+	if angle > p.TurnRate {
+		angle = p.TurnRate
+	}
+	if angle < -p.TurnRate {
+		angle = -p.TurnRate
+	}
+	return p.Velocity.Norm().Rotate(angle).Scale(p.Speed)
+}
+
+// checkEnts checks for any ents along move and returns true if the projectile
+// stopped on one of them.
+func (p *Projectile) checkEnts(g *Game, move linear.Seg2) bool {
+	for _, ent := range g.temp.AllEnts {
+		if ent == p || ent.Id() == p.Owner() || p.Hit[ent.Id()] {
+			continue
+		}
+		if _, ok := ent.(*Projectile); ok {
+			continue
+		}
+		if p.EffectOnlyOnTarget && ent.Id() != p.Target {
+			continue
+		}
+		// Projectiles fly past anything they aren't allowed to hurt.
+		if !g.CanDamage(p.Side(), ent, p.Source) {
+			continue
+		}
+		dist := p.Size + ent.Stats().Size()
+		if distSquaredToSeg(ent.Pos(), move) > dist*dist {
+			continue
+		}
+		if p.Pierce {
+			p.Hit[ent.Id()] = true
+			p.affect(g, ent.Pos(), ent)
+			continue
+		}
+		p.Position = ent.Pos()
+		p.detonate(g, ent)
+		return true
+	}
+	return false
+}
+
+// expire is called when a projectile runs out of time, loses its target, or
+// dies on a wall.
+func (p *Projectile) expire(g *Game) {
+	if p.EffectOnlyOnTarget {
+		p.Done = true
+		return
+	}
+	p.detonate(g, nil)
+}
+
+func (p *Projectile) detonate(g *Game, hit Ent) {
+	p.affect(g, p.Position, hit)
+	p.Done = true
+}
+
+// affect applies damage and conditions to hit, or to everything within Aoe of
+// pos if this projectile has an Aoe.
+func (p *Projectile) affect(g *Game, pos linear.Vec2, hit Ent) {
+	if p.Aoe <= 0 {
+		if hit != nil {
+			p.affectEnt(g, hit)
+		}
+		return
+	}
+	for _, ent := range g.temp.AllEnts {
+		if _, ok := ent.(*Projectile); ok {
+			continue
+		}
+		if _, ok := ent.(*PlayerEnt); !ok && p.AoePlayersOnly {
+			continue
+		}
+		if ent.Pos().Sub(pos).Mag2() <= p.Aoe*p.Aoe {
+			p.affectEnt(g, ent)
+		}
+	}
+}
+
+func (p *Projectile) affectEnt(g *Game, ent Ent) {
+	if !g.CanDamage(p.Side(), ent, p.Source) {
+		return
+	}
+	for _, damage := range p.Damages {
+		g.DealDamage(p.Side(), ent, p.Source, damage)
+	}
+	player, ok := ent.(*PlayerEnt)
+	if !ok {
+		return
+	}
+	for _, conditionMaker := range p.ConditionMakers {
+		maker, ok := effect_makers[conditionMaker.Name]
+		if !ok {
+			base.Warn().Printf("Unknown condition '%s'", conditionMaker.Name)
+			continue
+		}
+		player.Processes[g.NextId()] = maker(conditionMaker.Params)
+	}
+}
+
+// distSquaredToSeg returns the squared distance from v to the closest point on
+// seg.
+func distSquaredToSeg(v linear.Vec2, seg linear.Seg2) float64 {
+	ray := seg.Ray()
+	if ray.Mag2() == 0 {
+		return v.Sub(seg.P).Mag2()
+	}
+	t := v.Sub(seg.P).Dot(ray) / ray.Mag2()
+	if t < 0 {
+		t = 0
+	}
+	if t > 1 {
+		t = 1
+	}
+	return v.Sub(seg.P.Add(ray.Scale(t))).Mag2()
+}
+
+func (p *Projectile) Draw(g *Game, side int) {
+	size := p.Size
+	if size < 5 {
+		size = 5
+	}
+	base.EnableShader("circle")
+	base.SetUniformF("circle", "edge", 0.9)
+	if p.Motion == ProjectileArcing && p.Timer > 0 {
+		// Draw a shadow where the projectile is, and the projectile itself above
+		// it so that it looks like it is flying.
+		frac := float64(p.Frames) / float64(p.Timer)
+		height := p.Height * math.Sin(frac*math.Pi)
+		gl.Color4ub(0, 0, 0, 100)
+		texture.Render(p.Position.X-size, p.Position.Y-size, 2*size, 2*size)
+		size *= 1 + height/100
+		gl.Color4ub(255, 50, 50, 240)
+		texture.Render(p.Position.X-size, p.Position.Y+height-size, 2*size, 2*size)
+	} else {
+		gl.Color4ub(255, 50, 50, 240)
+		texture.Render(p.Position.X-size, p.Position.Y-size, 2*size, 2*size)
+	}
+	base.EnableShader("")
+}
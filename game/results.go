@@ -0,0 +1,116 @@
+package game
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Results is how each side is doing, for showing once a match is over.
+type Results struct {
+	Sides []SideResults
+
+	// Sides that control the most control points, empty if nobody controls
+	// any.
+	Winners []int
+}
+
+type SideResults struct {
+	Side          int
+	ControlPoints int
+	Players       []PlayerResults
+}
+
+type PlayerResults struct {
+	Id     int64
+	Name   string
+	Champ  string
+	Ai     bool
+	Deaths int
+}
+
+// Results returns the current results, ordered by side and then by engine id.
+func (g *Game) Results() Results {
+	sides := make(map[int]*SideResults)
+	get := func(side int) *SideResults {
+		if sides[side] == nil {
+			sides[side] = &SideResults{Side: side}
+		}
+		return sides[side]
+	}
+
+	var ids []int64
+	for id := range g.Engines {
+		ids = append(ids, id)
+	}
+	sort.Sort(int64Slice(ids))
+	for _, id := range ids {
+		data := g.Engines[id]
+		player := PlayerResults{
+			Id:     id,
+			Name:   data.Name,
+			Ai:     data.Ai != nil,
+			Deaths: data.Deaths,
+		}
+		if data.Champ >= 0 && data.Champ < len(g.Champs) {
+			player.Champ = g.Champs[data.Champ].Name
+		}
+		side := get(data.Side)
+		side.Players = append(side.Players, player)
+	}
+
+	for _, ent := range g.Ents {
+		if cp, ok := ent.(*ControlPoint); ok && cp.Controlled {
+			get(cp.Controller).ControlPoints++
+		}
+	}
+
+	var results Results
+	var order []int
+	for side := range sides {
+		order = append(order, side)
+	}
+	sort.Ints(order)
+	best := 0
+	for _, side := range order {
+		results.Sides = append(results.Sides, *sides[side])
+		if sides[side].ControlPoints > best {
+			best = sides[side].ControlPoints
+		}
+	}
+	if best > 0 {
+		for _, side := range order {
+			if sides[side].ControlPoints == best {
+				results.Winners = append(results.Winners, side)
+			}
+		}
+	}
+	return results
+}
+
+func (r Results) String() string {
+	var lines []string
+	for _, side := range r.Sides {
+		lines = append(lines, fmt.Sprintf("Side %d: %d control points", side.Side, side.ControlPoints))
+		for _, player := range side.Players {
+			kind := "player"
+			if player.Ai {
+				kind = "ai"
+			}
+			lines = append(lines, fmt.Sprintf("  %s (%s, %s): %d deaths", player.Name, kind, player.Champ, player.Deaths))
+		}
+	}
+	switch len(r.Winners) {
+	case 0:
+		lines = append(lines, "No winner")
+	case 1:
+		lines = append(lines, fmt.Sprintf("Side %d wins", r.Winners[0]))
+	default:
+		var winners []string
+		for _, side := range r.Winners {
+			winners = append(winners, fmt.Sprint(side))
+		}
+		lines = append(lines, fmt.Sprintf("Tie between sides %s", strings.Join(winners, ", ")))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,146 @@
+package game
+
+import (
+	"encoding/json"
+	"github.com/runningwild/magnus/base"
+	"github.com/runningwild/magnus/generator"
+	"path/filepath"
+)
+
+// RoomFile is a room file, see generator.ReadRoomFile for the format.
+type RoomFile struct {
+	Info generator.RoomInfo
+	Room Room
+}
+
+// Supports returns true if the room can be played in mode with sides sides.
+// Whatever the info says, moba rooms need moba side data since that's where
+// the control points go.
+func (f *RoomFile) Supports(mode string, sides int) bool {
+	if mode == "moba" && len(f.Room.Moba.SideData) == 0 {
+		return false
+	}
+	return f.Info.Supports(mode, sides)
+}
+
+// LoadRoomFile loads a room file, including files from before the format had
+// a version.
+func LoadRoomFile(path string) (RoomFile, error) {
+	var file RoomFile
+	info, room, err := generator.ReadRoomFile(path)
+	if err != nil {
+		return file, err
+	}
+	file.Info = info
+	err = json.Unmarshal(room, &file.Room)
+	return file, err
+}
+
+// SaveRoomFile saves a room file at the current version, filling in any info
+// that is missing.
+func SaveRoomFile(path string, file RoomFile) error {
+	return generator.WriteRoomFile(path, file.Info, file.Room)
+}
+
+// A MapPreset is a generator, and the modes and number of sides it can
+// generate rooms for.
+type MapPreset struct {
+	Name     string
+	Modes    []string
+	MaxSides int
+	Generate func(g *generator.Generator, dx, dy, sides int) generator.Room
+}
+
+var MapPresets = []MapPreset{
+	{
+		Name:     "Moba",
+		Modes:    []string{"moba"},
+		MaxSides: 4,
+		Generate: func(g *generator.Generator, dx, dy, sides int) generator.Room {
+			return g.Moba(generator.MobaOptions{Dx: float64(dx), Dy: float64(dy), Sides: sides})
+		},
+	},
+	{
+		Name:     "Mirrored moba",
+		Modes:    []string{"moba"},
+		MaxSides: 4,
+		Generate: func(g *generator.Generator, dx, dy, sides int) generator.Room {
+			return g.Moba(generator.MobaOptions{Dx: float64(dx), Dy: float64(dy), Sides: sides, Mirror: true})
+		},
+	},
+}
+
+// Sizes that the host can choose between for generated maps.
+var MapSizes = []int{768, 1024, 1536, 2048}
+
+// SetupMap is the map chosen during setup.  Rooms from files are sent along
+// with the choice since the other engines might not have the same files.
+type SetupMap struct {
+	Name string
+
+	// Set for a room from a file.
+	Room *Room
+
+	// Set for a generated room, along with its size.
+	Preset string
+	Dx, Dy int
+}
+
+// defaultSetupMap is what is played if the host never chooses a map.
+var defaultSetupMap = SetupMap{Name: "Moba", Preset: "Moba", Dx: 1024, Dy: 1024}
+
+// MapChoices returns every map in data/rooms and every preset that can be
+// played in mode with sides sides.  Generated maps come first.
+func MapChoices(mode string, sides int) []SetupMap {
+	var choices []SetupMap
+	for _, preset := range MapPresets {
+		info := generator.RoomInfo{Modes: preset.Modes, Sides: preset.MaxSides}
+		if info.Supports(mode, sides) {
+			choices = append(choices, SetupMap{Name: preset.Name, Preset: preset.Name, Dx: 1024, Dy: 1024})
+		}
+	}
+	paths, err := filepath.Glob(filepath.Join(base.GetDataDir(), "rooms", "*.json"))
+	if err != nil {
+		base.Error().Printf("Unable to list rooms: %v", err)
+	}
+	for _, path := range paths {
+		file, err := LoadRoomFile(path)
+		if err != nil {
+			base.Warn().Printf("Unable to load %s: %v", path, err)
+			continue
+		}
+		if !file.Supports(mode, sides) {
+			continue
+		}
+		room := file.Room
+		choices = append(choices, SetupMap{Name: file.Info.Name, Room: &room})
+	}
+	return choices
+}
+
+// makeRoom returns the room for m, generating it from seed if it's a preset.
+func (m SetupMap) makeRoom(seed int64, sides int) (Room, bool) {
+	if m.Room != nil {
+		return *m.Room, true
+	}
+	for _, preset := range MapPresets {
+		if preset.Name != m.Preset {
+			continue
+		}
+		generated, _ := generator.GenerateValid(seed, 10, generator.ValidateOptions{}, func(g *generator.Generator) generator.Room {
+			return preset.Generate(g, m.Dx, m.Dy, sides)
+		})
+		var room Room
+		data, err := json.Marshal(generated)
+		if err == nil {
+			err = json.Unmarshal(data, &room)
+		}
+		if err != nil {
+			base.Error().Printf("Unable to convert generated room: %v", err)
+			return room, false
+		}
+		return room, true
+	}
+	base.Error().Printf("No map preset named %q", m.Preset)
+	return Room{}, false
+}
@@ -0,0 +1,37 @@
+package game
+
+import (
+	"github.com/runningwild/linear"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMobaRoomsNeedSideData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "magnus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "room.json")
+	var file RoomFile
+	file.Info.Modes = []string{"moba"}
+	file.Room.Dx, file.Room.Dy = 100, 100
+	file.Room.Starts = []linear.Vec2{{10, 10}, {90, 90}}
+	if err := SaveRoomFile(path, file); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadRoomFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Supports("moba", 2) {
+		t.Errorf("A moba room without side data supports moba")
+	}
+
+	loaded.Room.Moba.SideData = make([]mobaRoomSideData, 3)
+	if !loaded.Supports("moba", 2) {
+		t.Errorf("A moba room with side data doesn't support moba")
+	}
+}
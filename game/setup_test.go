@@ -0,0 +1,16 @@
+package game
+
+import "testing"
+
+func TestSetupCompleteWaitsForEveryone(t *testing.T) {
+	var g Game
+	g.Setup = &Setup{Mode: "moba", Sides: make(map[int64]*SetupSideData)}
+	SetupSetEngineIds{[]int64{1, 2}, 1}.Apply(&g)
+	SetupComplete{1}.Apply(&g)
+	if g.Setup == nil {
+		t.Fatalf("The game started while engine 2 wasn't ready")
+	}
+	if waiting := g.Setup.Waiting(g.Setup.Host); len(waiting) != 1 || waiting[0] != 2 {
+		t.Errorf("Expected to be waiting for only engine 2, got %v", waiting)
+	}
+}
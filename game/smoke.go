@@ -0,0 +1,79 @@
+package game
+
+import (
+	"encoding/gob"
+	gl "github.com/chsc/gogl/gl21"
+	"github.com/runningwild/linear"
+	"github.com/runningwild/magnus/base"
+	"github.com/runningwild/magnus/stats"
+	"github.com/runningwild/magnus/texture"
+)
+
+// A Smoke cloud blocks line of sight through it and hides everything inside of
+// it until it dissipates.  Nothing collides with it and it can't be destroyed.
+type Smoke struct {
+	BaseEnt
+	NonManaUser
+
+	Radius float64
+
+	// Frames left until the smoke dissipates.
+	Timer int
+}
+
+func init() {
+	gob.Register(&Smoke{})
+}
+
+// MakeSmoke places a cloud of smoke with the given radius at pos on behalf of
+// owner.
+func (g *Game) MakeSmoke(owner Gid, side int, pos linear.Vec2, radius float64, frames int) {
+	smoke := Smoke{
+		BaseEnt: BaseEnt{
+			Side_:        side,
+			Owner_:       owner,
+			CurrentLevel: GidInvadersStart,
+			Position:     pos,
+		},
+		Radius: radius,
+		Timer:  frames,
+	}
+	if ownerEnt, ok := g.Ents[owner]; ok {
+		smoke.CurrentLevel = ownerEnt.Level()
+	}
+	smoke.StatsInst = stats.Make(stats.Base{
+		Health: 1,
+		Mass:   1,
+	})
+	g.AddEnt(&smoke)
+}
+
+func (s *Smoke) Think(g *Game) {
+	s.Velocity = linear.Vec2{}
+	if s.Timer > 0 {
+		s.Timer--
+	}
+}
+
+func (s *Smoke) Dead() bool {
+	return s.Timer <= 0
+}
+
+func (s *Smoke) Immovable() bool { return true }
+
+func (s *Smoke) Occlusion() (Occlusion, bool) {
+	return Occlusion{Center: s.Position, Radius: s.Radius, Conceals: true}, s.Timer > 0
+}
+
+func (s *Smoke) Draw(g *Game, side int) {
+	base.EnableShader("circle")
+	base.SetUniformF("circle", "edge", 0.6)
+	alpha := 200
+	// Fade out over the last second.
+	if s.Timer < 60 {
+		alpha = alpha * s.Timer / 60
+	}
+	gl.Color4ub(150, 150, 150, gl.Ubyte(alpha))
+	texture.Render(s.Position.X-s.Radius, s.Position.Y-s.Radius, 2*s.Radius, 2*s.Radius)
+	base.EnableShader("")
+}
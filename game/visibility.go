@@ -0,0 +1,359 @@
+package game
+
+import (
+	"fmt"
+	"github.com/runningwild/linear"
+	"github.com/runningwild/magnus/los"
+)
+
+// Ents with at least this much Cloaking can't be seen by the other sides
+// unless they have been revealed or detected.
+const CloakedThreshold = 0.5
+
+const (
+	// Number of frames that an ent stays revealed after taking damage or using
+	// an ability.
+	RevealOnDamageFrames = 60
+	RevealOnCastFrames   = 90
+)
+
+// visionStamp is what a viewer's los was last computed from, if none of it has
+// changed then neither has what the viewer can see.  Viewers are stamped with
+// the los cache cell that they are in, cached los is computed from the center
+// of the cell so it is the same anywhere inside of it.
+type visionStamp struct {
+	side      int
+	cell      los.Cell
+	vision    float64
+	occluders int
+}
+
+// visibleCells converts los cache results into grid cells.
+func visibleCells(vps []visiblePos) []los.Cell {
+	cells := make([]los.Cell, len(vps))
+	for i, vp := range vps {
+		cells[i] = los.Cell{int(vp.X), int(vp.Y)}
+	}
+	return cells
+}
+
+// shapedCells returns the cells in vps, which are relative to a viewer at pos,
+// for which visible returns true.
+func shapedCells(vps []visiblePos, pos linear.Vec2, visible func(offset linear.Vec2) bool) []los.Cell {
+	var cells []los.Cell
+	for _, vp := range vps {
+		center := linear.Vec2{(float64(vp.X) + 0.5) * LosGridSize, (float64(vp.Y) + 0.5) * LosGridSize}
+		if visible(center.Sub(pos)) {
+			cells = append(cells, los.Cell{int(vp.X), int(vp.Y)})
+		}
+	}
+	return cells
+}
+
+// A VisionShaper is a Process that lets the ent it is on see further than its
+// Vision stat in some directions.
+type VisionShaper interface {
+	// VisionShape returns the furthest that the ent can see and a function that
+	// says whether it can see the point at offset from itself.  Line of sight is
+	// still required.
+	VisionShape(g *Game) (maxDist float64, visible func(offset linear.Vec2) bool)
+}
+
+// sideVisions returns the vision for every side as of the start of this frame.
+func (g *Game) sideVisions() map[int]*los.Grid {
+	if g.temp.Vision == nil {
+		g.updateVision()
+	}
+	return g.temp.Vision
+}
+
+// updateVision brings the vision for every side up to date.  It is called once
+// at the start of every frame so that everything that checks visibility during
+// the frame gets the same answer on every client.  Each ent with any vision is
+// a viewer in its side's grid, along with each of its VisionShapers, but only
+// the viewers that have moved to another cell since the last frame are
+// recomputed.
+func (g *Game) updateVision() {
+	if g.Moba == nil || g.Moba.losCache == nil {
+		g.temp.Vision = make(map[int]*los.Grid)
+		return
+	}
+	lc := g.Moba.losCache
+	if g.temp.Vision == nil {
+		g.temp.Vision = make(map[int]*los.Grid)
+		g.temp.VisionStamps = make(map[string]visionStamp)
+	}
+	for side := range g.Moba.Sides {
+		if _, ok := g.temp.Vision[side]; !ok {
+			g.temp.Vision[side] = los.MakeGrid(lc.dx, lc.dy, LosGridSize)
+		}
+	}
+	if g.updateOccluders() {
+		g.temp.OccludersChanged++
+	}
+	stamps := g.temp.VisionStamps
+	seen := make(map[string]bool)
+	setViewer := func(id string, stamp visionStamp, cells func() []los.Cell) {
+		seen[id] = true
+		old, ok := stamps[id]
+		if ok && old == stamp {
+			return
+		}
+		if ok && old.side != stamp.side {
+			g.temp.Vision[old.side].RemoveViewer(id)
+		}
+		g.temp.Vision[stamp.side].SetViewer(id, cells())
+		stamps[id] = stamp
+	}
+	g.DoForEnts(func(gid Gid, ent Ent) {
+		if _, ok := g.temp.Vision[ent.Side()]; !ok || ent.Level() != GidInvadersStart {
+			return
+		}
+		pos := ent.Pos()
+		cell := los.Cell{int(pos.X) / LosGridSize, int(pos.Y) / LosGridSize}
+		if player, ok := ent.(*PlayerEnt); ok {
+			for pid, proc := range player.Processes {
+				shaper, ok := proc.(VisionShaper)
+				if !ok {
+					continue
+				}
+				maxDist, visible := shaper.VisionShape(g)
+				if maxDist <= 0 {
+					continue
+				}
+				// These depend on more than just the position of the ent, so they are
+				// always recomputed.
+				id := fmt.Sprintf("%s/%d", gid, pid)
+				delete(stamps, id)
+				setViewer(id, visionStamp{ent.Side(), cell, maxDist, g.temp.OccludersChanged}, func() []los.Cell {
+					return shapedCells(g.losTo(gid, pos, maxDist), pos, visible)
+				})
+			}
+		}
+		vision := ent.Stats().Vision()
+		if vision <= 0 {
+			return
+		}
+		setViewer(string(gid), visionStamp{ent.Side(), cell, vision, g.temp.OccludersChanged}, func() []los.Cell {
+			return visibleCells(g.losTo(gid, pos, vision))
+		})
+	})
+	for id, stamp := range stamps {
+		if !seen[id] {
+			g.temp.Vision[stamp.side].RemoveViewer(id)
+			delete(stamps, id)
+		}
+	}
+}
+
+// IsPosVisibleTo returns true if side has line of sight to pos.
+func (g *Game) IsPosVisibleTo(pos linear.Vec2, side int) bool {
+	sv, ok := g.sideVisions()[side]
+	if !ok {
+		// Modes without vision, and observers, can see everything.
+		return true
+	}
+	return sv.PointVisible(pos)
+}
+
+// isBodyVisibleTo returns true if side has line of sight to any part of ent.
+func (g *Game) isBodyVisibleTo(ent Ent, side int) bool {
+	size := linear.Vec2{ent.Stats().Size(), ent.Stats().Size()}
+	visible, _ := g.sideVisions()[side].RegionVisibility(ent.Pos().Sub(size), ent.Pos().Add(size))
+	return visible > 0
+}
+
+// isPolyVisibleTo returns true if side has line of sight to any part of poly.
+func (g *Game) isPolyVisibleTo(poly linear.Poly, side int) bool {
+	sv, ok := g.sideVisions()[side]
+	if !ok {
+		return true
+	}
+	visible, total := sv.PolyVisibility(poly)
+	if total == 0 && len(poly) > 0 {
+		// Too small to contain the center of any cell.
+		return sv.PointVisible(poly[0])
+	}
+	return visible > 0
+}
+
+// IsVisibleTo returns true if side can see ent.  Ents are always visible to
+// their own side, otherwise some part of them must be in line of sight of
+// something on side, and if they are cloaked they must also be revealed or
+// within the detection radius of something on side.
+func (g *Game) IsVisibleTo(ent Ent, side int) bool {
+	if ent.Side() == side {
+		return true
+	}
+	if _, ok := g.sideVisions()[side]; !ok {
+		return true
+	}
+	// Otherwise nobody would be able to see what is blocking their view.
+	if _, ok := ent.(Occluder); ok {
+		return true
+	}
+	if !g.isBodyVisibleTo(ent, side) {
+		return false
+	}
+	if ent.Stats().Cloaking() < CloakedThreshold || ent.Revealed() {
+		return true
+	}
+	return g.isDetectedBy(ent, side)
+}
+
+// isDetectedBy returns true if ent is within the detection radius of anything
+// on side.  It doesn't check line of sight, IsVisibleTo has already done that.
+func (g *Game) isDetectedBy(ent Ent, side int) bool {
+	for _, detector := range g.temp.AllEnts {
+		if detector.Side() != side || detector.Level() != ent.Level() {
+			continue
+		}
+		detection := detector.Stats().Detection()
+		if detection <= 0 {
+			continue
+		}
+		if detector.Pos().Sub(ent.Pos()).Mag2() <= detection*detection {
+			return true
+		}
+	}
+	return false
+}
+
+// RevealNear reveals every ent that isn't on side within radius of pos for
+// frames frames, along with any hidden hazards there that side can see.
+func (g *Game) RevealNear(side int, level Gid, pos linear.Vec2, radius float64, frames int) {
+	for _, ent := range g.temp.AllEnts {
+		if ent.Side() == side || ent.Level() != level {
+			continue
+		}
+		if ent.Pos().Sub(pos).Mag2() <= radius*radius {
+			ent.Reveal(frames)
+		}
+	}
+	g.RevealHazards(side, level, pos, radius)
+}
+
+type SyncMode int
+
+const (
+	// Every client gets the entire game state and runs the whole simulation.
+	SyncFull SyncMode = iota
+
+	// The host runs the simulation and each client is only sent the ents,
+	// processes and hazards that its side can see.
+	SyncVisible
+)
+
+// A LocatedProcess is a process in Game.Processes that happens somewhere, so
+// with SyncVisible it is only sent to sides that can see some part of it.
+// Processes that aren't LocatedProcesses are sent to everyone.
+type LocatedProcess interface {
+	Process
+
+	// Location returns the side that the process belongs to and where it is
+	// happening.
+	Location() (side int, where []linear.Vec2)
+}
+
+// StateForSide returns the game state that should be sent to a client
+// playing on side.  With SyncFull this is just g.  With SyncVisible it is a
+// shallow copy of g without anything that side can't see: ents, processes,
+// hidden hazards, other sides' respawn timers and deaths, mana drained where
+// side can't see, the rng and the architect.  Nothing is hidden during setup.
+// The copy shares ents and everything else that side can see with g, so it
+// must be encoded before g thinks again.
+func (g *Game) StateForSide(side int) *Game {
+	if g.Sync == SyncFull || g.Setup != nil {
+		return g
+	}
+	filtered := &Game{
+		Levels:      make(map[Gid]*Level),
+		Friction:    g.Friction,
+		NextIdValue: g.NextIdValue,
+		Ents:        make(map[Gid]Ent),
+		Engines:     make(map[int64]*PlayerData),
+		GameThinks:  g.GameThinks,
+		Moba:        g.Moba,
+		Champs:      g.Champs,
+		Sync:        g.Sync,
+	}
+	for gid, ent := range g.Ents {
+		if g.IsVisibleTo(ent, side) {
+			filtered.Ents[gid] = ent
+		}
+	}
+	for id, data := range g.Engines {
+		if data.Side != side {
+			hidden := *data
+			hidden.CountdownFrames = 0
+			hidden.Deaths = 0
+			data = &hidden
+		}
+		filtered.Engines[id] = data
+	}
+	for _, proc := range g.Processes {
+		if g.isProcessVisibleTo(proc, side) {
+			filtered.Processes = append(filtered.Processes, proc)
+		}
+	}
+	visible := func(pos linear.Vec2) bool { return g.IsPosVisibleTo(pos, side) }
+	for gid, level := range g.Levels {
+		copied := &Level{
+			ManaSource: level.ManaSource.copyHidden(visible),
+			Room:       level.Room,
+		}
+		copied.Room.Hazards = make(map[string]*Hazard)
+		for name, hazard := range level.Room.Hazards {
+			if hazard.VisibleTo(side) {
+				copied.Room.Hazards[name] = hazard
+			}
+		}
+		filtered.Levels[gid] = copied
+	}
+	if g.Standard != nil {
+		standard := *g.Standard
+		if side != standard.ArchitectSide {
+			standard.Architect = architectData{}
+		}
+		filtered.Standard = &standard
+	}
+	return filtered
+}
+
+// isProcessVisibleTo returns true if side can see any part of proc.
+func (g *Game) isProcessVisibleTo(proc Process, side int) bool {
+	located, ok := proc.(LocatedProcess)
+	if !ok {
+		return true
+	}
+	procSide, where := located.Location()
+	if procSide == side {
+		return true
+	}
+	for _, pos := range where {
+		if g.IsPosVisibleTo(pos, side) {
+			return true
+		}
+	}
+	return false
+}
+
+// PrepareSnapshot gets a state from StateForSide ready to be drawn by a client
+// that doesn't run the simulation itself.  prev is the last state that was
+// prepared, if there was one, its caches are kept if the walls are the same.
+func (g *Game) PrepareSnapshot(prev *Game) {
+	if g.Setup != nil || g.Moba == nil || g.Levels[GidInvadersStart] == nil {
+		return
+	}
+	room := &g.Levels[GidInvadersStart].Room
+	if prev != nil && prev.Moba != nil && prev.Moba.losCache != nil && prev.Moba.losCache.Key() == roomLosCacheKey(room) {
+		g.temp = prev.temp
+		g.Moba.losCache = prev.Moba.losCache
+	} else {
+		g.Moba.losCache = makeLosCache(room.Dx, room.Dy)
+	}
+	g.temp.AllEntsDirty = true
+	g.updateWallCaches()
+	g.updateAllEnts()
+	g.updateVision()
+}
@@ -0,0 +1,129 @@
+package game
+
+import (
+	"github.com/runningwild/cmwc"
+	"github.com/runningwild/linear"
+	"github.com/runningwild/magnus/base"
+	"github.com/runningwild/magnus/stats"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// makeVisibilityGame returns an empty 1024x1024 moba with two sides, and a
+// pillar in the corner so that there are some walls.
+func makeVisibilityGame(t *testing.T) *Game {
+	dir, err := ioutil.TempDir("", "magnus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base.SetDatadir(dir)
+	t.Cleanup(func() {
+		base.CloseLog()
+		os.RemoveAll(dir)
+	})
+	LosCacheSettings = LosCacheOptions{MaxBytes: 1 << 20}
+
+	var g Game
+	g.Sync = SyncVisible
+	g.Levels = map[Gid]*Level{GidInvadersStart: &Level{}}
+	room := &g.Levels[GidInvadersStart].Room
+	room.Dx, room.Dy = 1024, 1024
+	room.Walls = map[string]linear.Poly{"1": {{900, 900}, {900, 950}, {950, 950}, {950, 900}}}
+	rng := cmwc.MakeGoodCmwc()
+	rng.Seed(123)
+	options := manaSourceOptions(room, rng)
+	g.Levels[GidInvadersStart].ManaSource.Init(&options)
+	g.Ents = make(map[Gid]Ent)
+	g.Moba = &GameModeMoba{Sides: map[int]*GameModeMobaSideData{0: {}, 1: {}}}
+	g.Moba.losCache = makeLosCache(room.Dx, room.Dy)
+	return &g
+}
+
+func addVisibilityPlayer(g *Game, gid Gid, side int, pos linear.Vec2, cloaking float64) {
+	p := &PlayerEnt{}
+	p.Gid = gid
+	p.Side_ = side
+	p.CurrentLevel = GidInvadersStart
+	p.Position = pos
+	p.Processes = make(map[int]Process)
+	p.StatsInst = stats.Make(stats.Base{
+		Health:   1000,
+		Mass:     750,
+		Size:     12,
+		Vision:   400,
+		Cloaking: cloaking,
+	})
+	g.AddEnt(p)
+}
+
+func TestStateForSideHidesWhatSideCantSee(t *testing.T) {
+	g := makeVisibilityGame(t)
+	addVisibilityPlayer(g, "Engine:1", 0, linear.Vec2{200, 200}, 0)
+	addVisibilityPlayer(g, "Engine:2", 1, linear.Vec2{300, 200}, 0)
+	addVisibilityPlayer(g, "Engine:3", 1, linear.Vec2{250, 300}, 1)
+	addVisibilityPlayer(g, "Engine:4", 1, linear.Vec2{800, 800}, 0)
+	g.Processes = []Process{
+		&controlPointAttackProcess{Side: 1, ProjPos: linear.Vec2{220, 220}, LockTime: 10},
+		&controlPointAttackProcess{Side: 1, ProjPos: linear.Vec2{1000, 20}, LockTime: 10},
+	}
+	g.Engines = map[int64]*PlayerData{
+		1: {PlayerGid: "Engine:1", Side: 0, CountdownFrames: 5, Deaths: 1},
+		2: {PlayerGid: "Engine:2", Side: 1, CountdownFrames: 5, Deaths: 2},
+	}
+	g.Rng = cmwc.MakeGoodCmwc()
+	ms := &g.Levels[GidInvadersStart].ManaSource
+	near, far := &ms.nodes[0][0], &ms.nodes[len(ms.nodes)-1][len(ms.nodes[0])-1]
+	near.Mana, far.Mana = Mana{}, Mana{}
+	g.updateWallCaches()
+	g.updateAllEnts()
+	g.updateVision()
+
+	state := g.StateForSide(0)
+	for gid, want := range map[Gid]bool{
+		"Engine:1": true,  // on side 0
+		"Engine:2": true,  // in sight
+		"Engine:3": false, // in sight but cloaked
+		"Engine:4": false, // too far away
+	} {
+		if _, ok := state.Ents[gid]; ok != want {
+			t.Errorf("%s in side 0's state: got %t, want %t", gid, ok, want)
+		}
+	}
+	if len(state.Processes) != 1 {
+		t.Errorf("Expected side 0 to get only the process it can see, got %d", len(state.Processes))
+	}
+	if state.Rng != nil {
+		t.Errorf("Side 0's state has the rng")
+	}
+	if data := state.Engines[1]; data.CountdownFrames != 5 || data.Deaths != 1 {
+		t.Errorf("Side 0's own engine data was changed: %+v", *data)
+	}
+	if data := state.Engines[2]; data.CountdownFrames != 0 || data.Deaths != 0 {
+		t.Errorf("Side 0 can see side 1's respawn countdown or deaths: %+v", *data)
+	}
+	if g.Engines[2].CountdownFrames != 5 {
+		t.Errorf("Filtering the state changed the game's engine data")
+	}
+	copied := &state.Levels[GidInvadersStart].ManaSource
+	if copied.nodes[0][0].Mana != (Mana{}) {
+		t.Errorf("Side 0 can't see drained mana that is in sight")
+	}
+	if last := copied.nodes[len(ms.nodes)-1][len(ms.nodes[0])-1]; last.Mana != last.MaxMana {
+		t.Errorf("Side 0 can see drained mana that is out of sight")
+	}
+	if far.Mana != (Mana{}) {
+		t.Errorf("Filtering the state changed the game's mana")
+	}
+
+	// Side 1 sees all of its own ents, cloaked or not.
+	state = g.StateForSide(1)
+	for _, gid := range []Gid{"Engine:2", "Engine:3", "Engine:4"} {
+		if _, ok := state.Ents[gid]; !ok {
+			t.Errorf("%s is missing from its own side's state", gid)
+		}
+	}
+	if len(state.Processes) != 2 {
+		t.Errorf("Expected side 1 to get both of its processes, got %d", len(state.Processes))
+	}
+}
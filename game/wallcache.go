@@ -2,6 +2,8 @@ package game
 
 import (
 	"github.com/runningwild/linear"
+	"github.com/runningwild/magnus/base"
+	"math"
 )
 
 const wallGridSize = 100
@@ -28,6 +30,30 @@ func (wc *wallCache) GetWalls(x, y int) []linear.Seg2 {
 	return wc.walls[x][y]
 }
 
+// GetWallsAlong returns the walls near every cell that seg passes through, so
+// that something moving farther than a cell in one frame can't skip a wall.
+// Walls near more than one of the cells are only returned once.
+func (wc *wallCache) GetWallsAlong(seg linear.Seg2) []linear.Seg2 {
+	x0, x1 := int(math.Min(seg.P.X, seg.Q.X)), int(math.Max(seg.P.X, seg.Q.X))
+	y0, y1 := int(math.Min(seg.P.Y, seg.Q.Y)), int(math.Max(seg.P.Y, seg.Q.Y))
+	if x0/wallGridSize == x1/wallGridSize && y0/wallGridSize == y1/wallGridSize {
+		return wc.GetWalls(x0, y0)
+	}
+	var walls []linear.Seg2
+	seen := make(map[linear.Seg2]bool)
+	for x := x0 / wallGridSize; x <= x1/wallGridSize; x++ {
+		for y := y0 / wallGridSize; y <= y1/wallGridSize; y++ {
+			for _, wall := range wc.GetWalls(x*wallGridSize, y*wallGridSize) {
+				if !seen[wall] {
+					seen[wall] = true
+					walls = append(walls, wall)
+				}
+			}
+		}
+	}
+	return walls
+}
+
 func (wc *wallCache) SetWalls(dx, dy int, walls []linear.Seg2, dist int) {
 	if len(walls) == 0 {
 		wc.walls = nil
@@ -78,3 +104,21 @@ func (wc *wallCache) SetWalls(dx, dy int, walls []linear.Seg2, dist int) {
 		}
 	}
 }
+
+// roomWalls returns every wall segment in room, in a fixed order.
+func roomWalls(room *Room) []linear.Seg2 {
+	var walls []linear.Seg2
+	base.DoOrdered(room.Walls, func(a, b string) bool { return a < b }, func(_ string, poly linear.Poly) {
+		for i := range poly {
+			walls = append(walls, poly.Seg(i))
+		}
+	})
+	return walls
+}
+
+// roomVisibleWalls returns every segment in room that blocks vision.  Hazards
+// that block vision are included here but not in roomWalls since ents can still
+// move through them.
+func roomVisibleWalls(room *Room) []linear.Seg2 {
+	return append(visionBlockingSegs(room), roomWalls(room)...)
+}
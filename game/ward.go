@@ -0,0 +1,109 @@
+package game
+
+import (
+	"encoding/gob"
+	gl "github.com/chsc/gogl/gl21"
+	"github.com/runningwild/linear"
+	"github.com/runningwild/magnus/base"
+	"github.com/runningwild/magnus/stats"
+	"github.com/runningwild/magnus/texture"
+)
+
+// A Ward is a stationary ent that gives its side vision around it until it
+// expires or an enemy destroys it.
+type Ward struct {
+	BaseEnt
+	NonManaUser
+
+	// Frames left until the ward expires on its own.
+	Timer int
+}
+
+func init() {
+	gob.Register(&Ward{})
+}
+
+// MakeWard places a ward at pos on behalf of owner and returns true, or returns
+// false if pos is inside of a wall.  Each owner can have at most maxWards
+// wards at a time, if it already has that many the one closest to expiring is
+// removed.
+func (g *Game) MakeWard(owner Gid, side int, pos linear.Vec2, radius, health float64, frames, maxWards int) bool {
+	level := GidInvadersStart
+	if ownerEnt, ok := g.Ents[owner]; ok {
+		level = ownerEnt.Level()
+	}
+	if l, ok := g.Levels[level]; !ok || l.Room.blocked(pos) {
+		return false
+	}
+
+	// g.temp.AllEnts doesn't have wards that were placed earlier this frame.
+	var owned []*Ward
+	g.DoForEnts(func(_ Gid, ent Ent) {
+		if ward, ok := ent.(*Ward); ok && ward.Owner() == owner && !ward.Dead() {
+			owned = append(owned, ward)
+		}
+	})
+	for maxWards > 0 && len(owned) >= maxWards {
+		oldest := 0
+		for i := range owned {
+			if owned[i].Timer < owned[oldest].Timer {
+				oldest = i
+			}
+		}
+		owned[oldest].Timer = 0
+		owned = append(owned[:oldest], owned[oldest+1:]...)
+	}
+
+	ward := Ward{
+		BaseEnt: BaseEnt{
+			Side_:        side,
+			Owner_:       owner,
+			CurrentLevel: level,
+			Position:     pos,
+		},
+		Timer: frames,
+	}
+	ward.StatsInst = stats.Make(stats.Base{
+		Health: health,
+		Mass:   100,
+		Size:   8,
+		Vision: radius,
+	})
+	g.AddEnt(&ward)
+	return true
+}
+
+func (w *Ward) Think(g *Game) {
+	w.BaseEnt.Think(g)
+	w.Velocity = linear.Vec2{}
+	if w.Timer > 0 {
+		w.Timer--
+	}
+}
+
+func (w *Ward) Dead() bool {
+	return w.Timer <= 0 || w.BaseEnt.Dead()
+}
+
+func (w *Ward) Immovable() bool { return true }
+
+func (w *Ward) Draw(g *Game, side int) {
+	base.EnableShader("circle")
+	base.SetUniformF("circle", "edge", 0.8)
+	if w.Side() == side {
+		gl.Color4ub(100, 255, 100, 200)
+	} else {
+		gl.Color4ub(255, 100, 100, 200)
+	}
+	size := w.Stats().Size()
+	texture.Render(w.Position.X-size, w.Position.Y-size, 2*size, 2*size)
+
+	base.EnableShader("status_bar")
+	base.SetUniformF("status_bar", "inner", 0.04)
+	base.SetUniformF("status_bar", "outer", 0.045)
+	base.SetUniformF("status_bar", "buffer", 0.01)
+	base.SetUniformF("status_bar", "frac", float32(w.Stats().HealthCur()/w.Stats().HealthMax()))
+	gl.Color4ub(255, 255, 255, 200)
+	texture.Render(w.Position.X-100, w.Position.Y-100, 200, 200)
+	base.EnableShader("")
+}
@@ -0,0 +1,36 @@
+package game
+
+import (
+	"github.com/runningwild/linear"
+	"testing"
+)
+
+func TestMakeWardLimitsWardsPlacedInOneFrame(t *testing.T) {
+	g := makeVisibilityGame(t)
+	addVisibilityPlayer(g, "Engine:1", 0, linear.Vec2{200, 200}, 0)
+	g.updateAllEnts()
+	for i := 0; i < 3; i++ {
+		if !g.MakeWard("Engine:1", 0, linear.Vec2{300, 200}, 100, 10, 100-i, 2) {
+			t.Fatalf("Unable to place ward %d", i)
+		}
+	}
+	alive := 0
+	for _, ent := range g.Ents {
+		if ward, ok := ent.(*Ward); ok && !ward.Dead() {
+			alive++
+		}
+	}
+	if alive != 2 {
+		t.Errorf("Expected 2 wards after placing 3 with a limit of 2, got %d", alive)
+	}
+}
+
+func TestMakeWardRejectsBlockedPositions(t *testing.T) {
+	g := makeVisibilityGame(t)
+	addVisibilityPlayer(g, "Engine:1", 0, linear.Vec2{200, 200}, 0)
+	for _, pos := range []linear.Vec2{{925, 925}, {-10, 200}, {200, 2000}} {
+		if g.MakeWard("Engine:1", 0, pos, 100, 10, 100, 2) {
+			t.Errorf("Placed a ward at %v", pos)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package generator
+
+import (
+	"github.com/runningwild/linear"
+	"github.com/runningwild/magnus/base"
+	"math/rand"
+)
+
+type DungeonOptions struct {
+	Dx, Dy float64
+
+	// Number of levels to generate.  Every level but the last has a portal at
+	// its end that leads to the next one.
+	Levels int
+
+	// The dungeon is laid out on a grid of cells this big, walls and corridors
+	// are always a whole number of cells.
+	CellSize float64
+
+	// Width of corridors, and the smallest and largest that a room can be on
+	// each side, all in cells.
+	CorridorWidth int
+	MinRoomSize   int
+	MaxRoomSize   int
+
+	// Density is the fraction of the available space that gets a room, and
+	// Branching is the chance that a room gets a corridor to one of its
+	// neighbors on top of the ones needed to connect everything.  Both are from
+	// 0 to 1.
+	Density   float64
+	Branching float64
+
+	// Number of start positions to place in the first room.
+	Starts int
+}
+
+func (o *DungeonOptions) setDefaults() {
+	if o.Levels < 1 {
+		o.Levels = 1
+	}
+	if o.CellSize == 0 {
+		o.CellSize = 32
+	}
+	if o.CorridorWidth == 0 {
+		o.CorridorWidth = 3
+	}
+	if o.MinRoomSize == 0 {
+		o.MinRoomSize = 6
+	}
+	if o.MaxRoomSize < o.MinRoomSize {
+		o.MaxRoomSize = 2 * o.MinRoomSize
+	}
+	if o.Density == 0 {
+		o.Density = 0.8
+	}
+	if o.Starts == 0 {
+		o.Starts = 1
+	}
+}
+
+// dungeonRect is a rectangle of cells, x and y inclusive, x+dx and y+dy
+// exclusive.
+type dungeonRect struct {
+	x, y, dx, dy int
+}
+
+func (r dungeonRect) center() (int, int) {
+	return r.x + r.dx/2, r.y + r.dy/2
+}
+
+// dungeonLevel is a grid of cells that are either open or solid.
+type dungeonLevel struct {
+	dx, dy int
+	open   [][]bool
+	rooms  []dungeonRect
+}
+
+func (l *dungeonLevel) carve(r dungeonRect) {
+	for x := r.x; x < r.x+r.dx; x++ {
+		for y := r.y; y < r.y+r.dy; y++ {
+			// The cells along the edge are always left solid.
+			if x > 0 && y > 0 && x < l.dx-1 && y < l.dy-1 {
+				l.open[x][y] = true
+			}
+		}
+	}
+}
+
+// connect carves an L-shaped corridor between the centers of rooms a and b.
+func (l *dungeonLevel) connect(r *rand.Rand, a, b dungeonRect, width int) {
+	ax, ay := a.center()
+	bx, by := b.center()
+	if r.Intn(2) == 0 {
+		ax, ay, bx, by = bx, by, ax, ay
+	}
+	lo, hi := ax, bx
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	l.carve(dungeonRect{lo - width/2, ay - width/2, hi - lo + width, width})
+	lo, hi = ay, by
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	l.carve(dungeonRect{bx - width/2, lo - width/2, width, hi - lo + width})
+}
+
+// split recursively divides rect in two until the pieces are small enough to
+// hold a single room, places rooms in some of them, and connects the two halves
+// of every split.  It returns the indices of the rooms placed in rect.
+func (l *dungeonLevel) split(r *rand.Rand, rect dungeonRect, o *DungeonOptions) []int {
+	// Every piece needs room for the smallest room and a wall on either side.
+	min := o.MinRoomSize + 2
+	canX := rect.dx >= 2*min
+	canY := rect.dy >= 2*min
+	big := rect.dx > o.MaxRoomSize+2 || rect.dy > o.MaxRoomSize+2
+	if !big || (!canX && !canY) {
+		if r.Float64() >= o.Density {
+			return nil
+		}
+		room := dungeonRect{dx: o.MinRoomSize, dy: o.MinRoomSize}
+		if max := rect.dx - 2; max > o.MinRoomSize {
+			room.dx += r.Intn(max - o.MinRoomSize + 1)
+		}
+		if max := rect.dy - 2; max > o.MinRoomSize {
+			room.dy += r.Intn(max - o.MinRoomSize + 1)
+		}
+		room.x = rect.x + 1 + r.Intn(rect.dx-room.dx-1)
+		room.y = rect.y + 1 + r.Intn(rect.dy-room.dy-1)
+		l.rooms = append(l.rooms, room)
+		l.carve(room)
+		return []int{len(l.rooms) - 1}
+	}
+	horizontal := canY && (!canX || rect.dy > rect.dx || (rect.dy == rect.dx && r.Intn(2) == 0))
+	var a, b dungeonRect
+	if horizontal {
+		cut := min + r.Intn(rect.dy-2*min+1)
+		a = dungeonRect{rect.x, rect.y, rect.dx, cut}
+		b = dungeonRect{rect.x, rect.y + cut, rect.dx, rect.dy - cut}
+	} else {
+		cut := min + r.Intn(rect.dx-2*min+1)
+		a = dungeonRect{rect.x, rect.y, cut, rect.dy}
+		b = dungeonRect{rect.x + cut, rect.y, rect.dx - cut, rect.dy}
+	}
+	ra := l.split(r, a, o)
+	rb := l.split(r, b, o)
+	if len(ra) > 0 && len(rb) > 0 {
+		// Connect the closest pair of rooms from the two halves so that
+		// corridors don't cut across the rest of the dungeon.
+		best, bestDist := [2]int{}, -1
+		for _, i := range ra {
+			for _, j := range rb {
+				ix, iy := l.rooms[i].center()
+				jx, jy := l.rooms[j].center()
+				dist := (ix-jx)*(ix-jx) + (iy-jy)*(iy-jy)
+				if bestDist == -1 || dist < bestDist {
+					best, bestDist = [2]int{i, j}, dist
+				}
+			}
+		}
+		l.connect(r, l.rooms[best[0]], l.rooms[best[1]], o.CorridorWidth)
+	}
+	return append(ra, rb...)
+}
+
+// distances returns the number of steps from x,y to every open cell, or -1 for
+// cells that can't be reached.
+func (l *dungeonLevel) distances(x, y int) [][]int {
+	dist := make([][]int, l.dx)
+	for i := range dist {
+		dist[i] = make([]int, l.dy)
+		for j := range dist[i] {
+			dist[i][j] = -1
+		}
+	}
+	dist[x][y] = 0
+	queue := [][2]int{{x, y}}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, d := range [][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+			nx, ny := cur[0]+d[0], cur[1]+d[1]
+			if nx < 0 || ny < 0 || nx >= l.dx || ny >= l.dy || !l.open[nx][ny] || dist[nx][ny] != -1 {
+				continue
+			}
+			dist[nx][ny] = dist[cur[0]][cur[1]] + 1
+			queue = append(queue, [2]int{nx, ny})
+		}
+	}
+	return dist
+}
+
+// walls merges the solid cells into as few rectangles as it easily can.
+func (l *dungeonLevel) walls(size float64) []linear.Poly {
+	used := make([][]bool, l.dx)
+	for i := range used {
+		used[i] = make([]bool, l.dy)
+	}
+	solid := func(x, y int) bool { return !l.open[x][y] && !used[x][y] }
+	var walls []linear.Poly
+	for y := 0; y < l.dy; y++ {
+		for x := 0; x < l.dx; x++ {
+			if !solid(x, y) {
+				continue
+			}
+			x1 := x
+			for x1 < l.dx && solid(x1, y) {
+				x1++
+			}
+			y1 := y + 1
+			for ; y1 < l.dy; y1++ {
+				full := true
+				for i := x; i < x1 && full; i++ {
+					full = solid(i, y1)
+				}
+				if !full {
+					break
+				}
+			}
+			for i := x; i < x1; i++ {
+				for j := y; j < y1; j++ {
+					used[i][j] = true
+				}
+			}
+			x0, y0 := float64(x)*size, float64(y)*size
+			xx, yy := float64(x1)*size, float64(y1)*size
+			walls = append(walls, linear.Poly{
+				linear.Vec2{x0, yy},
+				linear.Vec2{xx, yy},
+				linear.Vec2{xx, y0},
+				linear.Vec2{x0, y0},
+			})
+		}
+	}
+	return walls
+}
+
+// GenerateDungeon generates Levels connected dungeons of rooms and corridors.
+// The dungeon is built by recursively splitting the map and placing a room in
+// most of the pieces, so every room can be reached from every other room.  The
+// first room gets the start positions and the room furthest from it gets the
+// end, which on every level but the last is also a portal to the next level.
+// The same options and seed always give the same dungeon.
+func GenerateDungeon(options DungeonOptions, seed int64) []Room {
+	return MakeGenerator(seed).Dungeon(options)
+}
+
+// Dungeon generates a dungeon the same way that GenerateDungeon does.
+func (g *Generator) Dungeon(options DungeonOptions) []Room {
+	options.setDefaults()
+	var rooms []Room
+	for i := 0; i < options.Levels; i++ {
+		room := g.dungeonLevel(&options)
+		if i < options.Levels-1 {
+			room.Portals[g.newId()] = Portal{
+				Region: linear.Poly{
+					room.End.Add(linear.Vec2{-options.CellSize, options.CellSize}),
+					room.End.Add(linear.Vec2{options.CellSize, options.CellSize}),
+					room.End.Add(linear.Vec2{options.CellSize, -options.CellSize}),
+					room.End.Add(linear.Vec2{-options.CellSize, -options.CellSize}),
+				},
+				Dest: i + 1,
+			}
+			room.NextId = g.nextId
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+func (g *Generator) dungeonLevel(o *DungeonOptions) Room {
+	r := g.rng
+	var l dungeonLevel
+	l.dx = int(o.Dx / o.CellSize)
+	l.dy = int(o.Dy / o.CellSize)
+	if l.dx < o.MinRoomSize+2 || l.dy < o.MinRoomSize+2 {
+		base.Error().Printf("A %vx%v dungeon is too small for %v cell rooms", o.Dx, o.Dy, o.MinRoomSize)
+		l.dx = o.MinRoomSize + 2
+		l.dy = o.MinRoomSize + 2
+	}
+
+	// Density can leave us with too few rooms to have both a start and an end,
+	// in which case just try again.
+	for sanity := 0; sanity < 100 && len(l.rooms) < 2; sanity++ {
+		l.open = make([][]bool, l.dx)
+		for i := range l.open {
+			l.open[i] = make([]bool, l.dy)
+		}
+		l.rooms = nil
+		l.split(r, dungeonRect{0, 0, l.dx, l.dy}, o)
+	}
+	if len(l.rooms) == 0 {
+		base.Error().Printf("Unable to place any rooms in a %vx%v dungeon", o.Dx, o.Dy)
+		l.rooms = append(l.rooms, dungeonRect{1, 1, l.dx - 2, l.dy - 2})
+		l.carve(l.rooms[0])
+	}
+
+	// Extra corridors to each room's nearest neighbor give the dungeon loops.
+	for i := range l.rooms {
+		if r.Float64() >= o.Branching {
+			continue
+		}
+		ix, iy := l.rooms[i].center()
+		best, bestDist := -1, 0
+		for j := range l.rooms {
+			if j == i {
+				continue
+			}
+			jx, jy := l.rooms[j].center()
+			dist := (ix-jx)*(ix-jx) + (iy-jy)*(iy-jy)
+			if best == -1 || dist < bestDist {
+				best, bestDist = j, dist
+			}
+		}
+		if best != -1 {
+			l.connect(r, l.rooms[i], l.rooms[best], o.CorridorWidth)
+		}
+	}
+
+	room := g.newRoom(int(float64(l.dx)*o.CellSize), int(float64(l.dy)*o.CellSize))
+	room.Portals = make(map[string]Portal)
+	room.Hazards = make(map[string]*Hazard)
+	cellCenter := func(x, y int) linear.Vec2 {
+		return linear.Vec2{(float64(x) + 0.5) * o.CellSize, (float64(y) + 0.5) * o.CellSize}
+	}
+
+	// Starts are spread along the middle row of the first room.
+	start := l.rooms[0]
+	sx, sy := start.center()
+	for i := 0; i < o.Starts; i++ {
+		x := start.x + (i+1)*start.dx/(o.Starts+1)
+		room.Starts = append(room.Starts, cellCenter(x, sy))
+	}
+
+	dist := l.distances(sx, sy)
+	end, endDist := 0, -1
+	for i := range l.rooms[1:] {
+		x, y := l.rooms[i+1].center()
+		if dist[x][y] > endDist {
+			end, endDist = i+1, dist[x][y]
+		}
+	}
+	room.End = cellCenter(l.rooms[end].center())
+
+	for _, wall := range l.walls(o.CellSize) {
+		room.Walls[g.newId()] = wall
+	}
+	room.Walls[g.newId()] = linear.Poly{
+		linear.Vec2{0, 0},
+		linear.Vec2{float64(room.Dx), 0},
+		linear.Vec2{float64(room.Dx), float64(room.Dy)},
+		linear.Vec2{0, float64(room.Dy)},
+	}
+	room.NextId = g.nextId
+	return room
+}
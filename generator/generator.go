@@ -5,33 +5,117 @@ import (
 	"github.com/runningwild/cmwc"
 	"github.com/runningwild/linear"
 	"github.com/runningwild/magnus/base"
+	"github.com/runningwild/magnus/stats"
 	"math"
 	"math/rand"
 )
 
+// Room has the same fields as game.Room so that a generated room can be
+// converted to one by going through json.
 type Room struct {
-	Walls  map[string]linear.Poly
-	Starts []linear.Vec2
-	Dx, Dy int
-	NextId int
+	Walls   map[string]linear.Poly
+	Starts  []linear.Vec2
+	End     linear.Vec2
+	Portals map[string]Portal
+	Hazards map[string]*Hazard
+	Dx, Dy  int
+	NextId  int
+
+	Mana RoomMana
 
 	// Only filled for moba rooms
 	Moba struct {
-		SideData []mobaRoomSideData
+		SideData []MobaRoomSideData
 	}
 }
 
-type mobaRoomSideData struct {
-	Base   linear.Vec2   // Position of the base for this side
-	Towers []linear.Vec2 // Positions of the towers for this side
-	// Will also need waypoints for units, production and whatnot.
+type MobaRoomSideData struct {
+	Base   linear.Vec2     // Position of the base for this side
+	Towers []linear.Vec2   // Positions of the towers for this side
+	Lanes  [][]linear.Vec2 // Waypoints along each lane, starting at the base
+	// Will also need production and whatnot.
+}
+
+type Portal struct {
+	Region linear.Poly
+	Dest   int
+}
+
+type Hazard struct {
+	Region        linear.Poly
+	Damage        stats.Damage
+	AccMultiplier float64
+	Friction      float64
+	BlockVision   bool
+	Placed        bool
+	Side          int
+	Owner         string
+	Hidden        bool
+	RevealedTo    map[int]bool
+}
+
+type ManaSeed struct {
+	Pos   linear.Vec2
+	Color int
+}
+
+type RoomMana struct {
+	Seeds            []ManaSeed
+	NumSeeds         int
+	NodeSpacing      int
+	MaxDrainDistance float64
+	MaxDrainRate     float64
+	RegenPerFrame    [3]float64
+	NodeMagnitude    float64
+	EventInterval    int
 }
 
-var nextIdInt int
+// A Generator generates rooms from a single seed.  All of the state used while
+// generating a room is kept in the Generator, so separate Generators can be
+// used from different goroutines at the same time.  A Generator itself should
+// only be used from one goroutine at a time.
+type Generator struct {
+	seed   int64
+	rng    *rand.Rand
+	nextId int
+}
+
+// MakeGenerator returns a Generator that generates rooms from seed, or from a
+// random seed if seed is 0.  Generators made with the same seed generate the
+// same rooms when they're asked for them in the same order.
+func MakeGenerator(seed int64) *Generator {
+	c := cmwc.MakeGoodCmwc()
+	if seed == 0 {
+		c.SeedWithDevRand()
+		seed = c.Int63()
+		c = cmwc.MakeGoodCmwc()
+		base.Log().Printf("SEED: %v", seed)
+	}
+	c.Seed(seed)
+	return &Generator{seed: seed, rng: rand.New(c)}
+}
+
+// Seed returns the seed that the Generator was made with.
+func (g *Generator) Seed() int64 {
+	return g.seed
+}
+
+// newRoom starts a new room, ids start over for each room.
+func (g *Generator) newRoom(dx, dy int) Room {
+	var room Room
+	room.Walls = make(map[string]linear.Poly)
+	room.Dx = dx
+	room.Dy = dy
+	g.nextId = 1
+	return room
+}
 
-func nextId() string {
-	nextIdInt++
-	return fmt.Sprintf("%d", nextIdInt)
+// newId returns the next free id in the current room, nextId is always the
+// id that will be used next so it can be stored in the room as is.
+func (g *Generator) newId() string {
+	id := fmt.Sprintf("%d", g.nextId)
+	g.nextId++
+	return id
 }
 
 func distFromPointToSeg(p linear.Vec2, s linear.Seg2) float64 {
@@ -56,23 +140,13 @@ func gridify(f float64, grid int) float64 {
 }
 
 func GenerateRoom(dx, dy, radius float64, grid int, seed int64) Room {
-	var room Room
-	room.Walls = make(map[string]linear.Poly)
-	nextIdInt = 0
-	room.Dx = int(dx)
-	room.Dy = int(dy)
-	c := cmwc.MakeGoodCmwc()
-	if seed == 0 {
-		c.SeedWithDevRand()
-		n := c.Int63()
-		c = cmwc.MakeGoodCmwc()
-		base.Log().Printf("SEED: %v", n)
-		c.Seed(n)
-	} else {
-		c.Seed(seed)
-	}
+	return MakeGenerator(seed).Room(dx, dy, radius, grid)
+}
+
+func (g *Generator) Room(dx, dy, radius float64, grid int) Room {
+	room := g.newRoom(int(dx), int(dy))
 	sanity := int(math.Sqrt(dx * dy))
-	r := rand.New(c)
+	r := g.rng
 	var poss []linear.Vec2
 	for sanity > 0 {
 		pos := linear.Vec2{r.Float64() * (dx - radius + 1), r.Float64() * (dy - radius + 1)}
@@ -118,11 +192,11 @@ func GenerateRoom(dx, dy, radius float64, grid int, seed int64) Room {
 
 	room.Starts = []linear.Vec2{poss[a], poss[b]}
 	for _, start := range room.Starts {
-		var data mobaRoomSideData
+		var data MobaRoomSideData
 		data.Base = start
 		room.Moba.SideData = append(room.Moba.SideData, data)
 	}
-	var data mobaRoomSideData
+	var data MobaRoomSideData
 	for i, pos := range poss {
 		if i == a || i == b {
 			continue
@@ -181,14 +255,60 @@ func GenerateRoom(dx, dy, radius float64, grid int, seed int64) Room {
 	for _, s := range segs {
 		right := s.Ray().Cross().Norm().Scale(-float64(grid))
 		s2 := linear.Seg2{s.Q.Add(right), s.P.Add(right)}
-		room.Walls[nextId()] = linear.Poly{s.P, s.Q, s2.P, s2.Q}
+		room.Walls[g.newId()] = linear.Poly{s.P, s.Q, s2.P, s2.Q}
 	}
-	room.Walls[nextId()] = linear.Poly{
+	room.Walls[g.newId()] = linear.Poly{
 		linear.Vec2{0, 0},
 		linear.Vec2{dx, 0},
 		linear.Vec2{dx, dy},
 		linear.Vec2{0, dy},
 	}
-	room.NextId = nextIdInt
+	room.NextId = g.nextId
+	room.Mana.Seeds = symmetricManaSeeds(r, dx, dy, room.Starts[0], room.Starts[1], 8)
 	return room
 }
+
+// symmetricManaSeeds places mana seeds so that two sides with bases at a and b
+// have the same access to every color.  Every seed that is closer to a is
+// mirrored across the line halfway between the bases, and each color gets a
+// single contested seed on that line.
+func symmetricManaSeeds(r *rand.Rand, dx, dy float64, a, b linear.Vec2, pairs int) []ManaSeed {
+	inside := func(v linear.Vec2) bool {
+		return v.X >= 0 && v.X <= dx && v.Y >= 0 && v.Y <= dy
+	}
+	mid := a.Add(b).Scale(0.5)
+	normal := b.Sub(a).Norm()
+	mirror := func(v linear.Vec2) linear.Vec2 {
+		return v.Sub(normal.Scale(2 * v.Sub(mid).Dot(normal)))
+	}
+
+	var seeds []ManaSeed
+	sanity := 1000
+	for color := 0; color < 3; color++ {
+		for ; sanity > 0; sanity-- {
+			// The line between the bases may not cross the middle of the room, so
+			// this picks points that reach well past the edges and throws out the
+			// ones that don't land inside.
+			t := (r.Float64()*2 - 1) * (dx + dy)
+			pos := mid.Add(normal.Cross().Scale(t))
+			if inside(pos) {
+				seeds = append(seeds, ManaSeed{pos, color})
+				break
+			}
+		}
+	}
+	for i := 0; i < pairs && sanity > 0; sanity-- {
+		pos := linear.Vec2{r.Float64() * dx, r.Float64() * dy}
+		if pos.Sub(mid).Dot(normal) > 0 {
+			pos = mirror(pos)
+		}
+		other := mirror(pos)
+		if !inside(pos) || !inside(other) {
+			continue
+		}
+		color := i % 3
+		seeds = append(seeds, ManaSeed{pos, color}, ManaSeed{other, color})
+		i++
+	}
+	return seeds
+}
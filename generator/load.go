@@ -0,0 +1,125 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Version of the room file format.  Files without a version are just a Room,
+// which is how rooms were saved before there was any metadata.
+const RoomFileVersion = 1
+
+// RoomInfo describes a room so that maps that can't be used for a game can be
+// left out when choosing one.
+type RoomInfo struct {
+	Name   string
+	Author string
+
+	// Modes that the room can be played in, "moba" or "standard".
+	Modes []string
+
+	// Number of sides that the room has starts for.
+	Sides int
+}
+
+// Supports returns true if the room can be played in mode with sides sides.
+func (info RoomInfo) Supports(mode string, sides int) bool {
+	if info.Sides < sides {
+		return false
+	}
+	for _, m := range info.Modes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// infer fills in anything missing from info from the room itself, which was
+// loaded from or is being saved to path.
+func (info *RoomInfo) infer(path string, room Room) {
+	if info.Name == "" {
+		info.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if info.Sides == 0 {
+		info.Sides = len(room.Starts)
+	}
+	if len(info.Modes) == 0 {
+		if len(room.Moba.SideData) > 0 {
+			info.Modes = []string{"moba"}
+		} else {
+			info.Modes = []string{"standard"}
+		}
+	}
+}
+
+// roomFile is the format of the rooms in data/rooms.  The room is kept as json
+// so that it can be decoded into either package's Room.
+type roomFile struct {
+	Version int
+	Info    RoomInfo
+	Room    json.RawMessage
+}
+
+// ReadRoomFile reads the room file at path and returns its info, with
+// anything missing filled in from the room, and its room as json so that it
+// can be decoded into either package's types.
+func ReadRoomFile(path string) (RoomInfo, json.RawMessage, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return RoomInfo{}, nil, err
+	}
+	var file roomFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return RoomInfo{}, nil, err
+	}
+	switch file.Version {
+	case 0:
+		file.Info = RoomInfo{}
+		file.Room = data
+	case RoomFileVersion:
+	default:
+		return RoomInfo{}, nil, fmt.Errorf("%s is version %d, only versions up to %d are supported", path, file.Version, RoomFileVersion)
+	}
+	var room Room
+	if err := json.Unmarshal(file.Room, &room); err != nil {
+		return RoomInfo{}, nil, err
+	}
+	file.Info.infer(path, room)
+	return file.Info, file.Room, nil
+}
+
+// WriteRoomFile saves room, which can be either package's Room, to path at
+// the current version, filling in anything missing from info.
+func WriteRoomFile(path string, info RoomInfo, room interface{}) error {
+	file := roomFile{Version: RoomFileVersion, Info: info}
+	var err error
+	file.Room, err = json.Marshal(room)
+	if err != nil {
+		return err
+	}
+	var decoded Room
+	if err := json.Unmarshal(file.Room, &decoded); err != nil {
+		return err
+	}
+	file.Info.infer(path, decoded)
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// LoadRoom loads the room from a room file.
+func LoadRoom(path string) (Room, error) {
+	var room Room
+	_, data, err := ReadRoomFile(path)
+	if err != nil {
+		return room, err
+	}
+	err = json.Unmarshal(data, &room)
+	return room, err
+}
@@ -0,0 +1,357 @@
+package generator
+
+import (
+	"github.com/runningwild/linear"
+	"github.com/runningwild/magnus/base"
+	"github.com/runningwild/magnus/los"
+	"math"
+)
+
+type MobaOptions struct {
+	Dx, Dy float64
+
+	// Number of sides, from 2 to 4.
+	Sides int
+
+	// If Mirror is set the map is reflected onto each side rather than rotated.
+	// This only works for 2 or 4 sides, anything else is rotated regardless.
+	Mirror bool
+
+	// Number of sets of symmetric neutral control points, in addition to the
+	// one in the center, and number of sets of symmetric walls to try to place.
+	NeutralPoints int
+	Walls         int
+
+	// Walls are this thick, and this long on average.
+	WallThickness float64
+	WallLength    float64
+
+	// Nothing is placed where it would block a lane this wide.
+	LaneWidth float64
+}
+
+func (o *MobaOptions) setDefaults() {
+	if o.Sides < 2 {
+		o.Sides = 2
+	}
+	if o.Sides > 4 {
+		base.Warn().Printf("Can't generate a map for %d sides, using 4 instead", o.Sides)
+		o.Sides = 4
+	}
+	if o.Mirror && o.Sides != 2 && o.Sides != 4 {
+		base.Warn().Printf("Can't mirror a map for %d sides, rotating it instead", o.Sides)
+		o.Mirror = false
+	}
+	if o.NeutralPoints == 0 {
+		o.NeutralPoints = 1
+	}
+	if o.Walls == 0 {
+		o.Walls = 6
+	}
+	if o.WallThickness == 0 {
+		o.WallThickness = 32
+	}
+	if o.WallLength == 0 {
+		o.WallLength = 150
+	}
+	if o.LaneWidth == 0 {
+		o.LaneWidth = 120
+	}
+}
+
+// symmetry is the set of transformations that take side 0's half of the map
+// onto every side's, symmetry[i] maps side 0 onto side i.
+type symmetry []func(linear.Vec2) linear.Vec2
+
+func makeSymmetry(center linear.Vec2, sides int, mirror bool) symmetry {
+	reflectX := func(v linear.Vec2) linear.Vec2 { return linear.Vec2{2*center.X - v.X, v.Y} }
+	reflectY := func(v linear.Vec2) linear.Vec2 { return linear.Vec2{v.X, 2*center.Y - v.Y} }
+	if mirror && sides == 2 {
+		return symmetry{
+			func(v linear.Vec2) linear.Vec2 { return v },
+			reflectX,
+		}
+	}
+	if mirror && sides == 4 {
+		// In the same order that the sides would be in if they were rotated.
+		return symmetry{
+			func(v linear.Vec2) linear.Vec2 { return v },
+			reflectX,
+			func(v linear.Vec2) linear.Vec2 { return reflectX(reflectY(v)) },
+			reflectY,
+		}
+	}
+	var sym symmetry
+	for i := 0; i < sides; i++ {
+		angle := 2 * math.Pi * float64(i) / float64(sides)
+		sym = append(sym, func(v linear.Vec2) linear.Vec2 {
+			return v.RotateAround(center, angle)
+		})
+	}
+	return sym
+}
+
+// orbit returns every image of v, one for each side.
+func (sym symmetry) orbit(v linear.Vec2) []linear.Vec2 {
+	var ret []linear.Vec2
+	for _, f := range sym {
+		ret = append(ret, f(v))
+	}
+	return ret
+}
+
+// polyOrbit returns every image of poly, one for each side.  Reflections turn
+// polygons inside out, so they are flipped back to match poly.
+func (sym symmetry) polyOrbit(poly linear.Poly) []linear.Poly {
+	var ret []linear.Poly
+	for _, f := range sym {
+		var image linear.Poly
+		for _, v := range poly {
+			image = append(image, f(v))
+		}
+		if image.IsCounterClockwise() != poly.IsCounterClockwise() {
+			for i, j := 0, len(image)-1; i < j; i, j = i+1, j-1 {
+				image[i], image[j] = image[j], image[i]
+			}
+		}
+		ret = append(ret, image)
+	}
+	return ret
+}
+
+func distBetweenSegs(a, b linear.Seg2) float64 {
+	if a.DoesIsect(b) {
+		return 0
+	}
+	return math.Min(
+		math.Min(distFromPointToSeg(a.P, b), distFromPointToSeg(a.Q, b)),
+		math.Min(distFromPointToSeg(b.P, a), distFromPointToSeg(b.Q, a)))
+}
+
+func distFromPointToPoly(v linear.Vec2, poly linear.Poly) float64 {
+	if los.PolyContains(poly, v) {
+		return 0
+	}
+	dist := math.Inf(1)
+	for i := range poly {
+		dist = math.Min(dist, distFromPointToSeg(v, poly.Seg(i)))
+	}
+	return dist
+}
+
+func distBetweenPolys(a, b linear.Poly) float64 {
+	if los.PolyContains(b, a[0]) || los.PolyContains(a, b[0]) {
+		return 0
+	}
+	dist := math.Inf(1)
+	for i := range a {
+		for j := range b {
+			dist = math.Min(dist, distBetweenSegs(a.Seg(i), b.Seg(j)))
+		}
+	}
+	return dist
+}
+
+// GenerateMoba generates a moba map that is the same from the point of view of
+// every side: each side has a base, a start position, three lanes with a tower
+// on each, and the walls, neutral control points and mana seeds are all placed
+// symmetrically.  The same options and seed always give the same map.
+func GenerateMoba(options MobaOptions, seed int64) Room {
+	return MakeGenerator(seed).Moba(options)
+}
+
+// Moba generates a moba map the same way that GenerateMoba does.
+func (g *Generator) Moba(options MobaOptions) Room {
+	options.setDefaults()
+	dx, dy := options.Dx, options.Dy
+	room := g.newRoom(int(dx), int(dy))
+	r := g.rng
+
+	center := linear.Vec2{dx / 2, dy / 2}
+	sym := makeSymmetry(center, options.Sides, options.Mirror)
+
+	// Side 0's base is on the left, or the top left when there are four sides,
+	// and everything else for side 0 is placed relative to it.  The lanes go
+	// from the base to the center and to the points halfway around to each of
+	// the neighboring bases.
+	radius := 0.4 * math.Min(dx, dy)
+	angle := math.Pi
+	if options.Sides == 4 {
+		angle = 5 * math.Pi / 4
+	}
+	// Mirrored bases have to stay on the diagonal, or on the horizontal line
+	// through the center, otherwise their images won't line up.
+	jitter := (r.Float64() - 0.5) * 0.2
+	if !options.Mirror {
+		angle += jitter
+	}
+	toBase := (linear.Vec2{radius, 0}).Rotate(angle)
+	baseSpread := math.Pi / float64(options.Sides)
+	var side0 MobaRoomSideData
+	side0.Base = center.Add(toBase)
+	start := center.Add(toBase.Scale(0.85))
+	side0.Lanes = [][]linear.Vec2{
+		{side0.Base, center},
+		{side0.Base, center.Add(toBase.Rotate(baseSpread))},
+		{side0.Base, center.Add(toBase.Rotate(-baseSpread))},
+	}
+	for _, lane := range side0.Lanes {
+		end := lane[len(lane)-1]
+		side0.Towers = append(side0.Towers, side0.Base.Add(end.Sub(side0.Base).Scale(0.45)))
+	}
+
+	for _, f := range sym {
+		var data MobaRoomSideData
+		data.Base = f(side0.Base)
+		for _, tower := range side0.Towers {
+			data.Towers = append(data.Towers, f(tower))
+		}
+		for _, lane := range side0.Lanes {
+			var image []linear.Vec2
+			for _, v := range lane {
+				image = append(image, f(v))
+			}
+			data.Lanes = append(data.Lanes, image)
+		}
+		room.Moba.SideData = append(room.Moba.SideData, data)
+		room.Starts = append(room.Starts, f(start))
+	}
+
+	var lanes []linear.Seg2
+	var keepClear []linear.Vec2
+	for _, data := range room.Moba.SideData {
+		for _, lane := range data.Lanes {
+			for i := 1; i < len(lane); i++ {
+				lanes = append(lanes, linear.Seg2{lane[i-1], lane[i]})
+			}
+		}
+		keepClear = append(keepClear, data.Base)
+		keepClear = append(keepClear, data.Towers...)
+	}
+	keepClear = append(keepClear, room.Starts...)
+
+	// Neutral control points go in the last entry of SideData, one in the
+	// center and the rest in symmetric sets.
+	margin := 2 * options.WallThickness
+	inside := func(v linear.Vec2) bool {
+		return v.X >= margin && v.X <= dx-margin && v.Y >= margin && v.Y <= dy-margin
+	}
+	var neutral MobaRoomSideData
+	neutral.Towers = append(neutral.Towers, center)
+	for sanity := 1000; sanity > 0 && len(neutral.Towers) < 1+options.NeutralPoints*len(sym); sanity-- {
+		orbit := sym.orbit(linear.Vec2{r.Float64() * dx, r.Float64() * dy})
+		good := true
+		for i, v := range orbit {
+			if !inside(v) {
+				good = false
+			}
+			for _, other := range append(keepClear, neutral.Towers...) {
+				if v.Sub(other).Mag() < 200 {
+					good = false
+				}
+			}
+			for _, w := range orbit[i+1:] {
+				if v.Sub(w).Mag() < 200 {
+					good = false
+				}
+			}
+		}
+		if good {
+			neutral.Towers = append(neutral.Towers, orbit...)
+		}
+	}
+	room.Moba.SideData = append(room.Moba.SideData, neutral)
+	keepClear = append(keepClear, neutral.Towers...)
+
+	// Walls are placed a set at a time, each set is one wall and all of its
+	// images.  Walls keep out of the lanes, keep away from anything that ents
+	// will be placed on or fighting over, and leave room to walk between them.
+	var walls []linear.Poly
+	placed := 0
+	for sanity := 1000; sanity > 0 && placed < options.Walls; sanity-- {
+		p := linear.Vec2{r.Float64() * dx, r.Float64() * dy}
+		length := options.WallLength * (0.5 + r.Float64())
+		var wallAngle float64
+		if options.Sides == 3 {
+			wallAngle = r.Float64() * math.Pi
+		} else {
+			wallAngle = float64(r.Intn(4)) * math.Pi / 2
+		}
+		ray := (linear.Vec2{1, 0}).Rotate(wallAngle)
+		right := ray.Cross().Scale(-options.WallThickness)
+		q := p.Add(ray.Scale(length))
+		wall := linear.Poly{p, q, q.Add(right), p.Add(right)}
+		if wall.IsCounterClockwise() {
+			wall = linear.Poly{p, p.Add(right), q.Add(right), q}
+		}
+		orbit := sym.polyOrbit(wall)
+		good := true
+		for i, image := range orbit {
+			for _, v := range image {
+				if !inside(v) {
+					good = false
+				}
+			}
+			for _, lane := range lanes {
+				for j := range image {
+					if distBetweenSegs(image.Seg(j), lane) < options.LaneWidth/2 {
+						good = false
+					}
+				}
+			}
+			for _, v := range keepClear {
+				if distFromPointToPoly(v, image) < 100 {
+					good = false
+				}
+			}
+			for _, other := range append(walls, orbit[i+1:]...) {
+				if distBetweenPolys(image, other) < options.LaneWidth/2 {
+					good = false
+				}
+			}
+			if !good {
+				break
+			}
+		}
+		if !good {
+			continue
+		}
+		walls = append(walls, orbit...)
+		placed++
+	}
+	for _, wall := range walls {
+		room.Walls[g.newId()] = wall
+	}
+	room.Walls[g.newId()] = linear.Poly{
+		linear.Vec2{0, 0},
+		linear.Vec2{dx, 0},
+		linear.Vec2{dx, dy},
+		linear.Vec2{0, dy},
+	}
+	room.NextId = g.nextId
+
+	// Each color gets a contested seed in the center and a symmetric set
+	// further out.
+	contested := r.Intn(3)
+	room.Mana.Seeds = append(room.Mana.Seeds, ManaSeed{center, contested})
+	for color := 0; color < 3; color++ {
+		for n := 0; n < 2; n++ {
+			for sanity := 100; sanity > 0; sanity-- {
+				orbit := sym.orbit(linear.Vec2{r.Float64() * dx, r.Float64() * dy})
+				good := true
+				for _, v := range orbit {
+					if !inside(v) {
+						good = false
+					}
+				}
+				if good {
+					for _, v := range orbit {
+						room.Mana.Seeds = append(room.Mana.Seeds, ManaSeed{v, color})
+					}
+					break
+				}
+			}
+		}
+	}
+	return room
+}
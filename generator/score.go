@@ -0,0 +1,125 @@
+package generator
+
+import (
+	"github.com/runningwild/linear"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// RoomStats are measurements of a room that are useful for deciding how good
+// it is.
+type RoomStats struct {
+	// Fraction of the room that the largest ent can get to.
+	OpenArea float64
+
+	// Fraction of the open area that is still open when the room is turned
+	// halfway around or reflected, whichever matches the best.
+	Symmetry float64
+
+	// Number of passages between walls that are narrower than twice the
+	// narrowest a corridor can be.
+	ChokePoints int
+}
+
+// Measure returns the stats for room, using the same options that Validate
+// does.
+func Measure(room Room, options ValidateOptions) RoomStats {
+	options.setDefaults()
+	var walls, all []linear.Poly
+	for _, wall := range room.Walls {
+		if len(wall) < 3 {
+			continue
+		}
+		all = append(all, wall)
+		if !IsBoundary(wall, room.Dx, room.Dy) {
+			walls = append(walls, wall)
+		}
+	}
+	var stats RoomStats
+	stats.ChokePoints = len(passages(walls, all, 2*options.MinCorridorWidth))
+
+	grid := makeReachGrid(&room, walls, all, &options)
+	open := 0
+	matches := [3]int{}
+	for x := 0; x < grid.dx; x++ {
+		for y := 0; y < grid.dy; y++ {
+			if !grid.open[x*grid.dy+y] {
+				continue
+			}
+			open++
+			rx, ry := grid.dx-1-x, grid.dy-1-y
+			for i, cell := range [3]int{rx*grid.dy + ry, rx*grid.dy + y, x*grid.dy + ry} {
+				if grid.open[cell] {
+					matches[i]++
+				}
+			}
+		}
+	}
+	if open == 0 {
+		return stats
+	}
+	stats.OpenArea = float64(open) / float64(len(grid.open))
+	for _, match := range matches {
+		stats.Symmetry = math.Max(stats.Symmetry, float64(match)/float64(open))
+	}
+	return stats
+}
+
+// DefaultScore scores rooms that have no problems by how much open space they
+// have, how symmetric they are and how few choke points they have.  Rooms with
+// problems always get the lowest possible score.
+func DefaultScore(room Room) float64 {
+	var options ValidateOptions
+	if len(Validate(room, options)) > 0 {
+		return math.Inf(-1)
+	}
+	stats := Measure(room, options)
+	return stats.OpenArea + stats.Symmetry - 0.02*float64(stats.ChokePoints)
+}
+
+// GenerateBest calls generate with candidates different Generators at once
+// and returns the room that score gives the highest score to, along with the
+// seed that generated it.  The seeds for the Generators only depend on seed,
+// and ties go to the earliest candidate, so the result is the same no matter
+// how the candidates happen to be scheduled.  Both generate and score are
+// called from many goroutines at once.
+func GenerateBest(seed int64, candidates int, generate func(g *Generator) Room, score func(room Room) float64) (Room, int64) {
+	if candidates < 1 {
+		candidates = 1
+	}
+	seeds := MakeGenerator(seed)
+	gens := make([]*Generator, candidates)
+	gens[0] = MakeGenerator(seeds.Seed())
+	for i := 1; i < candidates; i++ {
+		gens[i] = MakeGenerator(seeds.rng.Int63())
+	}
+
+	rooms := make([]Room, candidates)
+	scores := make([]float64, candidates)
+	next := make(chan int, candidates)
+	for i := range gens {
+		next <- i
+	}
+	close(next)
+	var wg sync.WaitGroup
+	for worker := 0; worker < runtime.NumCPU() && worker < candidates; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				rooms[i] = generate(gens[i])
+				scores[i] = score(rooms[i])
+			}
+		}()
+	}
+	wg.Wait()
+
+	best := 0
+	for i := range scores {
+		if scores[i] > scores[best] {
+			best = i
+		}
+	}
+	return rooms[best], gens[best].Seed()
+}
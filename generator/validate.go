@@ -0,0 +1,386 @@
+package generator
+
+import (
+	"fmt"
+	"github.com/runningwild/linear"
+	"github.com/runningwild/magnus/base"
+	"github.com/runningwild/magnus/los"
+	"math"
+	"sort"
+)
+
+type ValidateOptions struct {
+	// Radius of the largest ent that needs to be able to get around the room,
+	// defaults to the size of a player.
+	EntSize float64
+
+	// Walls that are closer together than this, but not touching, make a
+	// corridor that is too narrow.  Defaults to four times EntSize.
+	MinCorridorWidth float64
+
+	// Reachability is checked on a grid this fine.
+	GridSize float64
+}
+
+func (o *ValidateOptions) setDefaults() {
+	if o.EntSize == 0 {
+		o.EntSize = 12
+	}
+	if o.MinCorridorWidth == 0 {
+		o.MinCorridorWidth = 4 * o.EntSize
+	}
+	if o.GridSize == 0 {
+		o.GridSize = 8
+	}
+}
+
+// A Problem is something that makes a room unplayable, or at least unpleasant
+// to play in.
+type Problem struct {
+	Pos linear.Vec2
+	Msg string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("(%.0f, %.0f): %s", p.Pos.X, p.Pos.Y, p.Msg)
+}
+
+// IsBoundary returns true if poly is the outline of a dx by dy room rather
+// than a wall in it.  The outline is the only wall that should be
+// counter-clockwise.
+func IsBoundary(poly linear.Poly, dx, dy int) bool {
+	if !poly.IsCounterClockwise() {
+		return false
+	}
+	for _, v := range poly {
+		if v.X != 0 && v.Y != 0 && v.X != float64(dx) && v.Y != float64(dy) {
+			return false
+		}
+	}
+	return true
+}
+
+func selfIntersects(poly linear.Poly) bool {
+	for i := range poly {
+		for j := i + 2; j < len(poly); j++ {
+			if i == 0 && j == len(poly)-1 {
+				continue
+			}
+			if poly.Seg(i).DoesIsect(poly.Seg(j)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Centroid returns the average of the vertices of poly.
+func Centroid(poly linear.Poly) linear.Vec2 {
+	var center linear.Vec2
+	if len(poly) == 0 {
+		return center
+	}
+	for _, v := range poly {
+		center = center.Add(v)
+	}
+	return center.Scale(1 / float64(len(poly)))
+}
+
+func insideAny(v linear.Vec2, walls []linear.Poly) bool {
+	for _, wall := range walls {
+		if los.PolyContains(wall, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// isOpen returns true if v isn't inside of, or on the edge of, any wall.
+func isOpen(v linear.Vec2, walls, all []linear.Poly) bool {
+	if insideAny(v, walls) {
+		return false
+	}
+	for _, wall := range all {
+		for i := range wall {
+			if distFromPointToSeg(v, wall.Seg(i)) < 1 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// objective is a position that players need to be able to get to.
+type objective struct {
+	pos  linear.Vec2
+	name string
+}
+
+func objectives(room *Room) []objective {
+	var objs []objective
+	for i, start := range room.Starts {
+		objs = append(objs, objective{start, fmt.Sprintf("start %d", i)})
+	}
+	if room.End != (linear.Vec2{}) {
+		objs = append(objs, objective{room.End, "end"})
+	}
+	base.DoOrdered(room.Portals, func(a, b string) bool { return a < b }, func(id string, portal Portal) {
+		objs = append(objs, objective{Centroid(portal.Region), fmt.Sprintf("portal %s", id)})
+	})
+	for side, data := range room.Moba.SideData {
+		if data.Base != (linear.Vec2{}) {
+			objs = append(objs, objective{data.Base, fmt.Sprintf("base for side %d", side)})
+		}
+		for i, tower := range data.Towers {
+			objs = append(objs, objective{tower, fmt.Sprintf("control point %d for side %d", i, side)})
+		}
+	}
+	return objs
+}
+
+// Validate checks that room is playable: every wall is wound the right way and
+// doesn't intersect itself, nothing leaves a corridor that is too narrow, and
+// an ent of the largest size can get from the first start to every other
+// start, control point, base, portal and the end.  It returns everything that
+// is wrong with the room, which is nothing if it is playable.
+func Validate(room Room, options ValidateOptions) []Problem {
+	options.setDefaults()
+	var problems []Problem
+	var ids []string
+	for id := range room.Walls {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	// Winding and self-intersection.  Ents only collide with the front of a
+	// wall, so a counter-clockwise wall can be walked through from the outside.
+	var walls []linear.Poly
+	var all []linear.Poly
+	for _, id := range ids {
+		wall := room.Walls[id]
+		if len(wall) < 3 {
+			problems = append(problems, Problem{Centroid(wall), fmt.Sprintf("wall %s has only %d vertices", id, len(wall))})
+			continue
+		}
+		if selfIntersects(wall) {
+			problems = append(problems, Problem{wall[0], fmt.Sprintf("wall %s intersects itself", id)})
+		}
+		all = append(all, wall)
+		if IsBoundary(wall, room.Dx, room.Dy) {
+			continue
+		}
+		if wall.IsCounterClockwise() {
+			problems = append(problems, Problem{wall[0], fmt.Sprintf("wall %s is counter-clockwise, ents can walk through it", id)})
+		}
+		walls = append(walls, wall)
+	}
+
+	for _, gap := range passages(walls, all, options.MinCorridorWidth) {
+		problems = append(problems, Problem{gap.mid, fmt.Sprintf("gap between walls is only %.0f wide", gap.width)})
+	}
+
+	// Reachability.  A cell is open if an ent can stand somewhere in it, close
+	// enough to its center.
+	grid := makeReachGrid(&room, walls, all, &options)
+	objs := objectives(&room)
+	if len(objs) == 0 {
+		return problems
+	}
+	cells := make([][]int, len(objs))
+	for i, obj := range objs {
+		if insideAny(obj.pos, walls) {
+			problems = append(problems, Problem{obj.pos, fmt.Sprintf("%s is inside of a wall", obj.name)})
+		}
+		cells[i] = grid.near(obj.pos, 2*options.EntSize)
+		if len(cells[i]) == 0 {
+			problems = append(problems, Problem{obj.pos, fmt.Sprintf("%s has no room around it", obj.name)})
+		}
+	}
+	if len(cells[0]) == 0 {
+		return problems
+	}
+	reached := grid.flood(cells[0])
+	for i, obj := range objs[1:] {
+		ok := len(cells[i+1]) == 0
+		for _, cell := range cells[i+1] {
+			ok = ok || reached[cell]
+		}
+		if !ok {
+			problems = append(problems, Problem{obj.pos, fmt.Sprintf("%s can't be reached from %s", obj.name, objs[0].name)})
+		}
+	}
+	return problems
+}
+
+// A passage is a gap between two walls that ents can go through.
+type passage struct {
+	mid   linear.Vec2
+	width float64
+}
+
+// passages returns every passage between walls that is narrower than
+// maxWidth.  Walls that touch, or overlap, don't leave a gap at all.  A gap is
+// only a passage if there is open space on either side of it, otherwise it's
+// filled in by another wall or it's just the corner of a room.
+func passages(walls, all []linear.Poly, maxWidth float64) []passage {
+	var ret []passage
+	for i := range all {
+		for j := i + 1; j < len(all); j++ {
+			dist, gap := gapBetween(all[i], all[j])
+			if dist == 0 || dist >= maxWidth {
+				continue
+			}
+			mid := gap.P.Add(gap.Q).Scale(0.5)
+			side := gap.Ray().Cross().Norm().Scale(dist / 2)
+			if isOpen(mid, walls, all) && isOpen(mid.Add(side), walls, all) && isOpen(mid.Sub(side), walls, all) {
+				ret = append(ret, passage{mid, dist})
+			}
+		}
+	}
+	return ret
+}
+
+// gapBetween returns the distance between the edges of two walls and the
+// shortest segment across that gap.  It is 0 if the edges touch.
+func gapBetween(a, b linear.Poly) (float64, linear.Seg2) {
+	dist := math.Inf(1)
+	var gap linear.Seg2
+	for i := range a {
+		for j := range b {
+			sa, sb := a.Seg(i), b.Seg(j)
+			if sa.DoesIsect(sb) {
+				return 0, linear.Seg2{sa.P, sa.P}
+			}
+			for _, end := range []struct {
+				v   linear.Vec2
+				seg linear.Seg2
+			}{{sa.P, sb}, {sa.Q, sb}, {sb.P, sa}, {sb.Q, sa}} {
+				if d := distFromPointToSeg(end.v, end.seg); d < dist {
+					dist = d
+					gap = linear.Seg2{end.v, closestPointOnSeg(end.v, end.seg)}
+				}
+			}
+		}
+	}
+	return dist, gap
+}
+
+func closestPointOnSeg(v linear.Vec2, seg linear.Seg2) linear.Vec2 {
+	ray := seg.Ray()
+	if ray.Mag2() == 0 {
+		return seg.P
+	}
+	t := v.Sub(seg.P).Dot(ray) / ray.Mag2()
+	t = math.Max(0, math.Min(1, t))
+	return seg.P.Add(ray.Scale(t))
+}
+
+type reachGrid struct {
+	dx, dy int
+	size   float64
+	open   []bool
+}
+
+func makeReachGrid(room *Room, walls, all []linear.Poly, options *ValidateOptions) *reachGrid {
+	g := &reachGrid{
+		dx:   int(math.Ceil(float64(room.Dx) / options.GridSize)),
+		dy:   int(math.Ceil(float64(room.Dy) / options.GridSize)),
+		size: options.GridSize,
+	}
+	g.open = make([]bool, g.dx*g.dy)
+	for x := 0; x < g.dx; x++ {
+		for y := 0; y < g.dy; y++ {
+			v := g.center(x*g.dy + y)
+			// Cells are only checked at their centers, so give them half a cell of
+			// slack or corridors that are just wide enough might look closed.
+			clear := options.EntSize - g.size/2
+			open := v.X >= clear && v.Y >= clear &&
+				v.X <= float64(room.Dx)-clear && v.Y <= float64(room.Dy)-clear
+			open = open && !insideAny(v, walls)
+			for _, wall := range all {
+				for i := range wall {
+					if !open {
+						break
+					}
+					open = distFromPointToSeg(v, wall.Seg(i)) >= clear
+				}
+			}
+			g.open[x*g.dy+y] = open
+		}
+	}
+	return g
+}
+
+func (g *reachGrid) center(cell int) linear.Vec2 {
+	return linear.Vec2{(float64(cell/g.dy) + 0.5) * g.size, (float64(cell%g.dy) + 0.5) * g.size}
+}
+
+// near returns every open cell whose center is within dist of v.
+func (g *reachGrid) near(v linear.Vec2, dist float64) []int {
+	var cells []int
+	r := int(dist/g.size) + 1
+	cx, cy := int(v.X/g.size), int(v.Y/g.size)
+	for x := cx - r; x <= cx+r; x++ {
+		for y := cy - r; y <= cy+r; y++ {
+			if x < 0 || y < 0 || x >= g.dx || y >= g.dy {
+				continue
+			}
+			cell := x*g.dy + y
+			if g.open[cell] && g.center(cell).Sub(v).Mag() <= dist {
+				cells = append(cells, cell)
+			}
+		}
+	}
+	return cells
+}
+
+// flood returns every open cell that can be reached from starts.
+func (g *reachGrid) flood(starts []int) map[int]bool {
+	reached := make(map[int]bool)
+	queue := append([]int(nil), starts...)
+	for _, cell := range starts {
+		reached[cell] = true
+	}
+	for len(queue) > 0 {
+		cell := queue[0]
+		queue = queue[1:]
+		x, y := cell/g.dy, cell%g.dy
+		for _, d := range [][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+			nx, ny := x+d[0], y+d[1]
+			if nx < 0 || ny < 0 || nx >= g.dx || ny >= g.dy {
+				continue
+			}
+			next := nx*g.dy + ny
+			if g.open[next] && !reached[next] {
+				reached[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return reached
+}
+
+// GenerateValid calls generate with a Generator made from seed and checks the
+// room that it returns, retrying with Generators made from new seeds until it
+// gets a room with no problems or it has tried tries times.  It returns the
+// first playable room, or the last room it tried along with its problems.  The
+// seeds it tries only depend on seed, so this is just as deterministic as
+// generate is.
+func GenerateValid(seed int64, tries int, options ValidateOptions, generate func(g *Generator) Room) (Room, []Problem) {
+	seeds := MakeGenerator(seed)
+	seed = seeds.Seed()
+	var room Room
+	var problems []Problem
+	for try := 0; try < tries; try++ {
+		g := MakeGenerator(seed)
+		seed = seeds.rng.Int63()
+		room = generate(g)
+		problems = Validate(room, options)
+		if len(problems) == 0 {
+			return room, nil
+		}
+		base.Warn().Printf("Room from seed %d has %d problems, the first is %v", g.Seed(), len(problems), problems[0])
+	}
+	base.Error().Printf("Unable to generate a playable room in %d tries", tries)
+	return room, problems
+}
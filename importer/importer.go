@@ -0,0 +1,355 @@
+// Package importer makes rooms from drawings made in other tools, so that
+// rooms can be drawn instead of typed in as coordinates.  SVG files, from
+// Inkscape for example, and Tiled's TMX files are supported.
+//
+// Every shape is something in the room depending on its name, or the name of
+// the layer that it is in if its own name doesn't mean anything.  Names are a
+// role followed by an optional number, like "start 2" or "tower1":
+//
+//	wall         a wall, this is the default for anything without a role
+//	start N      where players start, in order of N
+//	end, exit    where the room ends
+//	portal N     a region that leads to level N
+//	base N       the base for side N in a moba room
+//	tower N      a tower for side N
+//	lane N       waypoints along a lane for side N, starting at the base
+//	control      a neutral control point in a moba room
+//	mana N       a mana seed of color N, from 0 to 2
+//	hazard       a region that does something to ents inside it
+//
+// Anything that can't be used, like curves or text, is reported as a Problem
+// and left out of the room.
+package importer
+
+import (
+	"fmt"
+	"github.com/runningwild/linear"
+	"github.com/runningwild/magnus/generator"
+	"github.com/runningwild/magnus/stats"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type Options struct {
+	// Coordinates in the file are multiplied by Scale, 1 is used if this is 0.
+	Scale float64
+}
+
+func (o *Options) setDefaults() {
+	if o.Scale == 0 {
+		o.Scale = 1
+	}
+}
+
+// A Problem is something in the file that couldn't be imported.
+type Problem struct {
+	// Name of the shape or layer that has the problem.
+	Name string
+	Msg  string
+}
+
+func (p Problem) String() string {
+	if p.Name == "" {
+		return p.Msg
+	}
+	return fmt.Sprintf("%s: %s", p.Name, p.Msg)
+}
+
+type shapeKind int
+
+const (
+	shapePoint shapeKind = iota
+	shapePolygon
+	shapePolyline
+)
+
+func (k shapeKind) String() string {
+	switch k {
+	case shapePoint:
+		return "point"
+	case shapePolygon:
+		return "polygon"
+	}
+	return "polyline"
+}
+
+// A shape is anything from the file that might be part of the room, in the
+// file's coordinates.  Properties are used for hazards.
+type shape struct {
+	kind   shapeKind
+	points []linear.Vec2
+
+	// Names that might say what the shape is, most specific first.
+	names []string
+	props map[string]string
+}
+
+// label is the name that problems with the shape are reported under.
+func (s *shape) label() string {
+	for _, name := range s.names {
+		if name != "" {
+			return name
+		}
+	}
+	return s.kind.String()
+}
+
+var roles = map[string]string{
+	"wall":    "wall",
+	"walls":   "wall",
+	"start":   "start",
+	"starts":  "start",
+	"end":     "end",
+	"exit":    "end",
+	"portal":  "portal",
+	"portals": "portal",
+	"base":    "base",
+	"bases":   "base",
+	"tower":   "tower",
+	"towers":  "tower",
+	"lane":    "lane",
+	"lanes":   "lane",
+	"control": "control",
+	"mana":    "mana",
+	"hazard":  "hazard",
+	"hazards": "hazard",
+}
+
+// parseRole splits a name like "Tower 2" into its role and number.  ok is
+// false if the name doesn't start with a role.
+func parseRole(name string) (role string, num int, ok bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	end := strings.IndexFunc(name, func(r rune) bool { return !unicode.IsLetter(r) })
+	if end == -1 {
+		end = len(name)
+	}
+	role, ok = roles[name[:end]]
+	if !ok {
+		return "", 0, false
+	}
+	rest := strings.TrimLeftFunc(name[end:], func(r rune) bool { return !unicode.IsDigit(r) })
+	if rest != "" {
+		digits := strings.IndexFunc(rest, func(r rune) bool { return !unicode.IsDigit(r) })
+		if digits != -1 {
+			rest = rest[:digits]
+		}
+		num, _ = strconv.Atoi(rest)
+	}
+	return role, num, true
+}
+
+// role returns what the shape is, from the first of its names that has a
+// role.
+func (s *shape) role() (string, int) {
+	for _, name := range s.names {
+		if role, num, ok := parseRole(name); ok {
+			return role, num
+		}
+	}
+	return "wall", 0
+}
+
+func (s *shape) center() linear.Vec2 {
+	return generator.Centroid(s.points)
+}
+
+var damageKinds = map[string]stats.DamageKind{
+	"fire":     stats.DamageFire,
+	"acid":     stats.DamageAcid,
+	"crushing": stats.DamageCrushing,
+}
+
+// hazard makes a hazard from the shape's properties: damage, kind, acc,
+// friction, blockvision and hidden.
+func (s *shape) hazard() (*generator.Hazard, []Problem) {
+	var problems []Problem
+	hazard := &generator.Hazard{Region: s.points}
+	for _, key := range sortedKeys(s.props) {
+		value := s.props[key]
+		var err error
+		switch strings.ToLower(key) {
+		case "damage":
+			hazard.Damage.Amt, err = strconv.ParseFloat(value, 64)
+		case "kind":
+			kind, ok := damageKinds[strings.ToLower(value)]
+			if !ok {
+				err = fmt.Errorf("expected fire, acid or crushing")
+			}
+			hazard.Damage.Kind = kind
+		case "acc":
+			hazard.AccMultiplier, err = strconv.ParseFloat(value, 64)
+		case "friction":
+			hazard.Friction, err = strconv.ParseFloat(value, 64)
+		case "blockvision":
+			hazard.BlockVision, err = strconv.ParseBool(value)
+		case "hidden":
+			hazard.Hidden, err = strconv.ParseBool(value)
+		default:
+			continue
+		}
+		if err != nil {
+			problems = append(problems, Problem{s.label(), fmt.Sprintf("bad %s %q: %v", key, value, err)})
+		}
+	}
+	return hazard, problems
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type numberedPoint struct {
+	num int
+	pos linear.Vec2
+}
+
+// build makes a room that is dx by dy from shapes.  Shapes are in the file's
+// coordinates, where y goes down, and are flipped so that the room looks the
+// same as the drawing.
+func build(dx, dy float64, shapes []shape, options Options) (generator.Room, []Problem) {
+	var problems []Problem
+	var room generator.Room
+	room.Dx = int(math.Ceil(dx * options.Scale))
+	room.Dy = int(math.Ceil(dy * options.Scale))
+	room.Walls = make(map[string]linear.Poly)
+	room.Portals = make(map[string]generator.Portal)
+	room.Hazards = make(map[string]*generator.Hazard)
+	// NextId is always the next free id, like game.Room.AddWall expects.
+	room.NextId = 1
+	newId := func() string {
+		id := fmt.Sprintf("%d", room.NextId)
+		room.NextId++
+		return id
+	}
+
+	var starts []numberedPoint
+	var controlPoints []linear.Vec2
+	hasEnd := false
+	side := func(num int) int {
+		for len(room.Moba.SideData) <= num {
+			room.Moba.SideData = append(room.Moba.SideData, generator.MobaRoomSideData{})
+		}
+		return num
+	}
+	for i := range shapes {
+		s := &shapes[i]
+		for j, v := range s.points {
+			s.points[j] = linear.Vec2{v.X * options.Scale, (dy - v.Y) * options.Scale}
+		}
+		role, num := s.role()
+		need := func(kinds ...shapeKind) bool {
+			for _, kind := range kinds {
+				if s.kind == kind {
+					return true
+				}
+			}
+			problems = append(problems, Problem{s.label(), fmt.Sprintf("a %s can't be a %s", s.kind, role)})
+			return false
+		}
+		switch role {
+		case "wall":
+			if !need(shapePolygon) {
+				continue
+			}
+			if len(s.points) < 3 {
+				problems = append(problems, Problem{s.label(), "walls need at least three points"})
+				continue
+			}
+			wall := linear.Poly(s.points)
+			if wall.IsCounterClockwise() {
+				for a, b := 0, len(wall)-1; a < b; a, b = a+1, b-1 {
+					wall[a], wall[b] = wall[b], wall[a]
+				}
+			}
+			room.Walls[newId()] = wall
+
+		case "start":
+			starts = append(starts, numberedPoint{num, s.center()})
+
+		case "end":
+			if hasEnd {
+				problems = append(problems, Problem{s.label(), "there is already an end"})
+				continue
+			}
+			hasEnd = true
+			room.End = s.center()
+
+		case "portal":
+			if !need(shapePolygon) {
+				continue
+			}
+			room.Portals[newId()] = generator.Portal{Region: s.points, Dest: num}
+
+		case "base":
+			room.Moba.SideData[side(num)].Base = s.center()
+
+		case "tower":
+			data := &room.Moba.SideData[side(num)]
+			data.Towers = append(data.Towers, s.center())
+
+		case "lane":
+			if !need(shapePolyline, shapePolygon) {
+				continue
+			}
+			data := &room.Moba.SideData[side(num)]
+			data.Lanes = append(data.Lanes, s.points)
+
+		case "control":
+			controlPoints = append(controlPoints, s.center())
+
+		case "mana":
+			if num < 0 || num > 2 {
+				problems = append(problems, Problem{s.label(), fmt.Sprintf("mana colors are 0 to 2, not %d", num)})
+				continue
+			}
+			room.Mana.Seeds = append(room.Mana.Seeds, generator.ManaSeed{Pos: s.center(), Color: num})
+
+		case "hazard":
+			if !need(shapePolygon) {
+				continue
+			}
+			hazard, hazardProblems := s.hazard()
+			problems = append(problems, hazardProblems...)
+			room.Hazards[newId()] = hazard
+		}
+	}
+
+	// The game expects the neutral side to come after every other side, its
+	// towers are the control points.
+	if len(room.Moba.SideData) > 0 || len(controlPoints) > 0 {
+		room.Moba.SideData = append(room.Moba.SideData, generator.MobaRoomSideData{Towers: controlPoints})
+	}
+
+	sort.Stable(startsByNum(starts))
+	for _, start := range starts {
+		room.Starts = append(room.Starts, start.pos)
+	}
+	if len(room.Portals) == 0 {
+		room.Portals = nil
+	}
+	if len(room.Hazards) == 0 {
+		room.Hazards = nil
+	}
+
+	room.Walls[newId()] = linear.Poly{
+		linear.Vec2{0, 0},
+		linear.Vec2{float64(room.Dx), 0},
+		linear.Vec2{float64(room.Dx), float64(room.Dy)},
+		linear.Vec2{0, float64(room.Dy)},
+	}
+	return room, problems
+}
+
+type startsByNum []numberedPoint
+
+func (s startsByNum) Len() int           { return len(s) }
+func (s startsByNum) Less(i, j int) bool { return s[i].num < s[j].num }
+func (s startsByNum) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,407 @@
+package importer
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/runningwild/linear"
+	"github.com/runningwild/magnus/generator"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type xmlNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Nodes   []xmlNode  `xml:",any"`
+}
+
+func (n *xmlNode) attr(name string) string {
+	for _, attr := range n.Attrs {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// name is what Inkscape shows for an element, its label if it has one and
+// otherwise its id.
+func (n *xmlNode) name() string {
+	if label := n.attr("label"); label != "" {
+		return label
+	}
+	return n.attr("id")
+}
+
+// props are the element's data- attributes, without the data-.
+func (n *xmlNode) props() map[string]string {
+	props := make(map[string]string)
+	for _, attr := range n.Attrs {
+		if strings.HasPrefix(attr.Name.Local, "data-") {
+			props[strings.TrimPrefix(attr.Name.Local, "data-")] = attr.Value
+		}
+	}
+	return props
+}
+
+// An affine transform, applied as x' = a*x + c*y + e, y' = b*x + d*y + f like
+// in SVG.
+type transform [6]float64
+
+var identity = transform{1, 0, 0, 1, 0, 0}
+
+func (t transform) apply(v linear.Vec2) linear.Vec2 {
+	return linear.Vec2{t[0]*v.X + t[2]*v.Y + t[4], t[1]*v.X + t[3]*v.Y + t[5]}
+}
+
+// then returns the transform that does t and then u.
+func (t transform) then(u transform) transform {
+	return transform{
+		u[0]*t[0] + u[2]*t[1],
+		u[1]*t[0] + u[3]*t[1],
+		u[0]*t[2] + u[2]*t[3],
+		u[1]*t[2] + u[3]*t[3],
+		u[0]*t[4] + u[2]*t[5] + u[4],
+		u[1]*t[4] + u[3]*t[5] + u[5],
+	}
+}
+
+// parseNumbers returns every number in s, which can be separated by spaces or
+// commas.
+func parseNumbers(s string) ([]float64, error) {
+	var nums []float64
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		num, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, err
+		}
+		nums = append(nums, num)
+	}
+	return nums, nil
+}
+
+// parseTransform parses the transform attribute of an element.
+func parseTransform(s string) (transform, error) {
+	t := identity
+	s = strings.TrimSpace(s)
+	for s != "" {
+		open := strings.Index(s, "(")
+		close := strings.Index(s, ")")
+		if open == -1 || close < open {
+			return t, fmt.Errorf("bad transform %q", s)
+		}
+		name := strings.TrimSpace(strings.Trim(s[:open], ", \t\n"))
+		args, err := parseNumbers(s[open+1 : close])
+		if err != nil {
+			return t, err
+		}
+		s = strings.TrimSpace(strings.TrimLeft(s[close+1:], ", \t\n"))
+		for len(args) < 6 {
+			args = append(args, 0)
+		}
+		var u transform
+		switch name {
+		case "matrix":
+			copy(u[:], args)
+		case "translate":
+			u = transform{1, 0, 0, 1, args[0], args[1]}
+		case "scale":
+			if args[1] == 0 {
+				args[1] = args[0]
+			}
+			u = transform{args[0], 0, 0, args[1], 0, 0}
+		case "rotate":
+			a := args[0] * math.Pi / 180
+			cos, sin := math.Cos(a), math.Sin(a)
+			cx, cy := args[1], args[2]
+			u = transform{cos, sin, -sin, cos, cx - cos*cx + sin*cy, cy - sin*cx - cos*cy}
+		case "skewX":
+			u = transform{1, 0, math.Tan(args[0] * math.Pi / 180), 1, 0, 0}
+		case "skewY":
+			u = transform{1, math.Tan(args[0] * math.Pi / 180), 0, 1, 0, 0}
+		default:
+			return t, fmt.Errorf("unknown transform %q", name)
+		}
+		// Transforms in the list are applied right to left.
+		t = u.then(t)
+	}
+	return t, nil
+}
+
+// parseLength parses a length in user units, like "100" or "100px".
+func parseLength(s string) (float64, error) {
+	s = strings.TrimSuffix(strings.TrimSpace(s), "px")
+	return strconv.ParseFloat(s, 64)
+}
+
+// parsePath parses path data made of straight lines.  Each subpath is
+// returned separately, along with whether it was closed.
+func parsePath(d string) ([][]linear.Vec2, []bool, error) {
+	var paths [][]linear.Vec2
+	var closed []bool
+	var cur, start linear.Vec2
+	var cmd byte
+	i := 0
+	number := func() (float64, error) {
+		for i < len(d) && (d[i] == ',' || unicode.IsSpace(rune(d[i]))) {
+			i++
+		}
+		j := i
+		if j < len(d) && (d[j] == '-' || d[j] == '+') {
+			j++
+		}
+		dot, exp := false, false
+		for j < len(d) {
+			c := d[j]
+			switch {
+			case c >= '0' && c <= '9':
+			case c == '.' && !dot && !exp:
+				dot = true
+			case (c == 'e' || c == 'E') && !exp:
+				exp = true
+				if j+1 < len(d) && (d[j+1] == '-' || d[j+1] == '+') {
+					j++
+				}
+			default:
+				goto done
+			}
+			j++
+		}
+	done:
+		num, err := strconv.ParseFloat(d[i:j], 64)
+		i = j
+		return num, err
+	}
+	for {
+		for i < len(d) && (d[i] == ',' || unicode.IsSpace(rune(d[i]))) {
+			i++
+		}
+		if i >= len(d) {
+			break
+		}
+		if c := d[i]; unicode.IsLetter(rune(c)) {
+			cmd = c
+			i++
+		} else if cmd == 0 {
+			return nil, nil, fmt.Errorf("path doesn't start with a command")
+		}
+		relative := cmd >= 'a'
+		var next linear.Vec2
+		if relative {
+			next = cur
+		}
+		switch cmd {
+		case 'M', 'm', 'L', 'l':
+			x, err := number()
+			if err != nil {
+				return nil, nil, err
+			}
+			y, err := number()
+			if err != nil {
+				return nil, nil, err
+			}
+			next = next.Add(linear.Vec2{x, y})
+			if cmd == 'M' || cmd == 'm' {
+				paths = append(paths, nil)
+				closed = append(closed, false)
+				start = next
+				// Coordinates after a move are lines.
+				cmd -= 'M' - 'L'
+			}
+		case 'H', 'h':
+			x, err := number()
+			if err != nil {
+				return nil, nil, err
+			}
+			next = linear.Vec2{next.X + x, cur.Y}
+		case 'V', 'v':
+			y, err := number()
+			if err != nil {
+				return nil, nil, err
+			}
+			next = linear.Vec2{cur.X, next.Y + y}
+		case 'Z', 'z':
+			if len(paths) > 0 {
+				closed[len(closed)-1] = true
+			}
+			cur = start
+			cmd = 0
+			continue
+		default:
+			return nil, nil, fmt.Errorf("only straight lines are supported, not %q", string(cmd))
+		}
+		if len(paths) == 0 {
+			return nil, nil, fmt.Errorf("path doesn't start with a move")
+		}
+		cur = next
+		last := len(paths) - 1
+		paths[last] = append(paths[last], cur)
+	}
+	// Closed paths often end where they started, the polygon doesn't need both.
+	for i, path := range paths {
+		if closed[i] && len(path) > 1 && path[0].Sub(path[len(path)-1]).Mag() < 1e-9 {
+			paths[i] = path[:len(path)-1]
+		}
+	}
+	return paths, closed, nil
+}
+
+type svgImporter struct {
+	shapes   []shape
+	problems []Problem
+}
+
+func (im *svgImporter) problem(name string, format string, args ...interface{}) {
+	im.problems = append(im.problems, Problem{name, fmt.Sprintf(format, args...)})
+}
+
+func (im *svgImporter) add(node *xmlNode, names []string, kind shapeKind, t transform, points ...linear.Vec2) {
+	for i := range points {
+		points[i] = t.apply(points[i])
+	}
+	im.shapes = append(im.shapes, shape{
+		kind:   kind,
+		points: points,
+		names:  append([]string{node.name()}, names...),
+		props:  node.props(),
+	})
+}
+
+// walk adds the shapes in node and everything inside of it.  names are the
+// names of the groups that node is in, innermost first.
+func (im *svgImporter) walk(node *xmlNode, names []string, t transform) {
+	if s := node.attr("transform"); s != "" {
+		u, err := parseTransform(s)
+		if err != nil {
+			im.problem(node.name(), "%v", err)
+			return
+		}
+		t = u.then(t)
+	}
+	nums := func(attrs ...string) ([]float64, bool) {
+		var vals []float64
+		for _, attr := range attrs {
+			val := 0.0
+			if s := node.attr(attr); s != "" {
+				var err error
+				val, err = parseLength(s)
+				if err != nil {
+					im.problem(node.name(), "bad %s %q", attr, s)
+					return nil, false
+				}
+			}
+			vals = append(vals, val)
+		}
+		return vals, true
+	}
+	points := func() ([]linear.Vec2, bool) {
+		vals, err := parseNumbers(node.attr("points"))
+		if err != nil || len(vals)%2 != 0 {
+			im.problem(node.name(), "bad points %q", node.attr("points"))
+			return nil, false
+		}
+		var points []linear.Vec2
+		for i := 0; i < len(vals); i += 2 {
+			points = append(points, linear.Vec2{vals[i], vals[i+1]})
+		}
+		return points, true
+	}
+
+	switch node.XMLName.Local {
+	case "svg", "g", "a", "switch":
+		inner := append([]string{node.name()}, names...)
+		if node.XMLName.Local == "svg" {
+			inner = names
+		}
+		for i := range node.Nodes {
+			im.walk(&node.Nodes[i], inner, t)
+		}
+
+	case "rect":
+		if v, ok := nums("x", "y", "width", "height"); ok {
+			x, y, dx, dy := v[0], v[1], v[2], v[3]
+			im.add(node, names, shapePolygon, t, linear.Vec2{x, y}, linear.Vec2{x + dx, y}, linear.Vec2{x + dx, y + dy}, linear.Vec2{x, y + dy})
+		}
+
+	case "polygon":
+		if points, ok := points(); ok {
+			im.add(node, names, shapePolygon, t, points...)
+		}
+
+	case "polyline":
+		if points, ok := points(); ok {
+			im.add(node, names, shapePolyline, t, points...)
+		}
+
+	case "line":
+		if v, ok := nums("x1", "y1", "x2", "y2"); ok {
+			im.add(node, names, shapePolyline, t, linear.Vec2{v[0], v[1]}, linear.Vec2{v[2], v[3]})
+		}
+
+	case "path":
+		paths, closed, err := parsePath(node.attr("d"))
+		if err != nil {
+			im.problem(node.name(), "%v", err)
+			return
+		}
+		for i, path := range paths {
+			kind := shapePolyline
+			if closed[i] {
+				kind = shapePolygon
+			}
+			im.add(node, names, kind, t, path...)
+		}
+
+	case "circle", "ellipse":
+		// Round things are only used for their centers.
+		if v, ok := nums("cx", "cy"); ok {
+			im.add(node, names, shapePoint, t, linear.Vec2{v[0], v[1]})
+		}
+
+	case "defs", "metadata", "namedview", "title", "desc", "style":
+
+	default:
+		im.problem(node.name(), "%s elements aren't supported", node.XMLName.Local)
+	}
+}
+
+// ImportSVG makes a room from an SVG file.  The room is the size of the
+// drawing, and its contents are named as described in the package
+// documentation.
+func ImportSVG(r io.Reader, options Options) (generator.Room, []Problem) {
+	options.setDefaults()
+	var root xmlNode
+	if err := xml.NewDecoder(r).Decode(&root); err != nil {
+		return generator.Room{}, []Problem{{"", fmt.Sprintf("unable to read svg: %v", err)}}
+	}
+	if root.XMLName.Local != "svg" {
+		return generator.Room{}, []Problem{{"", fmt.Sprintf("expected an svg element, not %s", root.XMLName.Local)}}
+	}
+
+	var im svgImporter
+	t := identity
+	var dx, dy float64
+	if box := root.attr("viewBox"); box != "" {
+		vals, err := parseNumbers(box)
+		if err != nil || len(vals) != 4 {
+			return generator.Room{}, []Problem{{"", fmt.Sprintf("bad viewBox %q", box)}}
+		}
+		t = transform{1, 0, 0, 1, -vals[0], -vals[1]}
+		dx, dy = vals[2], vals[3]
+	} else {
+		var err error
+		dx, err = parseLength(root.attr("width"))
+		if err == nil {
+			dy, err = parseLength(root.attr("height"))
+		}
+		if err != nil {
+			return generator.Room{}, []Problem{{"", "the svg needs a viewBox, or a width and height in px"}}
+		}
+	}
+	im.walk(&root, nil, t)
+	room, problems := build(dx, dy, im.shapes, options)
+	return room, append(im.problems, problems...)
+}
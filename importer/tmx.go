@@ -0,0 +1,174 @@
+package importer
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/runningwild/linear"
+	"github.com/runningwild/magnus/generator"
+	"io"
+	"math"
+)
+
+type tmxProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type tmxPoints struct {
+	Points string `xml:"points,attr"`
+}
+
+type tmxObject struct {
+	Name       string        `xml:"name,attr"`
+	Type       string        `xml:"type,attr"`
+	Class      string        `xml:"class,attr"`
+	Gid        int           `xml:"gid,attr"`
+	X          float64       `xml:"x,attr"`
+	Y          float64       `xml:"y,attr"`
+	Width      float64       `xml:"width,attr"`
+	Height     float64       `xml:"height,attr"`
+	Rotation   float64       `xml:"rotation,attr"`
+	Properties []tmxProperty `xml:"properties>property"`
+	Polygon    *tmxPoints    `xml:"polygon"`
+	Polyline   *tmxPoints    `xml:"polyline"`
+	Ellipse    *struct{}     `xml:"ellipse"`
+	Point      *struct{}     `xml:"point"`
+	Text       *struct{}     `xml:"text"`
+}
+
+// tmxLayer is an object group, a tile layer or a group of layers.
+type tmxLayer struct {
+	XMLName    xml.Name
+	Name       string        `xml:"name,attr"`
+	OffsetX    float64       `xml:"offsetx,attr"`
+	OffsetY    float64       `xml:"offsety,attr"`
+	Properties []tmxProperty `xml:"properties>property"`
+	Objects    []tmxObject   `xml:"object"`
+	Layers     []tmxLayer    `xml:",any"`
+}
+
+type tmxMap struct {
+	Width      int        `xml:"width,attr"`
+	Height     int        `xml:"height,attr"`
+	TileWidth  int        `xml:"tilewidth,attr"`
+	TileHeight int        `xml:"tileheight,attr"`
+	Layers     []tmxLayer `xml:",any"`
+}
+
+type tmxImporter struct {
+	shapes   []shape
+	problems []Problem
+}
+
+func (im *tmxImporter) problem(name string, format string, args ...interface{}) {
+	im.problems = append(im.problems, Problem{name, fmt.Sprintf(format, args...)})
+}
+
+func (im *tmxImporter) object(obj *tmxObject, names []string, props map[string]string, offset linear.Vec2) {
+	s := shape{
+		names: append([]string{obj.Type, obj.Class, obj.Name}, names...),
+		props: make(map[string]string),
+	}
+	for key, value := range props {
+		s.props[key] = value
+	}
+	for _, prop := range obj.Properties {
+		s.props[prop.Name] = prop.Value
+	}
+	parse := func(p *tmxPoints) bool {
+		vals, err := parseNumbers(p.Points)
+		if err != nil || len(vals)%2 != 0 {
+			im.problem(s.label(), "bad points %q", p.Points)
+			return false
+		}
+		for i := 0; i < len(vals); i += 2 {
+			s.points = append(s.points, linear.Vec2{vals[i], vals[i+1]})
+		}
+		return true
+	}
+	w, h := obj.Width, obj.Height
+	switch {
+	case obj.Gid != 0:
+		im.problem(s.label(), "tile objects aren't supported")
+		return
+	case obj.Text != nil:
+		im.problem(s.label(), "text objects aren't supported")
+		return
+	case obj.Polygon != nil:
+		if !parse(obj.Polygon) {
+			return
+		}
+		s.kind = shapePolygon
+	case obj.Polyline != nil:
+		if !parse(obj.Polyline) {
+			return
+		}
+		s.kind = shapePolyline
+	case obj.Ellipse != nil:
+		// Round things are only used for their centers.
+		s.kind = shapePoint
+		s.points = []linear.Vec2{{w / 2, h / 2}}
+	case obj.Point != nil || (w == 0 && h == 0):
+		s.kind = shapePoint
+		s.points = []linear.Vec2{{0, 0}}
+	default:
+		s.kind = shapePolygon
+		s.points = []linear.Vec2{{0, 0}, {w, 0}, {w, h}, {0, h}}
+	}
+
+	// Points are relative to the object's position, and the object is rotated
+	// clockwise around it.
+	a := obj.Rotation * math.Pi / 180
+	t := transform{math.Cos(a), math.Sin(a), -math.Sin(a), math.Cos(a), obj.X + offset.X, obj.Y + offset.Y}
+	for i := range s.points {
+		s.points[i] = t.apply(s.points[i])
+	}
+	im.shapes = append(im.shapes, s)
+}
+
+// layer adds the shapes in layer and every layer inside of it.  Properties of
+// a layer are the defaults for the objects in it.
+func (im *tmxImporter) layer(layer *tmxLayer, names []string, props map[string]string, offset linear.Vec2) {
+	offset = offset.Add(linear.Vec2{layer.OffsetX, layer.OffsetY})
+	inner := make(map[string]string)
+	for key, value := range props {
+		inner[key] = value
+	}
+	for _, prop := range layer.Properties {
+		inner[prop.Name] = prop.Value
+	}
+	switch layer.XMLName.Local {
+	case "objectgroup":
+		names = append([]string{layer.Name}, names...)
+		for i := range layer.Objects {
+			im.object(&layer.Objects[i], names, inner, offset)
+		}
+	case "group":
+		names = append([]string{layer.Name}, names...)
+		for i := range layer.Layers {
+			im.layer(&layer.Layers[i], names, inner, offset)
+		}
+	case "layer", "imagelayer":
+		im.problem(layer.Name, "only object layers are imported, this layer is ignored")
+	}
+}
+
+// ImportTMX makes a room from a Tiled map.  The room is the size of the map,
+// and only object layers are used, their objects are named as described in the
+// package documentation.  An object's type is checked for a role before its
+// name.
+func ImportTMX(r io.Reader, options Options) (generator.Room, []Problem) {
+	options.setDefaults()
+	var m tmxMap
+	if err := xml.NewDecoder(r).Decode(&m); err != nil {
+		return generator.Room{}, []Problem{{"", fmt.Sprintf("unable to read tmx: %v", err)}}
+	}
+	var im tmxImporter
+	for i := range m.Layers {
+		im.layer(&m.Layers[i], nil, nil, linear.Vec2{})
+	}
+	dx := float64(m.Width * m.TileWidth)
+	dy := float64(m.Height * m.TileHeight)
+	room, problems := build(dx, dy, im.shapes, options)
+	return room, append(im.problems, problems...)
+}
@@ -0,0 +1,148 @@
+package los
+
+import (
+	"github.com/runningwild/linear"
+	"math"
+)
+
+// A Cell is a single square in a Grid.
+type Cell struct {
+	X, Y int
+}
+
+// A Grid merges what a number of viewers can see into a single grid of cells,
+// for example everything that one side can see.  Each viewer's cells are kept
+// separately so that when one viewer moves only its cells need to be updated.
+type Grid struct {
+	Dx, Dy   int
+	CellSize float64
+
+	// Number of viewers that can see each cell.
+	counts  []int32
+	viewers map[string][]Cell
+}
+
+// MakeGrid makes an empty grid with dx by dy cells, each of which is cellSize
+// on a side.
+func MakeGrid(dx, dy int, cellSize float64) *Grid {
+	return &Grid{
+		Dx:       dx,
+		Dy:       dy,
+		CellSize: cellSize,
+		counts:   make([]int32, dx*dy),
+		viewers:  make(map[string][]Cell),
+	}
+}
+
+func (g *Grid) inside(x, y int) bool {
+	return x >= 0 && y >= 0 && x < g.Dx && y < g.Dy
+}
+
+// SetViewer sets the cells that the viewer id can see, replacing whatever it
+// could see before.  Cells outside of the grid are ignored.
+func (g *Grid) SetViewer(id string, cells []Cell) {
+	g.RemoveViewer(id)
+	kept := make([]Cell, 0, len(cells))
+	for _, c := range cells {
+		if !g.inside(c.X, c.Y) {
+			continue
+		}
+		g.counts[c.X+c.Y*g.Dx]++
+		kept = append(kept, c)
+	}
+	g.viewers[id] = kept
+}
+
+// RemoveViewer removes everything that the viewer id could see.
+func (g *Grid) RemoveViewer(id string) {
+	for _, c := range g.viewers[id] {
+		g.counts[c.X+c.Y*g.Dx]--
+	}
+	delete(g.viewers, id)
+}
+
+// CellVisible returns true if any viewer can see the cell at x, y.
+func (g *Grid) CellVisible(x, y int) bool {
+	return g.inside(x, y) && g.counts[x+y*g.Dx] > 0
+}
+
+// CellAt returns the cell that contains p.
+func (g *Grid) CellAt(p linear.Vec2) Cell {
+	return Cell{int(math.Floor(p.X / g.CellSize)), int(math.Floor(p.Y / g.CellSize))}
+}
+
+// PointVisible returns true if any viewer can see p.
+func (g *Grid) PointVisible(p linear.Vec2) bool {
+	c := g.CellAt(p)
+	return g.CellVisible(c.X, c.Y)
+}
+
+// RegionVisibility returns the number of cells overlapping the rectangle with
+// corners min and max that are visible, and the total number of cells that
+// overlap it.
+func (g *Grid) RegionVisibility(min, max linear.Vec2) (visible, total int) {
+	c0 := g.CellAt(min)
+	c1 := g.CellAt(max)
+	for x := c0.X; x <= c1.X; x++ {
+		for y := c0.Y; y <= c1.Y; y++ {
+			if !g.inside(x, y) {
+				continue
+			}
+			total++
+			if g.counts[x+y*g.Dx] > 0 {
+				visible++
+			}
+		}
+	}
+	return
+}
+
+// PolyVisibility returns the number of cells whose centers are inside of poly
+// that are visible, and the total number of cells whose centers are inside of
+// poly.
+func (g *Grid) PolyVisibility(poly linear.Poly) (visible, total int) {
+	if len(poly) == 0 {
+		return
+	}
+	min, max := poly[0], poly[0]
+	for _, v := range poly {
+		min.X = math.Min(min.X, v.X)
+		min.Y = math.Min(min.Y, v.Y)
+		max.X = math.Max(max.X, v.X)
+		max.Y = math.Max(max.Y, v.Y)
+	}
+	c0 := g.CellAt(min)
+	c1 := g.CellAt(max)
+	for x := c0.X; x <= c1.X; x++ {
+		for y := c0.Y; y <= c1.Y; y++ {
+			if !g.inside(x, y) {
+				continue
+			}
+			center := linear.Vec2{(float64(x) + 0.5) * g.CellSize, (float64(y) + 0.5) * g.CellSize}
+			if !PolyContains(poly, center) {
+				continue
+			}
+			total++
+			if g.counts[x+y*g.Dx] > 0 {
+				visible++
+			}
+		}
+	}
+	return
+}
+
+// PolyContains returns true if v is inside poly, which can be any simple
+// polygon, not just a convex one.
+func PolyContains(poly linear.Poly, v linear.Vec2) bool {
+	inside := false
+	for i := range poly {
+		seg := poly.Seg(i)
+		if (seg.P.Y > v.Y) != (seg.Q.Y > v.Y) {
+			x := seg.P.X + (v.Y-seg.P.Y)/(seg.Q.Y-seg.P.Y)*(seg.Q.X-seg.P.X)
+			if v.X < x {
+				inside = !inside
+			}
+		}
+	}
+	return inside
+}
@@ -0,0 +1,128 @@
+package los
+
+import (
+	"github.com/runningwild/linear"
+	"testing"
+)
+
+func TestGridMergesViewers(t *testing.T) {
+	g := MakeGrid(4, 4, 10)
+	g.SetViewer("a", []Cell{{0, 0}, {1, 0}, {5, 5}, {-1, 0}})
+	g.SetViewer("b", []Cell{{1, 0}, {2, 0}})
+	for _, c := range []struct {
+		x, y int
+		want bool
+	}{
+		{0, 0, true},
+		{1, 0, true},
+		{2, 0, true},
+		{3, 0, false},
+		{0, 1, false},
+		{5, 5, false}, // outside of the grid
+	} {
+		if got := g.CellVisible(c.x, c.y); got != c.want {
+			t.Errorf("CellVisible(%d, %d) with a and b: got %t, want %t", c.x, c.y, got, c.want)
+		}
+	}
+
+	// Moving a only changes the cells that only a could see, b still sees the
+	// cell that they shared.
+	g.SetViewer("a", []Cell{{0, 1}, {1, 0}})
+	for _, c := range []struct {
+		x, y int
+		want bool
+	}{
+		{0, 0, false},
+		{0, 1, true},
+		{1, 0, true},
+		{2, 0, true},
+	} {
+		if got := g.CellVisible(c.x, c.y); got != c.want {
+			t.Errorf("CellVisible(%d, %d) after moving a: got %t, want %t", c.x, c.y, got, c.want)
+		}
+	}
+
+	g.RemoveViewer("b")
+	if !g.CellVisible(1, 0) {
+		t.Errorf("Removing b hid a cell that a can still see")
+	}
+	if g.CellVisible(2, 0) {
+		t.Errorf("Removing b didn't hide a cell that only b could see")
+	}
+	g.RemoveViewer("a")
+	g.RemoveViewer("a")
+	for x := 0; x < g.Dx; x++ {
+		for y := 0; y < g.Dy; y++ {
+			if g.CellVisible(x, y) {
+				t.Errorf("Cell %d, %d is visible after every viewer was removed", x, y)
+			}
+		}
+	}
+}
+
+func TestGridPointVisible(t *testing.T) {
+	g := MakeGrid(4, 4, 10)
+	g.SetViewer("a", []Cell{{1, 2}})
+	for _, c := range []struct {
+		p    linear.Vec2
+		want bool
+	}{
+		{linear.Vec2{10, 20}, true},
+		{linear.Vec2{15, 25}, true},
+		{linear.Vec2{19.9, 29.9}, true},
+		{linear.Vec2{20, 25}, false},
+		{linear.Vec2{15, 19.9}, false},
+		{linear.Vec2{-5, -5}, false},
+		{linear.Vec2{100, 100}, false},
+	} {
+		if got := g.PointVisible(c.p); got != c.want {
+			t.Errorf("PointVisible(%v): got %t, want %t", c.p, got, c.want)
+		}
+	}
+}
+
+func TestGridRegionVisibility(t *testing.T) {
+	g := MakeGrid(4, 4, 10)
+	g.SetViewer("a", []Cell{{0, 0}, {1, 1}, {3, 3}})
+	for _, c := range []struct {
+		min, max       linear.Vec2
+		visible, total int
+	}{
+		{linear.Vec2{0, 0}, linear.Vec2{19, 19}, 2, 4},
+		{linear.Vec2{5, 5}, linear.Vec2{5, 5}, 1, 1},
+		{linear.Vec2{25, 5}, linear.Vec2{35, 15}, 0, 4},
+		// Only the parts inside of the grid count.
+		{linear.Vec2{25, 25}, linear.Vec2{100, 100}, 1, 4},
+		{linear.Vec2{-100, -100}, linear.Vec2{-50, -50}, 0, 0},
+	} {
+		visible, total := g.RegionVisibility(c.min, c.max)
+		if visible != c.visible || total != c.total {
+			t.Errorf("RegionVisibility(%v, %v): got %d/%d, want %d/%d", c.min, c.max, visible, total, c.visible, c.total)
+		}
+	}
+}
+
+func TestGridPolyVisibility(t *testing.T) {
+	g := MakeGrid(4, 4, 10)
+	g.SetViewer("a", []Cell{{0, 0}, {2, 0}, {0, 2}})
+	for _, c := range []struct {
+		name           string
+		poly           linear.Poly
+		visible, total int
+	}{
+		// Covers the centers of the bottom left 3x3 cells.
+		{"square", linear.Poly{{0, 0}, {0, 30}, {30, 30}, {30, 0}}, 3, 9},
+		// Covers the centers of (0, 0), (1, 0), (2, 0), (0, 1), (1, 1) and (0, 2).
+		{"triangle", linear.Poly{{0, 0}, {0, 31}, {31, 0}}, 3, 6},
+		// Concave, the notch leaves out the centers of (1, 1) and (1, 2).
+		{"notched", linear.Poly{{0, 0}, {0, 30}, {10, 30}, {10, 10}, {20, 10}, {20, 30}, {30, 30}, {30, 0}}, 3, 7},
+		// Doesn't contain the center of any cell.
+		{"small", linear.Poly{{1, 1}, {1, 4}, {4, 4}, {4, 1}}, 0, 0},
+		{"empty", nil, 0, 0},
+	} {
+		visible, total := g.PolyVisibility(c.poly)
+		if visible != c.visible || total != c.total {
+			t.Errorf("PolyVisibility(%s): got %d/%d, want %d/%d", c.name, visible, total, c.visible, c.total)
+		}
+	}
+}
@@ -20,6 +20,7 @@ import (
 	_ "github.com/runningwild/magnus/effects"
 	"github.com/runningwild/magnus/game"
 	"github.com/runningwild/magnus/generator"
+	"github.com/runningwild/magnus/statesync"
 	"github.com/runningwild/magnus/texture"
 	_ "image/jpeg"
 	_ "image/png"
@@ -53,7 +54,7 @@ func init() {
 	base.SetDefaultKeyMap(key_map)
 }
 
-func debugHookup(version string) (*cgf.Engine, *game.LocalData) {
+func debugHookup(version string) (game.Engine, *game.LocalData) {
 	// if version != "standard" && version != "moba" && version != "host" && version != "client" {
 	// 	base.Log().Fatalf("Unable to handle Version() == '%s'", Version())
 	// }
@@ -63,6 +64,16 @@ func debugHookup(version string) (*cgf.Engine, *game.LocalData) {
 		sys.Think()
 	}
 
+	// A server that only sends what this side can see, see cmd/server.
+	if addr := os.Getenv("MAGNUS_SERVER"); addr != "" && version != "host" {
+		client, err := statesync.Dial(addr)
+		if err != nil {
+			base.Error().Fatalf("Unable to connect to %s: %v", addr, err)
+		}
+		base.Log().Printf("Engine Id: %v", client.Id())
+		return client, game.NewLocalDataMoba(client, gin.DeviceIndexAny, sys)
+	}
+
 	var engine *cgf.Engine
 	var room game.Room
 	generated := generator.GenerateRoom(1024, 1024, 100, 64, 64522029961391019)
@@ -138,7 +149,7 @@ func debugHookup(version string) (*cgf.Engine, *game.LocalData) {
 	return engine, localData
 }
 
-func mainLoop(engine *cgf.Engine, local *game.LocalData, mode string) {
+func mainLoop(engine game.Engine, local *game.LocalData, mode string) {
 	defer engine.Kill()
 	var profile_output *os.File
 	var contention_output *os.File
@@ -165,6 +176,9 @@ func mainLoop(engine *cgf.Engine, local *game.LocalData, mode string) {
 			if side0Key.FramePressCount() > 0 {
 				local.DebugCyclePlayers()
 			}
+			if side2Key.FramePressCount() > 0 {
+				local.DebugToggleEditor()
+			}
 			// if side0Key.FramePressCount() > 0 {
 			// 	local.DebugSetSide(0)
 			// }
@@ -180,7 +194,7 @@ func mainLoop(engine *cgf.Engine, local *game.LocalData, mode string) {
 				local.DebugChangeMode(game.LocalModeArchitect)
 			}
 			if side2Key.FramePressCount() > 0 {
-				local.DebugChangeMode(game.LocalModeEditor)
+				local.DebugToggleEditor()
 			}
 		}
 		sys.Think()
@@ -0,0 +1,248 @@
+// Package preview draws rooms into images without needing OpenGL, so that
+// rooms can be looked at on machines without a display, like when debugging
+// generators or in bug reports.
+package preview
+
+import (
+	"github.com/runningwild/linear"
+	"github.com/runningwild/magnus/generator"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"sort"
+)
+
+// An Ent is drawn as a circle in its side's color.
+type Ent struct {
+	Pos    linear.Vec2
+	Radius float64
+
+	// Side -1 is neutral.
+	Side int
+}
+
+type Options struct {
+	// Size of the image.  If only one is set the other is chosen so that the
+	// room fits without any space around it, and if neither is set the image
+	// is the size of the room.  The room is always centered and keeps its
+	// shape.
+	Width, Height int
+
+	// Ents to draw on top of the room.
+	Ents []Ent
+
+	// Positions to mark with a red X, like problems found by Validate.
+	Marks []linear.Vec2
+}
+
+var (
+	outsideColor = color.RGBA{30, 30, 34, 255}
+	floorColor   = color.RGBA{205, 200, 190, 255}
+	wallColor    = color.RGBA{55, 55, 62, 255}
+	edgeColor    = color.RGBA{0, 0, 0, 255}
+	hazardColor  = color.RGBA{255, 80, 0, 120}
+	portalColor  = color.RGBA{200, 0, 255, 110}
+	endColor     = color.RGBA{0, 200, 80, 255}
+	neutralColor = color.RGBA{150, 150, 150, 255}
+	markColor    = color.RGBA{255, 0, 0, 255}
+
+	// Same colors that the editor uses for mana.
+	manaColors = []color.RGBA{{255, 80, 80, 255}, {80, 255, 80, 255}, {80, 80, 255, 255}}
+
+	sideColors = []color.RGBA{
+		{40, 110, 255, 255},
+		{230, 40, 40, 255},
+		{30, 170, 60, 255},
+		{240, 190, 0, 255},
+		{160, 60, 220, 255},
+		{0, 190, 200, 255},
+	}
+)
+
+func sideColor(side int) color.RGBA {
+	if side < 0 {
+		return neutralColor
+	}
+	return sideColors[side%len(sideColors)]
+}
+
+func withAlpha(col color.RGBA, alpha uint8) color.RGBA {
+	col.A = alpha
+	return col
+}
+
+// sortedWalls returns the walls in room in the order of their ids, so that
+// overlapping walls are always drawn the same way.
+func sortedWalls(room generator.Room) []linear.Poly {
+	var ids []string
+	for id := range room.Walls {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var walls []linear.Poly
+	for _, id := range ids {
+		if len(room.Walls[id]) >= 3 {
+			walls = append(walls, room.Walls[id])
+		}
+	}
+	return walls
+}
+
+// Render draws room, along with anything in options, and returns the image.
+func Render(room generator.Room, options Options) *image.RGBA {
+	rdx, rdy := float64(room.Dx), float64(room.Dy)
+	if rdx <= 0 || rdy <= 0 {
+		rdx, rdy = 1, 1
+	}
+	width, height := options.Width, options.Height
+	switch {
+	case width <= 0 && height <= 0:
+		width, height = int(math.Ceil(rdx)), int(math.Ceil(rdy))
+	case height <= 0:
+		height = int(math.Ceil(float64(width) * rdy / rdx))
+	case width <= 0:
+		width = int(math.Ceil(float64(height) * rdx / rdy))
+	}
+	scale := math.Min(float64(width)/rdx, float64(height)/rdy)
+	offset := linear.Vec2{(float64(width) - rdx*scale) / 2, (float64(height) - rdy*scale) / 2}
+
+	// Rooms have y going up, images have it going down.
+	toPixel := func(v linear.Vec2) linear.Vec2 {
+		return linear.Vec2{v.X*scale + offset.X, (rdy-v.Y)*scale + offset.Y}
+	}
+	toPixels := func(poly []linear.Vec2) []linear.Vec2 {
+		pixels := make([]linear.Vec2, len(poly))
+		for i, v := range poly {
+			pixels[i] = toPixel(v)
+		}
+		return pixels
+	}
+
+	// Markers need to be visible in thumbnails, so they're never smaller than a
+	// few pixels.
+	marker := math.Max(3, 0.012*math.Min(float64(width), float64(height)))
+	edge := math.Max(1, marker/4)
+
+	c := makeCanvas(width, height, outsideColor)
+
+	// Counter-clockwise walls are boundaries, everything inside of them is open
+	// unless another wall is in the way.
+	bounded := false
+	for _, wall := range sortedWalls(room) {
+		if wall.IsCounterClockwise() {
+			c.fill(toPixels(wall), floorColor)
+			bounded = true
+		}
+	}
+	if !bounded {
+		c.fill(toPixels([]linear.Vec2{{0, 0}, {rdx, 0}, {rdx, rdy}, {0, rdy}}), floorColor)
+	}
+
+	for _, seed := range room.Mana.Seeds {
+		if seed.Color < 0 || seed.Color >= len(manaColors) {
+			continue
+		}
+		col := manaColors[seed.Color]
+		pos := toPixel(seed.Pos)
+		c.fill(circle(pos, 0.08*math.Min(rdx, rdy)*scale), withAlpha(col, 50))
+		c.fill(circle(pos, marker/2), col)
+	}
+
+	var ids []string
+	for id := range room.Hazards {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		c.fill(toPixels(room.Hazards[id].Region), hazardColor)
+	}
+	ids = ids[:0]
+	for id := range room.Portals {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		region := toPixels(room.Portals[id].Region)
+		c.fill(region, portalColor)
+		c.outline(region, true, edge, withAlpha(portalColor, 255))
+	}
+
+	for _, wall := range sortedWalls(room) {
+		if wall.IsCounterClockwise() {
+			c.outline(toPixels(wall), true, edge, edgeColor)
+			continue
+		}
+		c.fill(toPixels(wall), wallColor)
+		c.outline(toPixels(wall), true, edge, edgeColor)
+	}
+
+	// The last side in a moba room is neutral, its towers are control points.
+	sides := room.Moba.SideData
+	for i, data := range sides {
+		side := i
+		if i == len(sides)-1 {
+			side = -1
+		}
+		col := sideColor(side)
+		for _, lane := range data.Lanes {
+			c.outline(toPixels(lane), false, edge, withAlpha(col, 160))
+		}
+		for _, tower := range data.Towers {
+			pos := toPixel(tower)
+			if side == -1 {
+				c.fill(square(pos, marker, math.Pi/4), edgeColor)
+				c.fill(square(pos, marker-edge, math.Pi/4), col)
+				continue
+			}
+			c.fill(square(pos, marker, 0), edgeColor)
+			c.fill(square(pos, marker-edge, 0), col)
+		}
+		if side != -1 && data.Base != (linear.Vec2{}) {
+			pos := toPixel(data.Base)
+			c.fill(square(pos, 2*marker, 0), edgeColor)
+			c.fill(square(pos, 2*marker-edge, 0), col)
+		}
+	}
+
+	for i, start := range room.Starts {
+		pos := toPixel(start)
+		c.fill(circle(pos, marker), edgeColor)
+		c.fill(circle(pos, marker-edge), sideColor(i))
+	}
+	if room.End != (linear.Vec2{}) {
+		pos := toPixel(room.End)
+		c.fill(circle(pos, marker), endColor)
+		c.fill(circle(pos, marker-edge), floorColor)
+	}
+
+	for _, ent := range options.Ents {
+		pos := toPixel(ent.Pos)
+		radius := math.Max(ent.Radius*scale, 2)
+		c.fill(circle(pos, radius+edge), edgeColor)
+		c.fill(circle(pos, radius), sideColor(ent.Side))
+	}
+
+	for _, mark := range options.Marks {
+		pos := toPixel(mark)
+		d := linear.Vec2{marker, marker}
+		e := linear.Vec2{marker, -marker}
+		c.line(pos.Sub(d), pos.Add(d), edge*1.5, markColor)
+		c.line(pos.Sub(e), pos.Add(e), edge*1.5, markColor)
+	}
+	return c.img
+}
+
+// SavePNG renders room and saves it as a png at path.
+func SavePNG(path string, room generator.Room, options Options) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = png.Encode(f, Render(room, options))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,143 @@
+package preview
+
+import (
+	"github.com/runningwild/linear"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// Number of samples taken down each row of pixels when filling polygons.
+// Coverage across a row is computed exactly, so edges are antialiased in both
+// directions.
+const subRows = 4
+
+// canvas draws into an image in pixel coordinates.
+type canvas struct {
+	img *image.RGBA
+	cov []float64
+}
+
+func makeCanvas(dx, dy int, background color.RGBA) *canvas {
+	c := &canvas{
+		img: image.NewRGBA(image.Rect(0, 0, dx, dy)),
+		cov: make([]float64, dx+1),
+	}
+	for i := 0; i < len(c.img.Pix); i += 4 {
+		c.img.Pix[i+0] = background.R
+		c.img.Pix[i+1] = background.G
+		c.img.Pix[i+2] = background.B
+		c.img.Pix[i+3] = 255
+	}
+	return c
+}
+
+// blend mixes col into the pixel at x, y by coverage times col's alpha.  col
+// is not premultiplied.
+func (c *canvas) blend(x, y int, col color.RGBA, coverage float64) {
+	a := coverage * float64(col.A) / 255
+	if a <= 0 {
+		return
+	}
+	if a > 1 {
+		a = 1
+	}
+	i := c.img.PixOffset(x, y)
+	pix := c.img.Pix[i : i+3]
+	for j, v := range [3]uint8{col.R, col.G, col.B} {
+		pix[j] = uint8(float64(v)*a + float64(pix[j])*(1-a) + 0.5)
+	}
+}
+
+// fill fills poly using the even-odd rule.
+func (c *canvas) fill(poly []linear.Vec2, col color.RGBA) {
+	if len(poly) < 3 {
+		return
+	}
+	bounds := c.img.Bounds()
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	for _, v := range poly {
+		minY = math.Min(minY, v.Y)
+		maxY = math.Max(maxY, v.Y)
+	}
+	y0 := int(math.Max(math.Floor(minY), 0))
+	y1 := int(math.Min(math.Ceil(maxY), float64(bounds.Dy())))
+	var xs []float64
+	for y := y0; y < y1; y++ {
+		lo, hi := len(c.cov), -1
+		for sub := 0; sub < subRows; sub++ {
+			sy := float64(y) + (float64(sub)+0.5)/subRows
+			xs = xs[:0]
+			for i := range poly {
+				a, b := poly[i], poly[(i+1)%len(poly)]
+				if (a.Y <= sy) == (b.Y <= sy) {
+					continue
+				}
+				xs = append(xs, a.X+(sy-a.Y)*(b.X-a.X)/(b.Y-a.Y))
+			}
+			sort.Float64s(xs)
+			for i := 0; i+1 < len(xs); i += 2 {
+				x0 := math.Max(xs[i], 0)
+				x1 := math.Min(xs[i+1], float64(bounds.Dx()))
+				for px := int(x0); float64(px) < x1; px++ {
+					c.cov[px] += (math.Min(x1, float64(px+1)) - math.Max(x0, float64(px))) / subRows
+					if px < lo {
+						lo = px
+					}
+					if px > hi {
+						hi = px
+					}
+				}
+			}
+		}
+		for x := lo; x <= hi; x++ {
+			c.blend(x, y, col, c.cov[x])
+			c.cov[x] = 0
+		}
+	}
+}
+
+// line draws a line from a to b that is width pixels wide.
+func (c *canvas) line(a, b linear.Vec2, width float64, col color.RGBA) {
+	d := b.Sub(a)
+	if d.Mag() < 1e-9 {
+		return
+	}
+	n := d.Cross().Norm().Scale(width / 2)
+	c.fill([]linear.Vec2{a.Add(n), b.Add(n), b.Sub(n), a.Sub(n)}, col)
+}
+
+// outline draws the edges of poly, closing it if closed is set.
+func (c *canvas) outline(poly []linear.Vec2, closed bool, width float64, col color.RGBA) {
+	for i := 0; i+1 < len(poly); i++ {
+		c.line(poly[i], poly[i+1], width, col)
+	}
+	if closed && len(poly) > 2 {
+		c.line(poly[len(poly)-1], poly[0], width, col)
+	}
+}
+
+// circle returns a polygon that approximates a circle.
+func circle(center linear.Vec2, radius float64) []linear.Vec2 {
+	sides := 8 + int(radius)
+	if sides > 64 {
+		sides = 64
+	}
+	poly := make([]linear.Vec2, sides)
+	for i := range poly {
+		a := 2 * math.Pi * float64(i) / float64(sides)
+		poly[i] = center.Add(linear.Vec2{math.Cos(a), math.Sin(a)}.Scale(radius))
+	}
+	return poly
+}
+
+// square returns a square centered on center, turned by angle radians.
+func square(center linear.Vec2, radius, angle float64) []linear.Vec2 {
+	var poly []linear.Vec2
+	for i := 0; i < 4; i++ {
+		a := angle + math.Pi/4 + float64(i)*math.Pi/2
+		poly = append(poly, center.Add(linear.Vec2{math.Cos(a), math.Sin(a)}.Scale(radius*math.Sqrt2)))
+	}
+	return poly
+}
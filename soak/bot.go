@@ -0,0 +1,104 @@
+package soak
+
+import (
+	"github.com/runningwild/cgf"
+	"github.com/runningwild/linear"
+	"github.com/runningwild/magnus/game"
+	"math/rand"
+)
+
+// A bot drives a single player with random input.  It only ever talks to the
+// game through events, exactly like a LocalData would, so anything it finds
+// could also have been done by a person with a keyboard.
+type bot struct {
+	engineId int64
+	gid      game.Gid
+
+	abilities []game.Ability
+	pressed   []bool
+	active    int // index into abilities, or -1
+
+	// Current intentions, these are held for a random number of frames so that
+	// the bot actually gets somewhere.
+	accelerate float64
+	turn       float64
+	hold       int
+}
+
+func makeBot(engineId int64, gid game.Gid) *bot {
+	return &bot{
+		engineId: engineId,
+		gid:      gid,
+		active:   -1,
+	}
+}
+
+// setupAbilities makes the abilities for the champ that this bot's player is
+// using.  It can't be done until after the game has finished its setup phase.
+func (b *bot) setupAbilities(g *game.Game) {
+	player, ok := g.Ents[b.gid].(*game.PlayerEnt)
+	if !ok {
+		return
+	}
+	for _, ability := range g.Champs[player.Champ].Abilities {
+		b.abilities = append(b.abilities, game.MakeAbility(ability.Name, ability.Params))
+		b.pressed = append(b.pressed, false)
+	}
+}
+
+func (b *bot) Think(g *game.Game, rng *rand.Rand) []cgf.Event {
+	if b.abilities == nil {
+		b.setupAbilities(g)
+	}
+	if _, ok := g.Ents[b.gid].(*game.PlayerEnt); !ok {
+		// Dead, nothing to do until we respawn.
+		return nil
+	}
+	var events []cgf.Event
+
+	b.hold--
+	if b.hold <= 0 {
+		b.hold = rng.Intn(120) + 1
+		b.accelerate = (rng.Float64()*2 - 0.5) * 300
+		b.turn = rng.Float64()*2 - 1
+	}
+	if b.accelerate != 0 {
+		events = append(events, game.Accelerate{b.gid, b.accelerate})
+	}
+	if b.turn != 0 {
+		events = append(events, game.Turn{b.gid, b.turn})
+	}
+
+	if len(b.abilities) > 0 && rng.Intn(30) == 0 {
+		n := rng.Intn(len(b.abilities))
+		if b.abilities[n] != nil {
+			events = append(events, b.toggle(n)...)
+		}
+	}
+
+	if b.active != -1 {
+		room := g.Levels[game.GidInvadersStart].Room
+		mouse := linear.Vec2{rng.Float64() * float64(room.Dx), rng.Float64() * float64(room.Dy)}
+		more, die := b.abilities[b.active].Think(b.gid, g, mouse)
+		events = append(events, more...)
+		if die {
+			events = append(events, b.abilities[b.active].Deactivate(b.gid)...)
+			b.active = -1
+		}
+	}
+	return events
+}
+
+// toggle presses ability n if it isn't pressed, and releases it if it is.
+// This mirrors LocalData.activateAbility.
+func (b *bot) toggle(n int) []cgf.Event {
+	b.pressed[n] = !b.pressed[n]
+	events, active := b.abilities[n].Activate(b.gid, b.pressed[n])
+	if active && b.active != -1 && b.active != n {
+		events = append(events, b.abilities[b.active].Deactivate(b.gid)...)
+	}
+	if active {
+		b.active = n
+	}
+	return events
+}
@@ -0,0 +1,110 @@
+package soak
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/runningwild/linear"
+	"github.com/runningwild/magnus/base"
+	"github.com/runningwild/magnus/game"
+	"github.com/runningwild/magnus/los"
+	"hash/fnv"
+	"math"
+)
+
+// An invariant returns a non-empty string describing the problem if g is in a
+// state that should never be possible.
+type invariant struct {
+	name  string
+	check func(g *game.Game) string
+}
+
+var invariants = []invariant{
+	{"finite", checkFinite},
+	{"bounds", checkBounds},
+	{"walls", checkWalls},
+}
+
+func bad(f float64) bool {
+	return math.IsNaN(f) || math.IsInf(f, 0)
+}
+
+func checkFinite(g *game.Game) string {
+	var msg string
+	g.DoForEnts(func(gid game.Gid, ent game.Ent) {
+		if msg != "" {
+			return
+		}
+		pos := ent.Pos()
+		vel := ent.Vel()
+		health := ent.Stats().HealthCur()
+		if bad(pos.X) || bad(pos.Y) || bad(vel.X) || bad(vel.Y) || bad(health) {
+			msg = fmt.Sprintf("ent %v has pos %v, vel %v, health %v", gid, pos, vel, health)
+		}
+	})
+	return msg
+}
+
+func checkBounds(g *game.Game) string {
+	var msg string
+	g.DoForEnts(func(gid game.Gid, ent game.Ent) {
+		if msg != "" {
+			return
+		}
+		level, ok := g.Levels[ent.Level()]
+		if !ok {
+			msg = fmt.Sprintf("ent %v is on unknown level %v", gid, ent.Level())
+			return
+		}
+		pos := ent.Pos()
+		if pos.X < 0 || pos.Y < 0 || pos.X > float64(level.Room.Dx) || pos.Y > float64(level.Room.Dy) {
+			msg = fmt.Sprintf("ent %v at %v is outside of the %dx%d room", gid, pos, level.Room.Dx, level.Room.Dy)
+		}
+	})
+	return msg
+}
+
+func checkWalls(g *game.Game) string {
+	var msg string
+	g.DoForEnts(func(gid game.Gid, ent game.Ent) {
+		if msg != "" {
+			return
+		}
+		level, ok := g.Levels[ent.Level()]
+		if !ok {
+			return
+		}
+		base.DoOrdered(level.Room.Walls, func(a, b string) bool { return a < b }, func(name string, poly linear.Poly) {
+			if msg != "" {
+				return
+			}
+			// Counter-clockwise polys are room boundaries, ents belong inside them.
+			if poly.IsCounterClockwise() {
+				return
+			}
+			if los.PolyContains(poly, ent.Pos()) {
+				msg = fmt.Sprintf("ent %v at %v is inside of wall %s", gid, ent.Pos(), name)
+			}
+		})
+	})
+	return msg
+}
+
+// stateHash summarizes the state of every ent so that two runs of the same
+// match can be compared frame by frame.
+func stateHash(g *game.Game) uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	write := func(f float64) {
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(f))
+		h.Write(buf[:])
+	}
+	g.DoForEnts(func(gid game.Gid, ent game.Ent) {
+		h.Write([]byte(gid))
+		write(ent.Pos().X)
+		write(ent.Pos().Y)
+		write(ent.Vel().X)
+		write(ent.Vel().Y)
+		write(ent.Stats().HealthCur())
+	})
+	return h.Sum64()
+}
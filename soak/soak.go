@@ -0,0 +1,337 @@
+// Package soak runs headless matches with randomized bots and checks that the
+// simulation never ends up in an impossible state.
+package soak
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/runningwild/cgf"
+	"github.com/runningwild/magnus/base"
+	"github.com/runningwild/magnus/game"
+	"github.com/runningwild/magnus/generator"
+	"github.com/runningwild/magnus/preview"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+type Config struct {
+	// Match i is played with seed Seed+i.
+	Seed    int64
+	Matches int
+
+	// Number of frames to run each match for.
+	Frames int
+
+	// Number of sides and players on each side.
+	Sides          int
+	PlayersPerSide int
+
+	// If true each match is replayed from its event log and must produce
+	// identical state on every frame.
+	CheckReplay bool
+
+	// Directory to write event logs for failed matches to.  Nothing is
+	// written if this is empty.
+	OutDir string
+
+	FriendlyFire game.FriendlyFirePolicy
+}
+
+// A Failure describes the first thing that went wrong in a match.
+type Failure struct {
+	Seed      int64
+	Frame     int
+	Invariant string
+	Message   string
+	Stack     string
+}
+
+func (f *Failure) String() string {
+	return fmt.Sprintf("seed %d, frame %d: %s: %s", f.Seed, f.Frame, f.Invariant, f.Message)
+}
+
+// An EventLog contains everything needed to reproduce a match exactly.
+type EventLog struct {
+	Seed int64
+
+	// Events applied during the setup phase, before the first frame.
+	Setup []cgf.Event
+
+	// Frames[i] are the events applied before the i-th call to Game.Think.
+	Frames [][]cgf.Event
+
+	// Hashes[i] is the stateHash after the i-th call to Game.Think.
+	Hashes []uint64
+}
+
+func LoadEventLog(path string) (*EventLog, error) {
+	var log EventLog
+	err := base.LoadGob(path, &log)
+	if err != nil {
+		return nil, err
+	}
+	return &log, nil
+}
+
+// Run plays config.Matches matches and returns every failure found.
+func Run(config Config) []*Failure {
+	var failures []*Failure
+	for i := 0; i < config.Matches; i++ {
+		seed := config.Seed + int64(i)
+		failure, log := RunMatch(config, seed)
+		if failure == nil && config.CheckReplay {
+			failure = Replay(log)
+		}
+		if failure == nil {
+			base.Log().Printf("Soak: seed %d passed %d frames", seed, config.Frames)
+			continue
+		}
+		base.Error().Printf("Soak: %v", failure)
+		failures = append(failures, failure)
+		if config.OutDir != "" {
+			if err := writeFailure(config.OutDir, failure, log); err != nil {
+				base.Error().Printf("Unable to write event log: %v", err)
+			}
+		}
+	}
+	return failures
+}
+
+// RunMatch plays a single match with bots and returns the first failure, if
+// any, along with the log of every event that was applied.
+func RunMatch(config Config, seed int64) (*Failure, *EventLog) {
+	rng := rand.New(rand.NewSource(seed))
+	log := &EventLog{Seed: seed}
+	g := game.MakeGame()
+
+	var ids []int64
+	for i := 0; i < config.Sides*config.PlayersPerSide; i++ {
+		ids = append(ids, int64(i+1))
+	}
+	log.Setup = append(log.Setup, game.SetupSetEngineIds{ids, 0}, game.SetupFriendlyFire{config.FriendlyFire})
+	for i, id := range ids {
+		log.Setup = append(log.Setup, game.SetupChangeSides{id, i % config.Sides}, game.SetupSetReady{id, true})
+		if len(g.Champs) > 0 {
+			log.Setup = append(log.Setup, game.SetupChampSelect{id, rng.Intn(len(g.Champs))})
+		}
+	}
+	log.Setup = append(log.Setup, game.SetupComplete{seed})
+	failure := safely(seed, -1, func() { applyAll(g, log.Setup) })
+	if failure != nil {
+		return failure, log
+	}
+
+	var engineIds []int64
+	for id := range g.Engines {
+		engineIds = append(engineIds, id)
+	}
+	sort.Sort(int64Slice(engineIds))
+	var bots []*bot
+	for _, id := range engineIds {
+		bots = append(bots, makeBot(id, g.Engines[id].PlayerGid))
+	}
+
+	for frame := 0; frame < config.Frames; frame++ {
+		var events []cgf.Event
+		failure = safely(seed, frame, func() {
+			for _, b := range bots {
+				events = append(events, b.Think(g, rng)...)
+			}
+		})
+		if failure != nil {
+			return failure, log
+		}
+		log.Frames = append(log.Frames, events)
+		failure = step(g, seed, frame, events)
+		log.Hashes = append(log.Hashes, stateHash(g))
+		if failure != nil {
+			return failure, log
+		}
+	}
+	base.Log().Printf("Soak: seed %d los cache: %v", seed, g.LosCacheMetrics())
+	return nil, log
+}
+
+// Replay plays back log on a fresh game and checks that it ends up in exactly
+// the same state on every frame.
+func Replay(log *EventLog) *Failure {
+	g := game.MakeGame()
+	failure := safely(log.Seed, -1, func() { applyAll(g, log.Setup) })
+	if failure != nil {
+		return failure
+	}
+	for frame, events := range log.Frames {
+		failure = step(g, log.Seed, frame, events)
+		if failure != nil {
+			return failure
+		}
+		if frame < len(log.Hashes) && stateHash(g) != log.Hashes[frame] {
+			return &Failure{
+				Seed:      log.Seed,
+				Frame:     frame,
+				Invariant: "replay",
+				Message:   "replaying the event log did not reproduce the same state",
+			}
+		}
+	}
+	return nil
+}
+
+// step applies events, advances the game one frame and then checks all of the
+// invariants.
+func step(g *game.Game, seed int64, frame int, events []cgf.Event) *Failure {
+	failure := safely(seed, frame, func() {
+		applyAll(g, events)
+		g.Think()
+	})
+	if failure != nil {
+		return failure
+	}
+	for _, inv := range invariants {
+		if msg := inv.check(g); msg != "" {
+			return &Failure{
+				Seed:      seed,
+				Frame:     frame,
+				Invariant: inv.name,
+				Message:   msg,
+			}
+		}
+	}
+	return nil
+}
+
+func applyAll(g *game.Game, events []cgf.Event) {
+	for _, event := range events {
+		event.Apply(g)
+	}
+}
+
+type soakPanic struct {
+	data  interface{}
+	stack []byte
+}
+
+// safely runs f and converts any panic into a Failure.  Game.Think catches its
+// own panics with base.StackCatcher, so a panic handler is installed that
+// rethrows them to here rather than exiting.
+func safely(seed int64, frame int, f func()) (failure *Failure) {
+	base.SetPanicHandler(func(data interface{}, stack []byte) {
+		panic(soakPanic{data, stack})
+	})
+	defer base.SetPanicHandler(nil)
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		failure = &Failure{
+			Seed:      seed,
+			Frame:     frame,
+			Invariant: "panic",
+		}
+		if sp, ok := r.(soakPanic); ok {
+			failure.Message = fmt.Sprintf("%v", sp.data)
+			failure.Stack = string(sp.stack)
+		} else {
+			failure.Message = fmt.Sprintf("%v", r)
+		}
+	}()
+	f()
+	return nil
+}
+
+// writeFailure writes a gob encoded event log that can be passed back in to
+// Replay, a human readable version of the same thing, and a picture of the
+// room at the moment the match failed.
+func writeFailure(dir string, failure *Failure, log *EventLog) error {
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("seed-%d", failure.Seed)
+	err = base.SaveGob(filepath.Join(dir, name+".gob"), log)
+	if err != nil {
+		return err
+	}
+	err = savePreview(filepath.Join(dir, name+".png"), failure, log)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(dir, name+".txt"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "%v\n", failure)
+	if failure.Stack != "" {
+		fmt.Fprintf(w, "%s\n", failure.Stack)
+	}
+	fmt.Fprintf(w, "\nSetup:\n")
+	for _, event := range log.Setup {
+		fmt.Fprintf(w, "  %T %+v\n", event, event)
+	}
+	for frame, events := range log.Frames {
+		if len(events) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "Frame %d:\n", frame)
+		for _, event := range events {
+			fmt.Fprintf(w, "  %T %+v\n", event, event)
+		}
+	}
+	return w.Flush()
+}
+
+// savePreview replays log up to the frame that failed and saves a picture of
+// the room with every ent in it.  Nothing is saved if the match failed before
+// there was a room.
+func savePreview(path string, failure *Failure, log *EventLog) error {
+	g := game.MakeGame()
+	if safely(log.Seed, -1, func() { applyAll(g, log.Setup) }) != nil {
+		return nil
+	}
+	level := g.Levels[game.GidInvadersStart]
+	if level == nil {
+		return nil
+	}
+	for frame := 0; frame <= failure.Frame && frame < len(log.Frames); frame++ {
+		if step(g, log.Seed, frame, log.Frames[frame]) != nil {
+			break
+		}
+	}
+
+	var room generator.Room
+	data, err := json.Marshal(level.Room)
+	if err == nil {
+		err = json.Unmarshal(data, &room)
+	}
+	if err != nil {
+		return err
+	}
+	var gids []string
+	for gid := range g.Ents {
+		gids = append(gids, string(gid))
+	}
+	sort.Strings(gids)
+	var options preview.Options
+	options.Width = 1024
+	for _, gid := range gids {
+		ent := g.Ents[game.Gid(gid)]
+		options.Ents = append(options.Ents, preview.Ent{
+			Pos:    ent.Pos(),
+			Radius: ent.Stats().Size(),
+			Side:   ent.Side(),
+		})
+	}
+	return preview.SavePNG(path, room, options)
+}
+
+type int64Slice []int64
+
+func (s int64Slice) Len() int           { return len(s) }
+func (s int64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s int64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
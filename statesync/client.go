@@ -0,0 +1,142 @@
+package statesync
+
+import (
+	"bytes"
+	"encoding/gob"
+	"github.com/runningwild/cgf"
+	"github.com/runningwild/magnus/base"
+	"github.com/runningwild/magnus/game"
+	"net"
+	"sync"
+)
+
+// Client is a game.Engine that gets its state from a Server.  It never
+// simulates anything, so it is never the host.
+type Client struct {
+	conn net.Conn
+	id   int64
+
+	events chan cgf.Event
+
+	// Held between Pause and Unpause, and while the state is replaced.
+	mutex sync.Mutex
+	state *game.Game
+}
+
+// Dial connects to the server at addr, which looks like host:port, and waits
+// for the first state.
+func Dial(addr string) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	dec := gob.NewDecoder(conn)
+	var msg serverMessage
+	if err := dec.Decode(&msg); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c := &Client{
+		conn:   conn,
+		id:     msg.Id,
+		events: make(chan cgf.Event, 100),
+	}
+	// The los cache can come before the first state.
+	var losCache []byte
+	for msg.State == nil {
+		if err := dec.Decode(&msg); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if msg.LosCache != nil {
+			losCache = msg.LosCache
+		}
+	}
+	c.state, err = decodeState(msg.State)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.state.PrepareSnapshot(nil)
+	if losCache != nil {
+		c.state.LoadLosCache(losCache)
+	}
+	go c.receive(dec)
+	go c.send()
+	return c, nil
+}
+
+func (c *Client) receive(dec *gob.Decoder) {
+	for {
+		var msg serverMessage
+		if err := dec.Decode(&msg); err != nil {
+			base.Error().Printf("Statesync: lost the server: %v", err)
+			return
+		}
+		if msg.LosCache != nil {
+			c.mutex.Lock()
+			c.state.LoadLosCache(msg.LosCache)
+			c.mutex.Unlock()
+		}
+		if msg.State == nil {
+			continue
+		}
+		state, err := decodeState(msg.State)
+		if err != nil {
+			base.Error().Printf("Statesync: unable to decode the state: %v", err)
+			continue
+		}
+		c.mutex.Lock()
+		state.PrepareSnapshot(c.state)
+		c.state = state
+		c.mutex.Unlock()
+	}
+}
+
+func decodeState(data []byte) (*game.Game, error) {
+	var state game.Game
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&state)
+	return &state, err
+}
+
+func (c *Client) send() {
+	enc := gob.NewEncoder(c.conn)
+	for event := range c.events {
+		if err := enc.Encode(clientMessage{event}); err != nil {
+			base.Error().Printf("Statesync: unable to send %T: %v", event, err)
+		}
+	}
+}
+
+// ApplyEvent sends event to the server, it is applied to the state that comes
+// back.
+func (c *Client) ApplyEvent(event cgf.Event) {
+	c.events <- event
+}
+
+func (c *Client) Id() int64 {
+	return c.id
+}
+
+// Ids always returns nil, only the server knows who is connected.
+func (c *Client) Ids() []int64 {
+	return nil
+}
+
+func (c *Client) Pause() {
+	c.mutex.Lock()
+}
+
+func (c *Client) Unpause() {
+	c.mutex.Unlock()
+}
+
+// GetState returns the newest state, which is a *game.Game.  Call Pause first.
+func (c *Client) GetState() interface{} {
+	return c.state
+}
+
+func (c *Client) Kill() {
+	close(c.events)
+	c.conn.Close()
+}
@@ -0,0 +1,253 @@
+// Package statesync lets a server run the simulation by itself and send each
+// client only what that client's side can see, see game.SyncVisible.  Clients
+// send their events to the server instead of simulating them.
+//
+// Everything is gob encoded over a tcp connection.  The server first sends the
+// client its engine id, then a state every frame, dropping states that the
+// client isn't keeping up with.  Each state is encoded on its own, once for
+// every side, so that it can be dropped and so that it can be encoded before
+// the game changes.  The client sends events.
+package statesync
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"github.com/runningwild/cgf"
+	"github.com/runningwild/magnus/base"
+	"github.com/runningwild/magnus/game"
+	"net"
+	"sort"
+	"sync"
+)
+
+type serverMessage struct {
+	// Only set in the first message.
+	Id int64
+
+	// A gob encoded *game.Game.
+	State []byte
+
+	// A saved los cache, see game.LosCacheData.
+	LosCache []byte
+}
+
+type clientMessage struct {
+	Event cgf.Event
+}
+
+// An Event is an event sent by the client with engine id Id.
+type Event struct {
+	Id    int64
+	Event cgf.Event
+}
+
+type serverConn struct {
+	id   int64
+	conn net.Conn
+
+	// Holds the latest state that hasn't been sent yet, and the los cache if it
+	// hasn't been sent yet.
+	states   chan []byte
+	losCache chan []byte
+}
+
+type Server struct {
+	listener net.Listener
+
+	mutex    sync.Mutex
+	nextId   int64
+	clients  map[int64]*serverConn
+	events   []Event
+	losCache []byte
+}
+
+// Listen starts accepting clients on port.
+func Listen(port int) (*Server, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		listener: listener,
+		clients:  make(map[int64]*serverConn),
+	}
+	go s.accept()
+	return s, nil
+}
+
+func (s *Server) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			base.Log().Printf("Statesync: no longer accepting clients: %v", err)
+			return
+		}
+		s.mutex.Lock()
+		s.nextId++
+		c := &serverConn{
+			id:       s.nextId,
+			conn:     conn,
+			states:   make(chan []byte, 1),
+			losCache: make(chan []byte, 1),
+		}
+		if s.losCache != nil {
+			c.losCache <- s.losCache
+		}
+		s.clients[c.id] = c
+		s.mutex.Unlock()
+		base.Log().Printf("Statesync: engine %d connected from %v", c.id, conn.RemoteAddr())
+		go s.send(c)
+		go s.receive(c)
+	}
+}
+
+func (s *Server) send(c *serverConn) {
+	enc := gob.NewEncoder(c.conn)
+	err := enc.Encode(serverMessage{Id: c.id})
+	for {
+		var msg serverMessage
+		select {
+		case state, ok := <-c.states:
+			if !ok {
+				return
+			}
+			msg.State = state
+		case msg.LosCache = <-c.losCache:
+		}
+		if err != nil {
+			continue
+		}
+		err = enc.Encode(msg)
+		if err != nil {
+			s.drop(c, err)
+		}
+	}
+}
+
+func (s *Server) receive(c *serverConn) {
+	dec := gob.NewDecoder(c.conn)
+	for {
+		var msg clientMessage
+		if err := dec.Decode(&msg); err != nil {
+			s.drop(c, err)
+			return
+		}
+		if msg.Event == nil {
+			continue
+		}
+		s.mutex.Lock()
+		s.events = append(s.events, Event{c.id, msg.Event})
+		s.mutex.Unlock()
+	}
+}
+
+// drop disconnects c if it is still connected.
+func (s *Server) drop(c *serverConn, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.clients[c.id] != c {
+		return
+	}
+	base.Log().Printf("Statesync: engine %d disconnected: %v", c.id, err)
+	delete(s.clients, c.id)
+	close(c.states)
+	c.conn.Close()
+}
+
+// Ids returns the engine ids of every connected client.
+func (s *Server) Ids() []int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var ids []int64
+	for id := range s.clients {
+		ids = append(ids, id)
+	}
+	sort.Sort(int64Slice(ids))
+	return ids
+}
+
+// Events returns every event that has been received since the last call, in
+// the order that they were received.
+func (s *Server) Events() []Event {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	events := s.events
+	s.events = nil
+	return events
+}
+
+// Send sends every client the part of g that its side can see.  g must be
+// using game.SyncVisible and must not change until Send returns.  Clients that
+// aren't playing are only sent the state during setup.
+func (s *Server) Send(g *game.Game) {
+	if g.Sync != game.SyncVisible {
+		base.Error().Printf("Statesync: refusing to send a state that isn't using SyncVisible")
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	states := make(map[int][]byte)
+	for id, c := range s.clients {
+		side := 0
+		if g.Setup == nil {
+			data, ok := g.Engines[id]
+			if !ok {
+				continue
+			}
+			side = data.Side
+		}
+		state, ok := states[side]
+		if !ok {
+			buf := bytes.NewBuffer(nil)
+			if err := gob.NewEncoder(buf).Encode(g.StateForSide(side)); err != nil {
+				base.Error().Printf("Statesync: unable to encode the state: %v", err)
+				return
+			}
+			state = buf.Bytes()
+			states[side] = state
+		}
+		// Throw away the state that is still waiting, if any, so the client
+		// always gets the newest one.
+		select {
+		case <-c.states:
+		default:
+		}
+		c.states <- state
+	}
+}
+
+// SendLosCache sends a saved los cache to every client, and to every client
+// that connects later.
+func (s *Server) SendLosCache(data []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.losCache = data
+	for _, c := range s.clients {
+		select {
+		case <-c.losCache:
+		default:
+		}
+		c.losCache <- data
+	}
+}
+
+// Close stops accepting clients and disconnects everyone.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.mutex.Lock()
+	var clients []*serverConn
+	for _, c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mutex.Unlock()
+	for _, c := range clients {
+		s.drop(c, fmt.Errorf("server closed"))
+	}
+}
+
+type int64Slice []int64
+
+func (s int64Slice) Len() int           { return len(s) }
+func (s int64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s int64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -33,6 +33,10 @@ type Base struct {
 	// Maximum vision distance, for technical reasons this value will always be
 	// reported as LosPlayerHorizon if it ever exceeds LosPlayerHorizon.
 	Vision float64
+
+	// Cloaked ents on other sides within this distance are visible anyway, as
+	// long as there is line of sight to them.
+	Detection float64
 }
 
 type DamageKind int
@@ -109,6 +113,10 @@ func (s Inst) Vision() float64 {
 	return math.Max(0, vision)
 }
 
+func (s Inst) Detection() float64 {
+	return math.Max(0, s.ModifyBase(s.inst.Base).Detection)
+}
+
 func (s *Inst) SetHealth(health float64) {
 	s.inst.Dynamic.Health = health
 }